@@ -0,0 +1,457 @@
+package functions
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Account shows the account page where the user can download their data or delete the account.
+func (database Database) Account(w http.ResponseWriter, r *http.Request) {
+	user := CurrentUser(r)
+
+	data, err := getAccountData(database.Db, database.Content, user.Id, user.Token)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to load account", "err", err)
+		RenderError(w, errPleaseTryLater, 500)
+		return
+	}
+
+	ExecuteTemplate(w, "account.html", data, 200)
+}
+
+// AccountExport sends the user a JSON archive of their profile, posts, comments and reactions.
+func (database Database) AccountExport(w http.ResponseWriter, r *http.Request) {
+	user := CurrentUser(r)
+
+	export, err := ExportUserData(database.Db, user.Id)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to export user data", "err", err)
+		RenderError(w, errPleaseTryLater, 500)
+		return
+	}
+
+	body, err := json.MarshalIndent(export, "", "  ")
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to encode user data", "err", err)
+		RenderError(w, errPleaseTryLater, 500)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="agora-`+export.Profile.Name+`.json"`)
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write(body)
+}
+
+// AccountDelete deletes the account after checking the password, anonymising or removing its content.
+func (database Database) AccountDelete(w http.ResponseWriter, r *http.Request) {
+	user := CurrentUser(r)
+
+	mode := strings.TrimSpace(r.FormValue("mode"))
+	if mode != "anonymise" && mode != "delete" {
+		RenderError(w, "unknown deletion mode", 400)
+		return
+	}
+
+	var hashedPassword string
+	err := database.Db.QueryRow(Select_Password, user.Id).Scan(&hashedPassword)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to load password", "err", err)
+		RenderError(w, errPleaseTryLater, 500)
+		return
+	}
+
+	if bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(r.FormValue("password"))) != nil {
+		data, err := getAccountData(database.Db, database.Content, user.Id, user.Token)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "failed to load account", "err", err)
+			RenderError(w, errPleaseTryLater, 500)
+			return
+		}
+
+		data.Message = "❌ Wrong password, your account was not deleted"
+		ExecuteTemplate(w, "account.html", data, http.StatusUnauthorized)
+		return
+	}
+
+	var avatar string
+	if err := database.Db.QueryRow(Select_Avatar_By_ID, user.Id).Scan(&avatar); err != nil {
+		slog.ErrorContext(r.Context(), "failed to load avatar", "err", err)
+		RenderError(w, errPleaseTryLater, 500)
+		return
+	}
+
+	blobKeys, err := DeleteUser(database.Db, user.Id, mode == "delete")
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to delete user", "err", err)
+		RenderError(w, errPleaseTryLater, 500)
+		return
+	}
+
+	removeAvatarIfUnused(database.Db, database.AvatarDir, avatar)
+
+	for _, key := range blobKeys {
+		if err := database.Blobs.Delete(key); err != nil {
+			slog.ErrorContext(r.Context(), "failed to delete blob", "blob", key, "err", err)
+		}
+	}
+
+	RemoveCookie(w, r, database.Session)
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// AccountBio updates the short biography shown on the user's public profile.
+func (database Database) AccountBio(w http.ResponseWriter, r *http.Request) {
+	user := CurrentUser(r)
+
+	bio := strings.TrimSpace(r.FormValue("bio"))
+
+	if err := isValidBio(bio, database.Content); err != nil {
+		data, err2 := getAccountData(database.Db, database.Content, user.Id, user.Token)
+		if err2 != nil {
+			slog.ErrorContext(r.Context(), "failed to load account", "err", err2)
+			RenderError(w, errPleaseTryLater, 500)
+			return
+		}
+
+		data.Bio = bio
+		data.Message = err.Error()
+		ExecuteTemplate(w, "account.html", data, 400)
+		return
+	}
+
+	if _, err := database.Db.Exec(Update_Bio, bio, user.Id); err != nil {
+		slog.ErrorContext(r.Context(), "failed to update bio", "err", err)
+		RenderError(w, errPleaseTryLater, 500)
+		return
+	}
+
+	http.Redirect(w, r, "/account", http.StatusSeeOther)
+}
+
+// getAccountData loads what the account page needs to display.
+func getAccountData(db *sql.DB, limits ContentLimits, userID int, storedToken string) (AccountPageData, error) {
+	data := AccountPageData{Token: storedToken, Content: limits}
+
+	err := db.QueryRow(Select_Account, userID).Scan(&data.UserName, &data.Email, &data.Bio)
+	if err != nil {
+		return data, err
+	}
+
+	if err := getDigestChoices(db, userID, &data); err != nil {
+		return data, err
+	}
+
+	data.Unread = unreadCount(db, userID)
+	data.UnreadMessages = unreadMessagesCount(db, userID)
+
+	return data, nil
+}
+
+// DeleteUser removes a user inside a transaction. When hard is false, their posts and comments
+// are kept and attributed to the "[deleted]" placeholder instead.
+// It returns the keys of the attachment blobs that are not referenced anymore.
+func DeleteUser(db *sql.DB, userID int, hard bool) ([]string, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+
+	defer tx.Rollback()
+
+	blobKeys := []string{}
+
+	if hard {
+		rows, err := tx.Query(Select_User_Blob_Keys, userID)
+		if err != nil {
+			return nil, err
+		}
+
+		for rows.Next() {
+			var key string
+			if err := rows.Scan(&key); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			blobKeys = append(blobKeys, key)
+		}
+		rows.Close()
+
+		for _, query := range Hard_Delete_User {
+			if _, err := tx.Exec(query, userID); err != nil {
+				return nil, err
+			}
+		}
+	} else {
+		var deletedID int
+		if err := tx.QueryRow(Select_DeletedUserID).Scan(&deletedID); err != nil {
+			return nil, fmt.Errorf("failed to find the deleted user placeholder: %w", err)
+		}
+
+		for _, query := range Anonymise_User {
+			if _, err := tx.Exec(query, userID, deletedID); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	for _, query := range Delete_User_Rows {
+		if _, err := tx.Exec(query, userID); err != nil {
+			return nil, err
+		}
+	}
+
+	return blobKeys, tx.Commit()
+}
+
+// ExportUserData gathers everything the forum stores about a user.
+func ExportUserData(db *sql.DB, userID int) (*DataExport, error) {
+	export := &DataExport{
+		ExportedAt:    time.Now().UTC().Format(time.RFC3339),
+		Profile:       ExportProfile{Id: userID},
+		Posts:         []ExportPost{},
+		Comments:      []ExportComment{},
+		Reactions:     []ExportReaction{},
+		Bookmarks:     []ExportBookmark{},
+		Following:     []ExportFollow{},
+		Subscriptions: []ExportSubscription{},
+		Messages:      []ExportMessage{},
+		Chat:          []ExportChat{},
+	}
+
+	err := db.QueryRow(Select_Account, userID).Scan(&export.Profile.Name, &export.Profile.Email, &export.Profile.Bio)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := db.QueryRow(Select_Digest, userID).Scan(&export.Profile.Digest); err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query(Select_Digest_Categories, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	export.Profile.DigestCategories = []string{}
+	for rows.Next() {
+		var id int
+		var name string
+
+		if err := rows.Scan(&id, &name); err != nil {
+			return nil, err
+		}
+
+		export.Profile.DigestCategories = append(export.Profile.DigestCategories, name)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = db.Query(Export_Posts, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var post ExportPost
+		var createdAt time.Time
+		var categories string
+
+		if err := rows.Scan(&post.Id, &post.Title, &post.Content, &createdAt, &categories); err != nil {
+			return nil, err
+		}
+
+		post.CreatedAt = createdAt.UTC().Format(time.RFC3339)
+		post.Categories = []string{}
+		if categories != "" {
+			post.Categories = strings.Split(categories, ",")
+		}
+
+		export.Posts = append(export.Posts, post)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for i := range export.Posts {
+		post := Post{Id: export.Posts[i].Id}
+		if err := getPostAttachments(&post, db); err != nil {
+			return nil, err
+		}
+
+		export.Posts[i].Attachments = []ExportAttachment{}
+		for _, attachment := range post.Attachments {
+			export.Posts[i].Attachments = append(export.Posts[i].Attachments, ExportAttachment{
+				Name: attachment.Name,
+				Type: attachment.Mime,
+				Size: attachment.Size,
+				URL:  "/attachments/" + strconv.Itoa(attachment.Id),
+			})
+		}
+	}
+
+	rows, err = db.Query(Export_Comments, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var comment ExportComment
+		var createdAt time.Time
+
+		if err := rows.Scan(&comment.Id, &comment.PostId, &comment.Content, &createdAt); err != nil {
+			return nil, err
+		}
+
+		comment.CreatedAt = createdAt.UTC().Format(time.RFC3339)
+		export.Comments = append(export.Comments, comment)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = db.Query(Export_Reactions, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var reaction ExportReaction
+		var createdAt time.Time
+
+		if err := rows.Scan(&reaction.PostId, &reaction.CommentId, &reaction.Like, &createdAt); err != nil {
+			return nil, err
+		}
+
+		reaction.CreatedAt = createdAt.UTC().Format(time.RFC3339)
+		export.Reactions = append(export.Reactions, reaction)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = db.Query(Export_Bookmarks, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var bookmark ExportBookmark
+		var createdAt time.Time
+
+		if err := rows.Scan(&bookmark.PostId, &createdAt); err != nil {
+			return nil, err
+		}
+
+		bookmark.CreatedAt = createdAt.UTC().Format(time.RFC3339)
+		export.Bookmarks = append(export.Bookmarks, bookmark)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = db.Query(Export_Following, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var follow ExportFollow
+		var createdAt time.Time
+
+		if err := rows.Scan(&follow.Name, &createdAt); err != nil {
+			return nil, err
+		}
+
+		follow.CreatedAt = createdAt.UTC().Format(time.RFC3339)
+		export.Following = append(export.Following, follow)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = db.Query(Export_Subscriptions, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var subscription ExportSubscription
+		var createdAt time.Time
+
+		if err := rows.Scan(&subscription.PostId, &subscription.Email, &createdAt); err != nil {
+			return nil, err
+		}
+
+		subscription.CreatedAt = createdAt.UTC().Format(time.RFC3339)
+		export.Subscriptions = append(export.Subscriptions, subscription)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = db.Query(Export_Messages, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var message ExportMessage
+		var createdAt time.Time
+
+		if err := rows.Scan(&message.To, &message.Content, &createdAt); err != nil {
+			return nil, err
+		}
+
+		message.CreatedAt = createdAt.UTC().Format(time.RFC3339)
+		export.Messages = append(export.Messages, message)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = db.Query(Export_Chat_Messages, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var chat ExportChat
+		var createdAt time.Time
+
+		if err := rows.Scan(&chat.Room, &chat.Content, &createdAt); err != nil {
+			return nil, err
+		}
+
+		chat.CreatedAt = createdAt.UTC().Format(time.RFC3339)
+		export.Chat = append(export.Chat, chat)
+	}
+
+	return export, rows.Err()
+}
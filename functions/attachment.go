@@ -0,0 +1,266 @@
+package functions
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// AttachmentLimits controls which files can be attached to a post.
+type AttachmentLimits struct {
+	MaxFileSize  int64    // in bytes, for each file
+	MaxFiles     int      // per post
+	AllowedTypes []string // media types, detected from the content of the file
+}
+
+// DefaultAttachmentLimits allows a few screenshots and documents per post.
+var DefaultAttachmentLimits = AttachmentLimits{
+	MaxFileSize: 5 << 20,
+	MaxFiles:    5,
+	AllowedTypes: []string{
+		"image/png",
+		"image/jpeg",
+		"image/gif",
+		"image/webp",
+		"application/pdf",
+		"text/plain",
+		"application/zip",
+	},
+}
+
+// MaxRequestSize is the biggest post form body accepted: all the files plus room for the text fields.
+func (limits AttachmentLimits) MaxRequestSize() int64 {
+	return limits.MaxFileSize*int64(limits.MaxFiles) + 1<<20
+}
+
+// MaxFileSizeText is MaxFileSize written for humans.
+func (limits AttachmentLimits) MaxFileSizeText() string {
+	return humanSize(limits.MaxFileSize)
+}
+
+func (limits AttachmentLimits) allows(mediaType string) bool {
+	for _, allowed := range limits.AllowedTypes {
+		if allowed == mediaType {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Attachment serves /attachments/{id}: images are shown inline, every other file is downloaded.
+func (database Database) Attachment(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r, "id")
+	if err != nil {
+		RenderError(w, errPageNotFound, 404)
+		return
+	}
+
+	var attachment Attachment
+	err = database.Db.QueryRow(Select_Attachment, id).Scan(&attachment.Name, &attachment.Mime, &attachment.Size, &attachment.BlobKey)
+	if err == sql.ErrNoRows {
+		RenderError(w, "this file doesn't exist", 404)
+		return
+	}
+
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to load attachment", "err", err)
+		RenderError(w, errPleaseTryLater, 500)
+		return
+	}
+
+	blob, err := database.Blobs.Open(attachment.BlobKey)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to open attachment", "err", err)
+		RenderError(w, errPleaseTryLater, 500)
+		return
+	}
+	defer blob.Close()
+
+	disposition := "attachment"
+	if strings.HasPrefix(attachment.Mime, "image/") {
+		disposition = "inline"
+	}
+
+	w.Header().Set("Content-Type", attachment.Mime)
+	w.Header().Set("Content-Length", strconv.FormatInt(attachment.Size, 10))
+	w.Header().Set("Content-Disposition", mime.FormatMediaType(disposition, map[string]string{"filename": attachment.Name}))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	// opened on their own, the files can't run scripts with the forum's origin
+	w.Header().Set("Content-Security-Policy", "default-src 'none'; style-src 'unsafe-inline'; sandbox")
+	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+
+	if r.Method == http.MethodHead {
+		return
+	}
+
+	io.Copy(w, blob)
+}
+
+// getAttachmentFiles checks the files of the post form against the limits, sniffing their real type.
+func getAttachmentFiles(form *multipart.Form, limits AttachmentLimits) ([]Attachment, []*multipart.FileHeader, error) {
+	if form == nil || len(form.File["attachments"]) == 0 {
+		return nil, nil, nil
+	}
+
+	headers := []*multipart.FileHeader{}
+	for _, header := range form.File["attachments"] {
+		// an empty file input still sends a part without a file name
+		if header.Filename == "" && header.Size == 0 {
+			continue
+		}
+		headers = append(headers, header)
+	}
+
+	if len(headers) > limits.MaxFiles {
+		return nil, nil, fmt.Errorf("you can attach at most %d files", limits.MaxFiles)
+	}
+
+	attachments := []Attachment{}
+
+	for _, header := range headers {
+		name := filepath.Base(strings.ReplaceAll(header.Filename, "\\", "/"))
+		if name == "" || name == "." || name == "/" || len(name) > 255 || !IsPrintable(name) {
+			return nil, nil, errors.New("invalid file name")
+		}
+
+		if header.Size == 0 {
+			return nil, nil, fmt.Errorf("%s is empty", name)
+		}
+
+		if header.Size > limits.MaxFileSize {
+			return nil, nil, fmt.Errorf("%s is bigger than %s", name, limits.MaxFileSizeText())
+		}
+
+		mediaType, err := sniffType(header)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if !limits.allows(mediaType) {
+			return nil, nil, fmt.Errorf("%s: this type of file is not allowed", name)
+		}
+
+		attachments = append(attachments, Attachment{Name: name, Mime: mediaType, Size: header.Size})
+	}
+
+	return attachments, headers, nil
+}
+
+// sniffType detects the media type of an uploaded file from its first bytes.
+func sniffType(header *multipart.FileHeader) (string, error) {
+	file, err := header.Open()
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	head := make([]byte, 512)
+	n, err := io.ReadFull(file, head)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return "", err
+	}
+
+	mediaType, _, err := mime.ParseMediaType(http.DetectContentType(head[:n]))
+	if err != nil {
+		return "", err
+	}
+
+	return mediaType, nil
+}
+
+// storeAttachments saves the uploaded files in the blob store and fills their BlobKey.
+// If one of them fails, the ones already stored are deleted.
+func storeAttachments(blobs BlobStore, attachments []Attachment, headers []*multipart.FileHeader) error {
+	for i, header := range headers {
+		key, err := GenerateToken()
+		if err != nil {
+			deleteBlobs(blobs, attachments[:i])
+			return err
+		}
+
+		file, err := header.Open()
+		if err != nil {
+			deleteBlobs(blobs, attachments[:i])
+			return err
+		}
+
+		err = blobs.Put(key, file)
+		file.Close()
+		if err != nil {
+			deleteBlobs(blobs, attachments[:i])
+			return err
+		}
+
+		attachments[i].BlobKey = key
+	}
+
+	return nil
+}
+
+// deleteBlobs removes the stored files of the given attachments.
+func deleteBlobs(blobs BlobStore, attachments []Attachment) {
+	for _, attachment := range attachments {
+		if err := blobs.Delete(attachment.BlobKey); err != nil {
+			slog.Error("failed to delete blob", "blob", attachment.BlobKey, "err", err)
+		}
+	}
+}
+
+// insertAttachments links the stored files to the post.
+func insertAttachments(tx *sql.Tx, postID int, attachments []Attachment) error {
+	for _, attachment := range attachments {
+		_, err := tx.Exec(Insert_Attachment, postID, attachment.Name, attachment.Mime, attachment.Size, attachment.BlobKey)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// getPostAttachments loads the files attached to a post.
+func getPostAttachments(post *Post, db *sql.DB) error {
+	rows, err := db.Query(Select_Post_Attachments, post.Id)
+	if err != nil {
+		return err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var attachment Attachment
+
+		err := rows.Scan(&attachment.Id, &attachment.Name, &attachment.Mime, &attachment.Size)
+		if err != nil {
+			return err
+		}
+
+		attachment.IsImage = strings.HasPrefix(attachment.Mime, "image/")
+		attachment.SizeText = humanSize(attachment.Size)
+
+		post.Attachments = append(post.Attachments, attachment)
+	}
+
+	return rows.Err()
+}
+
+// humanSize formats a number of bytes like 12 B, 3.4 KB or 5.0 MB.
+func humanSize(size int64) string {
+	switch {
+	case size < 1<<10:
+		return fmt.Sprintf("%d B", size)
+	case size < 1<<20:
+		return fmt.Sprintf("%.1f KB", float64(size)/(1<<10))
+	default:
+		return fmt.Sprintf("%.1f MB", float64(size)/(1<<20))
+	}
+}
@@ -0,0 +1,149 @@
+package functions
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+type userKey struct{}
+
+// Authenticate resolves the session cookie of every request into the User of its context.
+// The requests without a valid session have no user, and their stale cookie is removed.
+func (database Database) Authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cookie, err := r.Cookie("session")
+		if err != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		var user User
+		err = database.Db.QueryRow(Select_Session_User, cookie.Value).Scan(&user.Id, &user.Token, &user.Name, &user.Role)
+		if err == sql.ErrNoRows {
+			// the session expired, or another login replaced it
+			if _, err := database.Db.Exec(Delete_Session_by_ID, cookie.Value); err != nil {
+				slog.ErrorContext(r.Context(), "failed to remove a stale session", "err", err)
+			}
+
+			RemoveCookie(w, r, database.Session)
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if err != nil {
+			slog.ErrorContext(r.Context(), "failed to load the session", "err", err)
+			RenderError(w, errPleaseTryLater, 500)
+			return
+		}
+
+		if info := requestInfoOf(r.Context()); info != nil {
+			info.userID = user.Id
+		}
+
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), userKey{}, &user)))
+	})
+}
+
+// CurrentUser returns the logged in user of the request, nil for a visitor.
+func CurrentUser(r *http.Request) *User {
+	user, _ := r.Context().Value(userKey{}).(*User)
+	return user
+}
+
+// sessionOf returns the id and the CSRF token of the user of the request, 0 and "" for a visitor.
+func sessionOf(r *http.Request) (int, string) {
+	user := CurrentUser(r)
+	if user == nil {
+		return 0, ""
+	}
+
+	return user.Id, user.Token
+}
+
+// RequireLogin sends the visitors to the login page, or answers 401 to their WebSockets.
+func RequireLogin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if CurrentUser(r) == nil {
+			if strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+				RenderError(w, "log in first", http.StatusUnauthorized)
+				return
+			}
+
+			http.Redirect(w, r, "/login", http.StatusSeeOther)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RequireRole only lets through the users who have role, the visitors are sent to the login page.
+func RequireRole(role string) Middleware {
+	return func(next http.Handler) http.Handler {
+		return RequireLogin(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !hasRole(CurrentUser(r).Role, role) {
+				RenderError(w, "Forbidden: only the "+role+"s can do this", http.StatusForbidden)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		}))
+	}
+}
+
+// CheckCSRF refuses the POST, PUT, PATCH and DELETE requests of a logged in user without their
+// CSRF token in the csrf_token field. The paths of exempt don't act for the logged in user:
+// the login, or links signed for one user.
+func CheckCSRF(exempt ...string) Middleware {
+	skip := map[string]bool{}
+	for _, path := range exempt {
+		skip[path] = true
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			user := CurrentUser(r)
+			if user == nil || skip[r.URL.Path] || isSafeMethod(r.Method) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			if err := parseForm(r); err != nil {
+				var tooBig *http.MaxBytesError
+				if errors.As(err, &tooBig) {
+					RenderError(w, "this request is too big", http.StatusRequestEntityTooLarge)
+					return
+				}
+
+				RenderError(w, "bad request", 400)
+				return
+			}
+
+			if !ValidCSRF(r, user.Token) {
+				RenderError(w, "Forbidden: CSRF Token Invalid", http.StatusForbidden)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// isSafeMethod tells if the method only reads.
+func isSafeMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+}
+
+// parseForm reads the form of the body, multipart or not, the files go to disk past 32MB.
+func parseForm(r *http.Request) error {
+	err := r.ParseMultipartForm(32 << 20)
+	if err == http.ErrNotMultipart {
+		err = r.ParseForm()
+	}
+
+	return err
+}
@@ -0,0 +1,303 @@
+package functions
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/gif"
+	_ "image/jpeg"
+	"image/png"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+const (
+	maxAvatarUpload = 5 << 20
+	maxAvatarPixels = 4096
+	// the URL of an avatar stays the same after an upload, browsers check the ETag every time instead
+	avatarCacheControl = "no-cache"
+)
+
+// AvatarRequestSize is the biggest avatar form body accepted: the image plus room for the other fields.
+const AvatarRequestSize = maxAvatarUpload + 1<<20
+
+// AvatarSizes are the square sizes (in pixels) every avatar is stored at.
+var AvatarSizes = []int{48, 128}
+
+var allowedAvatarTypes = map[string]bool{
+	"image/png":  true,
+	"image/jpeg": true,
+	"image/gif":  true,
+}
+
+// Avatar serves /avatars/{name}/{size}: the uploaded avatar of the user or a generated identicon.
+func (database Database) Avatar(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	size, err := strconv.Atoi(r.PathValue("size"))
+	if err != nil || !isAvatarSize(size) {
+		RenderError(w, errPageNotFound, 404)
+		return
+	}
+
+	var hash string
+	err = database.Db.QueryRow(Select_Avatar, name).Scan(&hash)
+	if err == sql.ErrNoRows {
+		RenderError(w, "this user doesn't exist", 404)
+		return
+	}
+
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to load avatar", "err", err)
+		RenderError(w, errPleaseTryLater, 500)
+		return
+	}
+
+	var content []byte
+	var etag string
+
+	if hash != "" {
+		content, err = os.ReadFile(avatarPath(database.AvatarDir, hash, size))
+		etag = `"` + hash + "-" + strconv.Itoa(size) + `"`
+	} else {
+		content, err = Identicon(name, size)
+		sum := sha256.Sum256([]byte(name))
+		etag = `"id-` + hex.EncodeToString(sum[:8]) + "-" + strconv.Itoa(size) + `"`
+	}
+
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to read avatar", "err", err)
+		RenderError(w, errPleaseTryLater, 500)
+		return
+	}
+
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("Cache-Control", avatarCacheControl)
+	w.Header().Set("ETag", etag)
+	http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(content))
+}
+
+// AccountAvatar replaces or removes the avatar of the logged in user.
+func (database Database) AccountAvatar(w http.ResponseWriter, r *http.Request) {
+	user := CurrentUser(r)
+
+	if err := r.ParseMultipartForm(maxAvatarUpload); err != nil {
+		RenderError(w, "the avatar must be smaller than 5MB", http.StatusRequestEntityTooLarge)
+		return
+	}
+	defer r.MultipartForm.RemoveAll()
+
+	var oldHash string
+	if err := database.Db.QueryRow(Select_Avatar_By_ID, user.Id).Scan(&oldHash); err != nil {
+		slog.ErrorContext(r.Context(), "failed to load avatar", "err", err)
+		RenderError(w, errPleaseTryLater, 500)
+		return
+	}
+
+	newHash := ""
+
+	if r.FormValue("action") != "remove" {
+		var err error
+		newHash, err = saveAvatar(r, database.AvatarDir)
+		if err != nil {
+			data, err2 := getAccountData(database.Db, database.Content, user.Id, user.Token)
+			if err2 != nil {
+				slog.ErrorContext(r.Context(), "failed to load account", "err", err2)
+				RenderError(w, errPleaseTryLater, 500)
+				return
+			}
+
+			data.Message = err.Error()
+			ExecuteTemplate(w, "account.html", data, 400)
+			return
+		}
+	}
+
+	if _, err := database.Db.Exec(Update_Avatar, newHash, user.Id); err != nil {
+		slog.ErrorContext(r.Context(), "failed to update avatar", "err", err)
+		RenderError(w, errPleaseTryLater, 500)
+		return
+	}
+
+	if oldHash != newHash {
+		removeAvatarIfUnused(database.Db, database.AvatarDir, oldHash)
+	}
+
+	http.Redirect(w, r, "/account", http.StatusSeeOther)
+}
+
+// saveAvatar validates the uploaded image by its content, then stores it cropped and resized
+// to every AvatarSizes in dir, under a path derived from its hash. Re-encoding drops any metadata.
+func saveAvatar(r *http.Request, dir string) (string, error) {
+	file, header, err := r.FormFile("avatar")
+	if err != nil {
+		return "", errors.New("please choose an image")
+	}
+	defer file.Close()
+
+	if header.Size > maxAvatarUpload {
+		return "", errors.New("the avatar must be smaller than 5MB")
+	}
+
+	upload, err := io.ReadAll(file)
+	if err != nil {
+		return "", errors.New("failed to read the image")
+	}
+
+	if !allowedAvatarTypes[http.DetectContentType(upload)] {
+		return "", errors.New("only PNG, JPEG and GIF images are allowed")
+	}
+
+	config, _, err := image.DecodeConfig(bytes.NewReader(upload))
+	if err != nil {
+		return "", errors.New("this image is damaged")
+	}
+
+	if config.Width > maxAvatarPixels || config.Height > maxAvatarPixels {
+		return "", fmt.Errorf("the image must be at most %dx%d pixels", maxAvatarPixels, maxAvatarPixels)
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(upload))
+	if err != nil {
+		return "", errors.New("this image is damaged")
+	}
+
+	sum := sha256.Sum256(upload)
+	hash := hex.EncodeToString(sum[:])
+
+	for _, size := range AvatarSizes {
+		path := avatarPath(dir, hash, size)
+		if _, err := os.Stat(path); err == nil {
+			continue
+		}
+
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, cropAndResize(img, size)); err != nil {
+			return "", err
+		}
+
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			return "", err
+		}
+
+		if err := os.WriteFile(path, buf.Bytes(), 0o644); err != nil {
+			return "", err
+		}
+	}
+
+	return hash, nil
+}
+
+// removeAvatarIfUnused deletes the stored files of an avatar nobody uses anymore.
+func removeAvatarIfUnused(db *sql.DB, dir, hash string) {
+	if hash == "" {
+		return
+	}
+
+	var users int
+	if err := db.QueryRow(Count_Avatar_Users, hash).Scan(&users); err != nil || users > 0 {
+		return
+	}
+
+	for _, size := range AvatarSizes {
+		os.Remove(avatarPath(dir, hash, size))
+	}
+}
+
+// avatarPath returns where an avatar of the given hash and size is stored in dir.
+func avatarPath(dir, hash string, size int) string {
+	return filepath.Join(dir, hash[:2], hash+"-"+strconv.Itoa(size)+".png")
+}
+
+func isAvatarSize(size int) bool {
+	for _, allowed := range AvatarSizes {
+		if size == allowed {
+			return true
+		}
+	}
+
+	return false
+}
+
+// cropAndResize crops the centered square of img and scales it to size x size,
+// averaging all the source pixels that fall in each destination pixel.
+func cropAndResize(img image.Image, size int) *image.NRGBA {
+	bounds := img.Bounds()
+	side := min(bounds.Dx(), bounds.Dy())
+	left := bounds.Min.X + (bounds.Dx()-side)/2
+	top := bounds.Min.Y + (bounds.Dy()-side)/2
+
+	dst := image.NewNRGBA(image.Rect(0, 0, size, size))
+
+	for y := 0; y < size; y++ {
+		y0 := top + y*side/size
+		y1 := max(top+(y+1)*side/size, y0+1)
+
+		for x := 0; x < size; x++ {
+			x0 := left + x*side/size
+			x1 := max(left+(x+1)*side/size, x0+1)
+
+			var r, g, b, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					cr, cg, cb, ca := img.At(sx, sy).RGBA()
+					r, g, b, a = r+uint64(cr), g+uint64(cg), b+uint64(cb), a+uint64(ca)
+					n++
+				}
+			}
+
+			dst.Set(x, y, color.RGBA64{uint16(r / n), uint16(g / n), uint16(b / n), uint16(a / n)})
+		}
+	}
+
+	return dst
+}
+
+// Identicon draws a symmetric 5x5 pattern derived from the name, for users without an avatar.
+func Identicon(name string, size int) ([]byte, error) {
+	sum := sha256.Sum256([]byte(name))
+	foreground := color.NRGBA{R: 60 + sum[0]%160, G: 60 + sum[1]%160, B: 60 + sum[2]%160, A: 255}
+	background := color.NRGBA{R: 240, G: 240, B: 240, A: 255}
+
+	img := image.NewNRGBA(image.Rect(0, 0, size, size))
+	cell := size / 6
+	margin := (size - cell*5) / 2
+
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			img.Set(x, y, background)
+		}
+	}
+
+	for row := 0; row < 5; row++ {
+		for col := 0; col < 3; col++ {
+			if sum[3+row*3+col]%2 == 0 {
+				continue
+			}
+
+			for _, c := range []int{col, 4 - col} {
+				for y := margin + row*cell; y < margin+(row+1)*cell; y++ {
+					for x := margin + c*cell; x < margin+(c+1)*cell; x++ {
+						img.Set(x, y, foreground)
+					}
+				}
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,59 @@
+package functions
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestAvatarRevalidated(t *testing.T) {
+	database := newTestDatabase(t)
+	alice := addTestUser(t, database, "alice")
+
+	router := newTestRouter(database)
+	router.HandleFunc("GET /avatars/{name}/{size}", database.Avatar)
+
+	get := func(etag string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/avatars/alice/48", nil)
+		if etag != "" {
+			r.Header.Set("If-None-Match", etag)
+		}
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		return w
+	}
+
+	first := get("")
+	if first.Code != http.StatusOK || first.Header().Get("Cache-Control") != "no-cache" {
+		t.Fatalf("the identicon answered %d with Cache-Control %q", first.Code, first.Header().Get("Cache-Control"))
+	}
+
+	etag := first.Header().Get("ETag")
+	if w := get(etag); w.Code != http.StatusNotModified {
+		t.Errorf("the unchanged avatar answered %d", w.Code)
+	}
+
+	// a new upload, stored like saveAvatar does
+	hash := "ab0123456789"
+	path := avatarPath(database.AvatarDir, hash, 48)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte("new avatar"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := database.Db.Exec(Update_Avatar, hash, alice.Id); err != nil {
+		t.Fatal(err)
+	}
+
+	w := get(etag)
+	if w.Code != http.StatusOK || w.Body.String() != "new avatar" {
+		t.Errorf("the new avatar answered %d with %q to the old ETag", w.Code, w.Body.String())
+	}
+	if w.Header().Get("ETag") == etag {
+		t.Error("the new avatar has the ETag of the old one")
+	}
+}
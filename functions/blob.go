@@ -0,0 +1,81 @@
+package functions
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// BlobStore keeps uploaded files. Keys are generated by the forum, never taken from user input.
+type BlobStore interface {
+	Put(key string, content io.Reader) error
+	Open(key string) (io.ReadCloser, error)
+	Delete(key string) error
+}
+
+// DiskBlobStore is a BlobStore saving every blob as a file under Root.
+type DiskBlobStore struct {
+	Root string
+}
+
+// Put writes the blob to a temporary file first so a failed upload never leaves a partial blob.
+func (store DiskBlobStore) Put(key string, content io.Reader) error {
+	path, err := store.path(key)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := io.Copy(tmp, content); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+func (store DiskBlobStore) Open(key string) (io.ReadCloser, error) {
+	path, err := store.path(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return os.Open(path)
+}
+
+func (store DiskBlobStore) Delete(key string) error {
+	path, err := store.path(key)
+	if err != nil {
+		return err
+	}
+
+	err = os.Remove(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+
+	return err
+}
+
+// path spreads the blobs in sub-directories named after the first two characters of their key.
+func (store DiskBlobStore) path(key string) (string, error) {
+	if len(key) < 3 || key != filepath.Base(key) {
+		return "", errors.New("invalid blob key")
+	}
+
+	return filepath.Join(store.Root, key[:2], key), nil
+}
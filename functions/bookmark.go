@@ -0,0 +1,38 @@
+package functions
+
+import (
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+// Bookmark saves a post for later or removes it from the saved posts,
+// and redirects back to the source page. Bookmarks are private: only their owner sees them.
+func (database Database) Bookmark(w http.ResponseWriter, r *http.Request) {
+	user := CurrentUser(r)
+
+	postId := getTargetId("post", strings.TrimSpace(r.FormValue("id")), w, database.Db)
+	if postId < 1 {
+		return
+	}
+
+	var saved int
+	if err := database.Db.QueryRow(Select_Bookmarked, postId, user.Id).Scan(&saved); err != nil {
+		slog.ErrorContext(r.Context(), "failed to load bookmark", "err", err)
+		RenderError(w, errPleaseTryLater, 500)
+		return
+	}
+
+	query := Insert_Bookmark
+	if saved > 0 {
+		query = Delete_Bookmark
+	}
+
+	if _, err := database.Db.Exec(query, user.Id, postId); err != nil {
+		slog.ErrorContext(r.Context(), "failed to toggle bookmark", "err", err)
+		RenderError(w, errPleaseTryLater, 500)
+		return
+	}
+
+	Redirect("post", postId, w, r, database.Db)
+}
@@ -0,0 +1,432 @@
+package functions
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	chatBackfill       = 50               // messages sent to a browser when it joins a room
+	chatMaxMessage     = 4096             // bytes, for one WebSocket message
+	chatIdleTimeout    = 70 * time.Second // without any frame from the browser
+	chatPingInterval   = 30 * time.Second
+	chatMessagesBurst  = 5               // messages a connection can send at once
+	chatMessageEvery   = 2 * time.Second // then one message every chatMessageEvery
+	chatDefaultMinutes = 10              // of a mute or a kick, when the moderator gives none
+	chatMaxMinutes     = 24 * 60
+)
+
+// ChatTopic is the hub topic of the chat room of a category.
+func ChatTopic(roomID int) string {
+	return "chat:" + strconv.Itoa(roomID)
+}
+
+// rateLimiter is a token bucket: burst tokens, one more every interval.
+type rateLimiter struct {
+	tokens   float64
+	burst    float64
+	interval time.Duration
+	last     time.Time
+}
+
+func newRateLimiter(burst int, interval time.Duration) *rateLimiter {
+	return &rateLimiter{tokens: float64(burst), burst: float64(burst), interval: interval, last: time.Now()}
+}
+
+// allow takes a token if there is one.
+func (limiter *rateLimiter) allow() bool {
+	now := time.Now()
+	limiter.tokens = min(limiter.burst, limiter.tokens+float64(now.Sub(limiter.last))/float64(limiter.interval))
+	limiter.last = now
+
+	if limiter.tokens < 1 {
+		return false
+	}
+
+	limiter.tokens--
+	return true
+}
+
+// Chat serves the chat rooms: the list on /chat and a room page on /chat/{id}.
+func (database Database) Chat(w http.ResponseWriter, r *http.Request) {
+	user := CurrentUser(r)
+
+	data := ChatPageData{
+		UserName:       user.Name,
+		Token:          user.Token,
+		Unread:         unreadCount(database.Db, user.Id),
+		UnreadMessages: unreadMessagesCount(database.Db, user.Id),
+		Moderator:      isModerator(user.Role),
+		Content:        database.Content,
+	}
+
+	var err error
+	data.Rooms, err = getRooms(database.Db)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to load chat rooms", "err", err)
+		RenderError(w, errPleaseTryLater, 500)
+		return
+	}
+
+	if r.PathValue("id") != "" {
+		var found bool
+		data.Room, found = database.chatRoom(w, r)
+		if !found {
+			return
+		}
+	}
+
+	ExecuteTemplate(w, "chat.html", data, 200)
+}
+
+// ChatSocket runs the WebSocket of the room /chat/{id}/ws for the logged in user.
+func (database Database) ChatSocket(w http.ResponseWriter, r *http.Request) {
+	user := CurrentUser(r)
+
+	room, found := database.chatRoom(w, r)
+	if !found {
+		return
+	}
+
+	database.chatConnection(w, r, room, user.Id, user.Name, user.Role)
+}
+
+// chatRoom loads the room {id} of the path, or answers 404 when it doesn't exist.
+func (database Database) chatRoom(w http.ResponseWriter, r *http.Request) (ChatRoom, bool) {
+	var room ChatRoom
+
+	roomID, err := pathID(r, "id")
+	if err != nil {
+		RenderError(w, errPageNotFound, 404)
+		return room, false
+	}
+
+	err = database.Db.QueryRow(Select_Room, roomID).Scan(&room.Id, &room.Name)
+	if err == sql.ErrNoRows {
+		RenderError(w, "this room doesn't exist", 404)
+		return room, false
+	}
+
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to load chat room", "err", err)
+		RenderError(w, errPleaseTryLater, 500)
+		return room, false
+	}
+
+	return room, true
+}
+
+// chatConnection runs the WebSocket of one browser in a room until it leaves, is kicked or is too slow.
+func (database Database) chatConnection(w http.ResponseWriter, r *http.Request, room ChatRoom, userID int, name, role string) {
+	// the session cookie is sent by any page opening a WebSocket, only ours may use it
+	if origin := r.Header.Get("Origin"); origin != "" {
+		parsed, err := url.Parse(origin)
+		if err != nil || parsed.Host != r.Host {
+			RenderError(w, "Forbidden: cross-origin WebSocket", http.StatusForbidden)
+			return
+		}
+	}
+
+	// a kick keeps the user out of the room for a while, checked before the upgrade to answer with a status
+	until, err := database.chatUntil(Select_Chat_Ban, room.Id, userID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to check chat kick", "err", err)
+		RenderError(w, errPleaseTryLater, 500)
+		return
+	}
+
+	if time.Now().Before(until) {
+		RenderError(w, "you were kicked from this room until "+until.Local().Format("15:04"), http.StatusForbidden)
+		return
+	}
+
+	subscriber, err := database.Hub.Subscribe(ChatTopic(room.Id))
+	if err != nil {
+		w.Header().Set("Retry-After", "30")
+		RenderError(w, errPleaseTryLater, http.StatusServiceUnavailable)
+		return
+	}
+
+	defer database.Hub.Unsubscribe(subscriber)
+
+	conn, err := upgradeWebSocket(w, r, chatMaxMessage)
+	if err != nil {
+		return
+	}
+
+	defer conn.Close(wsCloseGoingAway, "")
+
+	if err := sendChatBackfill(database.Db, conn, room.Id); err != nil {
+		slog.ErrorContext(r.Context(), "failed to send chat history", "err", err)
+		return
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+
+	go chatWriter(conn, subscriber, userID, done)
+
+	limiter := newRateLimiter(chatMessagesBurst, chatMessageEvery)
+
+	for {
+		conn.conn.SetReadDeadline(time.Now().Add(chatIdleTimeout))
+
+		opcode, payload, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+
+		var command ChatCommand
+		if opcode != wsText || json.Unmarshal(payload, &command) != nil {
+			conn.Close(wsClosePolicy, "invalid message")
+			return
+		}
+
+		if !limiter.allow() {
+			sendChatEvent(conn, ChatEvent{Type: "error", Content: "slow down, you are sending messages too fast"})
+			continue
+		}
+
+		var problem string
+
+		switch command.Type {
+		case "message":
+			problem = database.chatMessage(room.Id, userID, name, command.Content)
+		case "kick", "mute", "unmute":
+			problem = database.chatModerate(room.Id, name, role, command)
+		default:
+			problem = "unknown command"
+		}
+
+		if problem != "" {
+			sendChatEvent(conn, ChatEvent{Type: "error", Content: problem})
+		}
+	}
+}
+
+// chatWriter sends the events of the room to the browser and pings it, until done is closed.
+func chatWriter(conn *wsConn, subscriber *Subscriber, userID int, done chan struct{}) {
+	ping := time.NewTicker(chatPingInterval)
+	defer ping.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+
+		case <-ping.C:
+			if err := conn.Ping(); err != nil {
+				conn.Close(wsCloseGoingAway, "")
+				return
+			}
+
+		case event, ok := <-subscriber.Events:
+			if !ok {
+				// evicted by the hub or closed by the shutdown, the browser reconnects and gets the backfill
+				if subscriber.closed {
+					conn.Close(wsCloseGoingAway, "server restarting")
+				} else {
+					conn.Close(wsClosePolicy, "too slow")
+				}
+				return
+			}
+
+			chat := event.Data.(ChatEvent)
+
+			if chat.Type == "kicked" {
+				if chat.UserId == userID {
+					sendChatEvent(conn, chat)
+					conn.Close(wsClosePolicy, "kicked")
+					return
+				}
+				continue
+			}
+
+			if err := sendChatEvent(conn, chat); err != nil {
+				conn.Close(wsCloseGoingAway, "")
+				return
+			}
+		}
+	}
+}
+
+// chatMessage stores a message and broadcasts it to the room, it returns why it was refused.
+func (database Database) chatMessage(roomID, userID int, name, content string) string {
+	content = strings.TrimSpace(strings.ReplaceAll(content, "\r\n", "\n"))
+
+	if err := isValidMessage(content, database.Content); err != nil {
+		return err.Error()
+	}
+
+	until, err := database.chatUntil(Select_Chat_Mute, roomID, userID)
+	if err != nil {
+		slog.Error("failed to check chat mute", "err", err)
+		return errPleaseTryLater
+	}
+
+	if time.Now().Before(until) {
+		return "you are muted in this room until " + until.Local().Format("15:04")
+	}
+
+	result, err := database.Db.Exec(Insert_Chat_Message, roomID, userID, content)
+	if err != nil {
+		slog.Error("failed to store chat message", "err", err)
+		return errPleaseTryLater
+	}
+
+	id, _ := result.LastInsertId()
+
+	database.Hub.Publish(ChatTopic(roomID), "chat", ChatEvent{
+		Type:    "message",
+		Id:      int(id),
+		Author:  name,
+		Content: content,
+		Date:    time.Now().Format("15:04"),
+	})
+
+	return ""
+}
+
+// chatModerate kicks, mutes or unmutes a user of the room, only moderators can and only users can be targeted.
+func (database Database) chatModerate(roomID int, name, role string, command ChatCommand) string {
+	if !isModerator(role) {
+		return "only moderators can do that"
+	}
+
+	var targetID int
+	var targetRole string
+	err := database.Db.QueryRow(Select_User_Role_ByName, command.User).Scan(&targetID, &targetRole)
+	if err == sql.ErrNoRows {
+		return "this user doesn't exist"
+	}
+
+	if err != nil {
+		slog.Error("failed to load chat user", "err", err)
+		return errPleaseTryLater
+	}
+
+	if isModerator(targetRole) {
+		return "moderators can't be kicked or muted"
+	}
+
+	minutes := command.Minutes
+	if minutes < 1 || minutes > chatMaxMinutes {
+		minutes = chatDefaultMinutes
+	}
+	until := time.Now().Add(time.Duration(minutes) * time.Minute)
+
+	var notice string
+
+	switch command.Type {
+	case "kick":
+		// stored first, so the browser can't reconnect before the ban exists
+		if _, err := database.Db.Exec(Upsert_Chat_Ban, roomID, targetID, until); err != nil {
+			slog.Error("failed to kick chat user", "err", err)
+			return errPleaseTryLater
+		}
+
+		database.Hub.Publish(ChatTopic(roomID), "chat", ChatEvent{Type: "kicked", UserId: targetID,
+			Content: fmt.Sprintf("you were kicked by %s for %d minutes", name, minutes)})
+		notice = fmt.Sprintf("%s was kicked for %d minutes by %s", command.User, minutes, name)
+
+	case "mute":
+		if _, err := database.Db.Exec(Upsert_Chat_Mute, roomID, targetID, until); err != nil {
+			slog.Error("failed to mute chat user", "err", err)
+			return errPleaseTryLater
+		}
+		notice = fmt.Sprintf("%s was muted for %d minutes by %s", command.User, minutes, name)
+
+	case "unmute":
+		if _, err := database.Db.Exec(Delete_Chat_Mute, roomID, targetID); err != nil {
+			slog.Error("failed to unmute chat user", "err", err)
+			return errPleaseTryLater
+		}
+		notice = command.User + " was unmuted by " + name
+	}
+
+	database.Hub.Publish(ChatTopic(roomID), "chat", ChatEvent{Type: "system", Content: notice})
+	return ""
+}
+
+// chatUntil reads the end of the mute or the kick of query for a user of a room, zero when there is none.
+func (database Database) chatUntil(query string, roomID, userID int) (time.Time, error) {
+	var until time.Time
+	err := database.Db.QueryRow(query, roomID, userID).Scan(&until)
+	if err == sql.ErrNoRows {
+		return time.Time{}, nil
+	}
+
+	return until, err
+}
+
+// sendChatBackfill sends the last messages of the room to a browser that just joined.
+func sendChatBackfill(db *sql.DB, conn *wsConn, roomID int) error {
+	rows, err := db.Query(Select_Chat_Backfill, roomID, chatBackfill)
+	if err != nil {
+		return err
+	}
+
+	events := []ChatEvent{}
+	for rows.Next() {
+		event := ChatEvent{Type: "message"}
+		var createdAt time.Time
+
+		if err := rows.Scan(&event.Id, &event.Author, &event.Content, &createdAt); err != nil {
+			rows.Close()
+			return err
+		}
+
+		event.Date = createdAt.Local().Format("15:04")
+		events = append(events, event)
+	}
+	rows.Close()
+
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, event := range events {
+		if err := sendChatEvent(conn, event); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func sendChatEvent(conn *wsConn, event ChatEvent) error {
+	message, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	return conn.WriteText(message)
+}
+
+// getRooms lists the chat rooms, one per category.
+func getRooms(db *sql.DB) ([]ChatRoom, error) {
+	rows, err := db.Query(Select_Rooms)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	rooms := []ChatRoom{}
+	for rows.Next() {
+		var room ChatRoom
+		if err := rows.Scan(&room.Id, &room.Name); err != nil {
+			return nil, err
+		}
+		rooms = append(rooms, room)
+	}
+
+	return rooms, rows.Err()
+}
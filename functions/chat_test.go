@@ -0,0 +1,381 @@
+package functions
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// chatTestRoom is the first category of Initialize.
+const chatTestRoom = 1
+
+// newChatServer serves the chat WebSocket like main does, on a real listener the client can hijack.
+func newChatServer(t *testing.T, database Database) *httptest.Server {
+	router := newTestRouter(database)
+	router.HandleFunc("GET /chat/{id}/ws", database.ChatSocket, RequireLogin)
+
+	server := httptest.NewServer(router)
+	t.Cleanup(server.Close)
+	return server
+}
+
+// wsTestClient is the browser side of a chat WebSocket: it masks its frames and reads unmasked ones.
+type wsTestClient struct {
+	t      *testing.T
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+// dialChat opens the WebSocket of room as user, with the Origin header when origin isn't empty.
+// It returns the response of the server when the handshake is refused.
+func dialChat(t *testing.T, server *httptest.Server, user testUser, room int, origin string) (*wsTestClient, *http.Response) {
+	t.Helper()
+
+	conn, err := net.Dial("tcp", server.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	nonce := make([]byte, 16)
+	rand.Read(nonce)
+	key := base64.StdEncoding.EncodeToString(nonce)
+
+	r, _ := http.NewRequest(http.MethodGet, server.URL+"/chat/"+strconv.Itoa(room)+"/ws", nil)
+	r.Header.Set("Connection", "Upgrade")
+	r.Header.Set("Upgrade", "websocket")
+	r.Header.Set("Sec-WebSocket-Version", "13")
+	r.Header.Set("Sec-WebSocket-Key", key)
+	if origin != "" {
+		r.Header.Set("Origin", origin)
+	}
+	r.AddCookie(&http.Cookie{Name: "session", Value: user.Session})
+
+	if err := r.Write(conn); err != nil {
+		t.Fatal(err)
+	}
+
+	reader := bufio.NewReader(conn)
+	response, err := http.ReadResponse(reader, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if response.StatusCode != http.StatusSwitchingProtocols {
+		conn.Close()
+		return nil, response
+	}
+
+	hash := sha1.Sum([]byte(key + websocketGUID))
+	if accept := response.Header.Get("Sec-WebSocket-Accept"); accept != base64.StdEncoding.EncodeToString(hash[:]) {
+		t.Errorf("Sec-WebSocket-Accept is %q", accept)
+	}
+
+	client := &wsTestClient{t: t, conn: conn, reader: reader}
+	t.Cleanup(func() { conn.Close() })
+	return client, response
+}
+
+// mustDialChat opens the WebSocket of room as user, the handshake must succeed.
+func mustDialChat(t *testing.T, server *httptest.Server, user testUser, room int) *wsTestClient {
+	t.Helper()
+
+	client, response := dialChat(t, server, user, room, server.URL)
+	if client == nil {
+		t.Fatalf("the handshake of %s answered %d", user.Name, response.StatusCode)
+	}
+
+	return client
+}
+
+// send writes command as a masked text frame.
+func (client *wsTestClient) send(command ChatCommand) {
+	client.t.Helper()
+
+	payload, _ := json.Marshal(command)
+
+	frame := []byte{0x80 | wsText}
+	if len(payload) < 126 {
+		frame = append(frame, 0x80|byte(len(payload)))
+	} else {
+		frame = append(frame, 0x80|126)
+		frame = binary.BigEndian.AppendUint16(frame, uint16(len(payload)))
+	}
+
+	mask := make([]byte, 4)
+	rand.Read(mask)
+	frame = append(frame, mask...)
+	for i, b := range payload {
+		frame = append(frame, b^mask[i%4])
+	}
+
+	if _, err := client.conn.Write(frame); err != nil {
+		client.t.Fatal(err)
+	}
+}
+
+// frame reads the next frame of the server.
+func (client *wsTestClient) frame() (byte, []byte) {
+	client.t.Helper()
+
+	client.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	var header [2]byte
+	if _, err := io.ReadFull(client.reader, header[:]); err != nil {
+		client.t.Fatal(err)
+	}
+
+	if header[1]&0x80 != 0 {
+		client.t.Fatal("the server sent a masked frame")
+	}
+
+	length := int(header[1] & 0x7F)
+	if length == 126 {
+		var extended [2]byte
+		if _, err := io.ReadFull(client.reader, extended[:]); err != nil {
+			client.t.Fatal(err)
+		}
+		length = int(binary.BigEndian.Uint16(extended[:]))
+	}
+
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(client.reader, payload); err != nil {
+		client.t.Fatal(err)
+	}
+
+	return header[0] & 0x0F, payload
+}
+
+// event reads the next chat event.
+func (client *wsTestClient) event() ChatEvent {
+	client.t.Helper()
+
+	opcode, payload := client.frame()
+	if opcode != wsText {
+		client.t.Fatalf("got the opcode %d (%q) instead of an event", opcode, payload)
+	}
+
+	var event ChatEvent
+	if err := json.Unmarshal(payload, &event); err != nil {
+		client.t.Fatal(err)
+	}
+
+	return event
+}
+
+func TestChatHandshake(t *testing.T) {
+	database := newTestDatabase(t)
+	server := newChatServer(t, database)
+	alice := addTestUser(t, database, "alice")
+
+	if client, response := dialChat(t, server, alice, chatTestRoom, ""); client == nil {
+		t.Fatalf("the handshake without Origin answered %d", response.StatusCode)
+	}
+
+	mustDialChat(t, server, alice, chatTestRoom)
+
+	visitor := testUser{Name: "visitor", Session: "no such session"}
+	if _, response := dialChat(t, server, visitor, chatTestRoom, server.URL); response.StatusCode != http.StatusUnauthorized {
+		t.Errorf("the handshake of a visitor answered %d", response.StatusCode)
+	}
+
+	if _, response := dialChat(t, server, alice, 999, server.URL); response.StatusCode != http.StatusNotFound {
+		t.Errorf("the handshake to a missing room answered %d", response.StatusCode)
+	}
+}
+
+func TestChatRejectsOtherOrigins(t *testing.T) {
+	database := newTestDatabase(t)
+	server := newChatServer(t, database)
+	alice := addTestUser(t, database, "alice")
+
+	for _, origin := range []string{"http://evil.test", "https://" + server.Listener.Addr().String() + ".evil.test", "::"} {
+		client, response := dialChat(t, server, alice, chatTestRoom, origin)
+		if client != nil {
+			t.Errorf("the Origin %q was upgraded", origin)
+			continue
+		}
+
+		if response.StatusCode != http.StatusForbidden {
+			t.Errorf("the Origin %q answered %d", origin, response.StatusCode)
+		}
+	}
+}
+
+func TestChatBackfill(t *testing.T) {
+	database := newTestDatabase(t)
+	server := newChatServer(t, database)
+	alice := addTestUser(t, database, "alice")
+	bob := addTestUser(t, database, "bob")
+
+	for _, content := range []string{"first", "second", "third"} {
+		if _, err := database.Db.Exec(Insert_Chat_Message, chatTestRoom, alice.Id, content); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// another room, not sent
+	if _, err := database.Db.Exec(Insert_Chat_Message, chatTestRoom+1, alice.Id, "elsewhere"); err != nil {
+		t.Fatal(err)
+	}
+
+	client := mustDialChat(t, server, bob, chatTestRoom)
+
+	for _, content := range []string{"first", "second", "third"} {
+		event := client.event()
+		if event.Type != "message" || event.Author != "alice" || event.Content != content {
+			t.Errorf("got %+v instead of the message %q of alice", event, content)
+		}
+	}
+
+	client.send(ChatCommand{Type: "message", Content: "live"})
+	if event := client.event(); event.Content != "live" {
+		t.Errorf("got %+v after the backfill instead of the new message", event)
+	}
+}
+
+func TestChatBroadcast(t *testing.T) {
+	database := newTestDatabase(t)
+	server := newChatServer(t, database)
+	alice := addTestUser(t, database, "alice")
+	bob := addTestUser(t, database, "bob")
+	carol := addTestUser(t, database, "carol")
+
+	aliceClient := mustDialChat(t, server, alice, chatTestRoom)
+	bobClient := mustDialChat(t, server, bob, chatTestRoom)
+	carolClient := mustDialChat(t, server, carol, chatTestRoom+1)
+
+	aliceClient.send(ChatCommand{Type: "message", Content: "  hello room  "})
+
+	for name, client := range map[string]*wsTestClient{"alice": aliceClient, "bob": bobClient} {
+		event := client.event()
+		if event.Type != "message" || event.Author != "alice" || event.Content != "hello room" || event.Id == 0 {
+			t.Errorf("%s got %+v instead of the message of alice", name, event)
+		}
+	}
+
+	// carol is in another room: her first event is her own message
+	carolClient.send(ChatCommand{Type: "message", Content: "other room"})
+	if event := carolClient.event(); event.Content != "other room" {
+		t.Errorf("carol got %+v from another room", event)
+	}
+
+	var count int
+	if err := database.Db.QueryRow(`SELECT COUNT(*) FROM chat_message WHERE category_id = ?`, chatTestRoom).Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 {
+		t.Errorf("%d messages stored in the room, want 1", count)
+	}
+}
+
+func TestChatRateLimit(t *testing.T) {
+	database := newTestDatabase(t)
+	server := newChatServer(t, database)
+	alice := addTestUser(t, database, "alice")
+
+	client := mustDialChat(t, server, alice, chatTestRoom)
+
+	for i := range chatMessagesBurst + 1 {
+		client.send(ChatCommand{Type: "message", Content: "message " + strconv.Itoa(i)})
+	}
+
+	// the error is written by the reader and the messages by the writer, in any order
+	var messages, errors int
+	for range chatMessagesBurst + 1 {
+		switch event := client.event(); event.Type {
+		case "message":
+			messages++
+		case "error":
+			errors++
+			if !strings.Contains(event.Content, "slow down") {
+				t.Errorf("the rate limit error is %q", event.Content)
+			}
+		default:
+			t.Errorf("unexpected event %+v", event)
+		}
+	}
+
+	if messages != chatMessagesBurst || errors != 1 {
+		t.Errorf("got %d messages and %d errors, want %d and 1", messages, errors, chatMessagesBurst)
+	}
+}
+
+func TestChatModeratorMuteAndKick(t *testing.T) {
+	database := newTestDatabase(t)
+	server := newChatServer(t, database)
+	alice := addTestUser(t, database, "alice")
+	bob := addTestUser(t, database, "bob")
+
+	if _, err := database.Db.Exec(`UPDATE user SET role = ? WHERE id = ?`, RoleModerator, alice.Id); err != nil {
+		t.Fatal(err)
+	}
+
+	aliceClient := mustDialChat(t, server, alice, chatTestRoom)
+	bobClient := mustDialChat(t, server, bob, chatTestRoom)
+
+	bobClient.send(ChatCommand{Type: "mute", User: "alice"})
+	if event := bobClient.event(); event.Type != "error" || !strings.Contains(event.Content, "only moderators") {
+		t.Errorf("a user muting got %+v", event)
+	}
+
+	aliceClient.send(ChatCommand{Type: "mute", User: "bob", Minutes: 5})
+	for name, client := range map[string]*wsTestClient{"alice": aliceClient, "bob": bobClient} {
+		if event := client.event(); event.Type != "system" || !strings.Contains(event.Content, "bob was muted for 5 minutes") {
+			t.Errorf("%s got %+v instead of the mute notice", name, event)
+		}
+	}
+
+	bobClient.send(ChatCommand{Type: "message", Content: "can I talk?"})
+	if event := bobClient.event(); event.Type != "error" || !strings.Contains(event.Content, "you are muted") {
+		t.Errorf("a muted user got %+v", event)
+	}
+
+	aliceClient.send(ChatCommand{Type: "unmute", User: "bob"})
+	aliceClient.event()
+	bobClient.event()
+
+	bobClient.send(ChatCommand{Type: "message", Content: "thanks"})
+	if event := bobClient.event(); event.Type != "message" || event.Content != "thanks" {
+		t.Errorf("an unmuted user got %+v", event)
+	}
+	aliceClient.event()
+
+	aliceClient.send(ChatCommand{Type: "kick", User: "bob", Minutes: 15})
+
+	if event := bobClient.event(); event.Type != "kicked" || !strings.Contains(event.Content, "15 minutes") {
+		t.Errorf("the kicked user got %+v", event)
+	}
+
+	opcode, payload := bobClient.frame()
+	if opcode != wsClose || len(payload) < 2 || binary.BigEndian.Uint16(payload) != wsClosePolicy || string(payload[2:]) != "kicked" {
+		t.Errorf("the kicked user got the opcode %d (%q) instead of the close frame", opcode, payload)
+	}
+
+	if event := aliceClient.event(); event.Type != "system" || !strings.Contains(event.Content, "bob was kicked for 15 minutes") {
+		t.Errorf("the room got %+v instead of the kick notice", event)
+	}
+
+	client, response := dialChat(t, server, bob, chatTestRoom, server.URL)
+	if client != nil || response.StatusCode != http.StatusForbidden {
+		t.Errorf("the kicked user joined again (%d)", response.StatusCode)
+	}
+
+	// the kick is for this room only
+	mustDialChat(t, server, bob, chatTestRoom+1)
+
+	aliceClient.send(ChatCommand{Type: "kick", User: "alice"})
+	if event := aliceClient.event(); event.Type != "error" || !strings.Contains(event.Content, "moderators can't be kicked") {
+		t.Errorf("kicking a moderator got %+v", event)
+	}
+}
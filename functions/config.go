@@ -0,0 +1,589 @@
+package functions
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"mime"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config is the configuration of the server. LoadConfig reads it from, by order of priority,
+// the command line flags, the FORUM_* environment variables and a TOML file, over DefaultConfig.
+type Config struct {
+	Addr          string // the address the server listens on
+	DBPath        string // of the SQLite database
+	AttachmentDir string // where the attached files are stored
+	AvatarDir     string // where the resized avatars are stored
+	BaseURL       string // of the links in the emails and the feeds, like https://agora.example
+	Server        ServerConfig
+	TLS           TLSConfig
+	Security      SecurityConfig
+	Session       SessionConfig
+	Content       ContentLimits
+	Attachments   AttachmentLimits
+	Mail          MailConfig
+	Digest        DigestConfig
+	Webhooks      WebhookConfig
+	Log           LogConfig
+}
+
+// ServerConfig protects the server from slow or greedy clients. The live streams and the chat
+// are not cut by the timeouts: they set their own deadlines.
+type ServerConfig struct {
+	ReadHeaderTimeout time.Duration
+	ReadTimeout       time.Duration // of a whole request, with its body
+	WriteTimeout      time.Duration // of a whole response
+	IdleTimeout       time.Duration // of a keep-alive connection waiting for the next request
+	MaxHeaderBytes    int
+	MaxBodySize       int64         // of the requests that don't upload files, which have their own limits
+	ShutdownTimeout   time.Duration // given to the requests in progress when the server stops
+	TrustedProxies    string        // addresses and networks, separated by commas, whose X-Forwarded-Proto is believed
+}
+
+// TLSConfig serves HTTPS when CertFile and KeyFile are set, the files are read again on SIGHUP.
+type TLSConfig struct {
+	CertFile     string
+	KeyFile      string
+	RedirectAddr string        // of a plain HTTP listener sending the browsers to HTTPS, none when empty
+	HSTSMaxAge   time.Duration // browsers that reached the forum over HTTPS stay on it that long
+}
+
+// Enabled tells if the server speaks HTTPS itself.
+func (config TLSConfig) Enabled() bool {
+	return config.CertFile != ""
+}
+
+// SecurityConfig sets the security headers of every response. {nonce} in CSP is replaced
+// by the nonce of the response, that the templates give to their scripts and styles.
+type SecurityConfig struct {
+	CSP            string // Content-Security-Policy, none when empty
+	CSPReportOnly  bool   // only report the violations to /csp-report, to try a new policy
+	FrameOptions   string // X-Frame-Options, DENY or SAMEORIGIN
+	ReferrerPolicy string
+}
+
+// DefaultCSP only allows the resources of the forum, and the inline scripts and styles with the nonce.
+const DefaultCSP = "default-src 'self'; script-src 'self' 'nonce-{nonce}'; style-src 'self' 'nonce-{nonce}'; " +
+	"img-src 'self' data:; object-src 'none'; base-uri 'self'; form-action 'self'; frame-ancestors 'none'; " +
+	"report-uri /csp-report"
+
+// SessionConfig controls the session cookies.
+type SessionConfig struct {
+	Lifetime      time.Duration
+	SecureCookies bool // always send the cookies over HTTPS only, they already are when the request came over HTTPS
+}
+
+// ContentLimits are the maximum sizes of what the users write, in bytes.
+type ContentLimits struct {
+	MaxTitle   int
+	MaxPost    int
+	MaxComment int
+	MaxMessage int // of the direct messages and the chat
+	MaxBio     int
+}
+
+// MailConfig chooses the Mailer of the digests: SMTPMailer when SMTPAddr is set, FileMailer otherwise.
+type MailConfig struct {
+	From         string
+	Outbox       string // the directory of FileMailer
+	SMTPAddr     string // host:port
+	SMTPUsername string
+	SMTPPassword string
+}
+
+// DigestConfig schedules the job sending the email digests.
+type DigestConfig struct {
+	Interval time.Duration // between two checks of the digests due
+}
+
+// WebhookConfig schedules the deliveries of the webhooks, see WebhookWorker.
+type WebhookConfig struct {
+	Interval    time.Duration // between two checks of the deliveries due
+	Timeout     time.Duration // of a delivery
+	Backoff     time.Duration // before the first retry, then twice as long after each failure
+	MaxAttempts int
+	KeepLog     time.Duration // the finished deliveries are removed after it
+}
+
+// LogConfig chooses which records are logged and how they are written.
+type LogConfig struct {
+	Level  string // debug, info, warn or error
+	Format string // text or json
+}
+
+// DefaultContentLimits are the limits used when the configuration doesn't change them.
+var DefaultContentLimits = ContentLimits{
+	MaxTitle:   150,
+	MaxPost:    50000,
+	MaxComment: 1000,
+	MaxMessage: 1000,
+	MaxBio:     300,
+}
+
+// DefaultConfig runs the forum on http://localhost:8080 with its data in db/.
+func DefaultConfig() Config {
+	return Config{
+		Addr:          ":8080",
+		DBPath:        "db/forum.db",
+		AttachmentDir: "db/attachments",
+		AvatarDir:     "db/avatars",
+		BaseURL:       "http://localhost:8080",
+		Server: ServerConfig{
+			ReadHeaderTimeout: 5 * time.Second,
+			ReadTimeout:       time.Minute,
+			WriteTimeout:      time.Minute,
+			IdleTimeout:       2 * time.Minute,
+			MaxHeaderBytes:    64 << 10,
+			MaxBodySize:       1 << 20,
+			ShutdownTimeout:   30 * time.Second,
+		},
+		TLS: TLSConfig{HSTSMaxAge: 365 * 24 * time.Hour},
+		Security: SecurityConfig{
+			CSP:            DefaultCSP,
+			FrameOptions:   "DENY",
+			ReferrerPolicy: "strict-origin-when-cross-origin",
+		},
+		Session:     SessionConfig{Lifetime: 24 * time.Hour},
+		Content:     DefaultContentLimits,
+		Attachments: DefaultAttachmentLimits,
+		Mail: MailConfig{
+			From:   "AGORA <no-reply@localhost>",
+			Outbox: "db/outbox",
+		},
+		Digest: DigestConfig{Interval: time.Hour},
+		Webhooks: WebhookConfig{
+			Interval:    5 * time.Second,
+			Timeout:     10 * time.Second,
+			Backoff:     30 * time.Second,
+			MaxAttempts: 8,
+			KeepLog:     30 * 24 * time.Hour,
+		},
+		Log: LogConfig{Level: "info", Format: "text"},
+	}
+}
+
+// Mailer returns the mailer described by the mail settings.
+func (config Config) Mailer() Mailer {
+	if config.Mail.SMTPAddr != "" {
+		return SMTPMailer{
+			Addr:     config.Mail.SMTPAddr,
+			From:     config.Mail.From,
+			Username: config.Mail.SMTPUsername,
+			Password: config.Mail.SMTPPassword,
+		}
+	}
+
+	return FileMailer{Dir: config.Mail.Outbox, From: config.Mail.From}
+}
+
+// configSetting is one setting, named session.lifetime in the file, -session-lifetime on the command line
+// and FORUM_SESSION_LIFETIME in the environment. Value points into a Config.
+type configSetting struct {
+	key   string
+	usage string
+	value any
+}
+
+func (config *Config) settings() []configSetting {
+	return []configSetting{
+		{"addr", "address to listen on", &config.Addr},
+		{"db", "path of the SQLite database", &config.DBPath},
+		{"attachment_dir", "directory of the attached files", &config.AttachmentDir},
+		{"avatar_dir", "directory of the resized avatars", &config.AvatarDir},
+		{"base_url", "URL of the forum in the links of the emails and the feeds", &config.BaseURL},
+		{"server.read_header_timeout", "time to read the headers of a request", &config.Server.ReadHeaderTimeout},
+		{"server.read_timeout", "time to read a whole request", &config.Server.ReadTimeout},
+		{"server.write_timeout", "time to write a whole response", &config.Server.WriteTimeout},
+		{"server.idle_timeout", "time a keep-alive connection waits for the next request", &config.Server.IdleTimeout},
+		{"server.max_header_bytes", "maximum size of the headers of a request", &config.Server.MaxHeaderBytes},
+		{"server.max_body_size", "maximum size of a request body, except file uploads", &config.Server.MaxBodySize},
+		{"server.shutdown_timeout", "time given to the requests in progress when the server stops", &config.Server.ShutdownTimeout},
+		{"server.trusted_proxies", "addresses and networks of the proxies whose X-Forwarded-Proto is trusted, separated by commas", &config.Server.TrustedProxies},
+		{"tls.cert_file", "certificate file (PEM) to serve HTTPS, read again on SIGHUP", &config.TLS.CertFile},
+		{"tls.key_file", "private key file (PEM) of the certificate", &config.TLS.KeyFile},
+		{"tls.redirect_addr", "address of a plain HTTP listener redirecting to HTTPS", &config.TLS.RedirectAddr},
+		{"tls.hsts_max_age", "time browsers must keep using HTTPS (Strict-Transport-Security), 0 tells them to stop", &config.TLS.HSTSMaxAge},
+		{"security.csp", "Content-Security-Policy of the responses, {nonce} is replaced by a new nonce each time", &config.Security.CSP},
+		{"security.csp_report_only", "only report the violations of the CSP instead of blocking them", &config.Security.CSPReportOnly},
+		{"security.frame_options", "X-Frame-Options of the responses, DENY or SAMEORIGIN", &config.Security.FrameOptions},
+		{"security.referrer_policy", "Referrer-Policy of the responses", &config.Security.ReferrerPolicy},
+		{"session.lifetime", "time before a session expires", &config.Session.Lifetime},
+		{"session.secure_cookies", "always send the session cookie over HTTPS only, even when the request came over HTTP", &config.Session.SecureCookies},
+		{"content.max_title", "maximum size of a post title, in bytes", &config.Content.MaxTitle},
+		{"content.max_post", "maximum size of a post, in bytes", &config.Content.MaxPost},
+		{"content.max_comment", "maximum size of a comment, in bytes", &config.Content.MaxComment},
+		{"content.max_message", "maximum size of a direct or chat message, in bytes", &config.Content.MaxMessage},
+		{"content.max_bio", "maximum size of a profile bio, in bytes", &config.Content.MaxBio},
+		{"attachments.max_file_size", "maximum size of an attached file, in bytes", &config.Attachments.MaxFileSize},
+		{"attachments.max_files", "maximum number of files attached to a post", &config.Attachments.MaxFiles},
+		{"attachments.allowed_types", "media types of the files that can be attached, separated by commas", &config.Attachments.AllowedTypes},
+		{"mail.from", "sender of the emails", &config.Mail.From},
+		{"mail.outbox", "directory where emails are written when there is no SMTP server", &config.Mail.Outbox},
+		{"mail.smtp_addr", "host:port of the SMTP server sending the emails", &config.Mail.SMTPAddr},
+		{"mail.smtp_username", "SMTP user name", &config.Mail.SMTPUsername},
+		{"mail.smtp_password", "SMTP password", &config.Mail.SMTPPassword},
+		{"digest.interval", "time between two checks of the email digests due", &config.Digest.Interval},
+		{"webhooks.interval", "time between two checks of the webhook deliveries due", &config.Webhooks.Interval},
+		{"webhooks.timeout", "time a webhook receiver has to answer", &config.Webhooks.Timeout},
+		{"webhooks.backoff", "time before the first retry of a failed delivery, doubled after each failure", &config.Webhooks.Backoff},
+		{"webhooks.max_attempts", "attempts of a delivery before it is failed", &config.Webhooks.MaxAttempts},
+		{"webhooks.keep_log", "time the finished deliveries are kept in the log", &config.Webhooks.KeepLog},
+		{"log.level", "lowest level logged: debug, info, warn or error", &config.Log.Level},
+		{"log.format", "format of the logs: text or json", &config.Log.Format},
+	}
+}
+
+func (setting configSetting) flag() string {
+	return strings.NewReplacer(".", "-", "_", "-").Replace(setting.key)
+}
+
+func (setting configSetting) env() string {
+	return "FORUM_" + strings.ToUpper(strings.ReplaceAll(setting.key, ".", "_"))
+}
+
+// text returns the value of the setting as it would be written.
+func (setting configSetting) text() string {
+	switch value := setting.value.(type) {
+	case *string:
+		return strconv.Quote(*value)
+	case *int:
+		return strconv.Itoa(*value)
+	case *int64:
+		return strconv.FormatInt(*value, 10)
+	case *bool:
+		return strconv.FormatBool(*value)
+	case *time.Duration:
+		return value.String()
+	case *[]string:
+		return strconv.Quote(strings.Join(*value, ", "))
+	}
+
+	return ""
+}
+
+// set parses a value written as text into the setting.
+func (setting configSetting) set(text string) error {
+	var err error
+
+	switch value := setting.value.(type) {
+	case *string:
+		*value = text
+	case *int:
+		*value, err = strconv.Atoi(strings.ReplaceAll(text, "_", ""))
+	case *int64:
+		*value, err = strconv.ParseInt(strings.ReplaceAll(text, "_", ""), 10, 64)
+	case *bool:
+		*value, err = strconv.ParseBool(text)
+	case *time.Duration:
+		*value, err = time.ParseDuration(text)
+	case *[]string:
+		*value = splitList(text)
+	}
+
+	if err != nil {
+		return fmt.Errorf("invalid value %q for %s", text, setting.key)
+	}
+
+	return nil
+}
+
+// splitList reads a list of values separated by commas, without the empty ones.
+func splitList(text string) []string {
+	list := []string{}
+	for _, item := range strings.Split(text, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+
+	return list
+}
+
+// LoadConfig reads the configuration from args, the command line without the program name,
+// from the environment through getenv and from the TOML file named by -config or FORUM_CONFIG.
+// It returns flag.ErrHelp when -help was asked, after printing the usage.
+func LoadConfig(args []string, getenv func(string) string) (Config, error) {
+	config := DefaultConfig()
+	settings := config.settings()
+
+	flags := flag.NewFlagSet("forum", flag.ContinueOnError)
+	file := flags.String("config", getenv("FORUM_CONFIG"), "path of a TOML configuration file (FORUM_CONFIG)")
+
+	// the flags are applied last, over the file and the environment
+	fromFlags := map[string]string{}
+	for _, setting := range settings {
+		key := setting.key
+		usage := fmt.Sprintf("%s (%s, default %v)", setting.usage, setting.env(), setting.text())
+		record := func(text string) error {
+			fromFlags[key] = text
+			return nil
+		}
+
+		if _, isBool := setting.value.(*bool); isBool {
+			flags.BoolFunc(setting.flag(), usage, record)
+		} else {
+			flags.Func(setting.flag(), usage, record)
+		}
+	}
+
+	if err := flags.Parse(args); err != nil {
+		return config, err
+	}
+
+	if flags.NArg() > 0 {
+		return config, fmt.Errorf("unexpected argument %q", flags.Arg(0))
+	}
+
+	if *file != "" {
+		text, err := os.ReadFile(*file)
+		if err != nil {
+			return config, err
+		}
+
+		values, err := parseTOML(string(text))
+		if err != nil {
+			return config, fmt.Errorf("%s: %w", *file, err)
+		}
+
+		if err := config.apply(values); err != nil {
+			return config, fmt.Errorf("%s: %w", *file, err)
+		}
+	}
+
+	fromEnv := map[string]string{}
+	for _, setting := range settings {
+		if value := getenv(setting.env()); value != "" {
+			fromEnv[setting.key] = value
+		}
+	}
+
+	if err := config.apply(fromEnv); err != nil {
+		return config, fmt.Errorf("environment: %w", err)
+	}
+
+	if err := config.apply(fromFlags); err != nil {
+		return config, fmt.Errorf("flags: %w", err)
+	}
+
+	config.BaseURL = strings.TrimSuffix(config.BaseURL, "/")
+
+	return config, config.Validate()
+}
+
+// apply sets the settings named by the keys of values.
+func (config *Config) apply(values map[string]string) error {
+	settings := map[string]configSetting{}
+	for _, setting := range config.settings() {
+		settings[setting.key] = setting
+	}
+
+	for key, value := range values {
+		setting, found := settings[key]
+		if !found {
+			return fmt.Errorf("unknown setting %q", key)
+		}
+
+		if err := setting.set(value); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Validate reports every setting that the server can't run with.
+func (config Config) Validate() error {
+	var problems []error
+
+	if _, _, err := net.SplitHostPort(config.Addr); err != nil {
+		problems = append(problems, fmt.Errorf("addr must be host:port or :port, not %q", config.Addr))
+	}
+
+	if _, err := ParseProxies(config.Server.TrustedProxies); err != nil {
+		problems = append(problems, fmt.Errorf("server.trusted_proxies: %w", err))
+	}
+
+	if (config.TLS.CertFile == "") != (config.TLS.KeyFile == "") {
+		problems = append(problems, errors.New("tls.cert_file and tls.key_file must be set together"))
+	}
+
+	if config.TLS.RedirectAddr != "" {
+		if !config.TLS.Enabled() {
+			problems = append(problems, errors.New("tls.redirect_addr needs tls.cert_file and tls.key_file"))
+		} else if _, _, err := net.SplitHostPort(config.TLS.RedirectAddr); err != nil {
+			problems = append(problems, fmt.Errorf("tls.redirect_addr must be host:port or :port, not %q", config.TLS.RedirectAddr))
+		}
+	}
+
+	if config.Security.FrameOptions != "DENY" && config.Security.FrameOptions != "SAMEORIGIN" {
+		problems = append(problems, fmt.Errorf("security.frame_options must be DENY or SAMEORIGIN, not %q", config.Security.FrameOptions))
+	}
+
+	if strings.ContainsAny(config.Security.CSP, "\r\n") {
+		problems = append(problems, errors.New("security.csp must be on one line"))
+	}
+
+	if strings.ContainsAny(config.Security.ReferrerPolicy, "\r\n") {
+		problems = append(problems, errors.New("security.referrer_policy must be on one line"))
+	}
+
+	if config.DBPath == "" {
+		problems = append(problems, errors.New("db must not be empty"))
+	}
+
+	if config.AttachmentDir == "" {
+		problems = append(problems, errors.New("attachment_dir must not be empty"))
+	}
+
+	if config.AvatarDir == "" {
+		problems = append(problems, errors.New("avatar_dir must not be empty"))
+	}
+
+	parsed, err := url.Parse(config.BaseURL)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		problems = append(problems, fmt.Errorf("base_url must be an http or https URL, not %q", config.BaseURL))
+	}
+
+	if config.Session.Lifetime > 0 && config.Session.Lifetime < time.Minute {
+		problems = append(problems, errors.New("session.lifetime must be at least 1m"))
+	}
+
+	if config.TLS.HSTSMaxAge < 0 {
+		problems = append(problems, errors.New("tls.hsts_max_age must not be negative"))
+	}
+
+	// every number is a size, a count or a time, only HSTS can be turned off with 0
+	for _, setting := range config.settings() {
+		switch value := setting.value.(type) {
+		case *time.Duration:
+			if *value <= 0 && setting.key != "tls.hsts_max_age" {
+				problems = append(problems, fmt.Errorf("%s must be positive", setting.key))
+			}
+		case *int:
+			if *value < 1 {
+				problems = append(problems, fmt.Errorf("%s must be positive", setting.key))
+			}
+		case *int64:
+			if *value < 1 {
+				problems = append(problems, fmt.Errorf("%s must be positive", setting.key))
+			}
+		}
+	}
+
+	if len(config.Attachments.AllowedTypes) == 0 {
+		problems = append(problems, errors.New("attachments.allowed_types must not be empty"))
+	}
+
+	for _, mediaType := range config.Attachments.AllowedTypes {
+		parsed, params, err := mime.ParseMediaType(mediaType)
+		if err != nil || len(params) > 0 || parsed != mediaType || !strings.Contains(parsed, "/") {
+			problems = append(problems, fmt.Errorf("attachments.allowed_types: %q is not a lowercase media type like image/png", mediaType))
+		}
+	}
+
+	if config.Mail.From == "" {
+		problems = append(problems, errors.New("mail.from must not be empty"))
+	}
+
+	if config.Mail.SMTPAddr != "" {
+		if _, _, err := net.SplitHostPort(config.Mail.SMTPAddr); err != nil {
+			problems = append(problems, fmt.Errorf("mail.smtp_addr must be host:port, not %q", config.Mail.SMTPAddr))
+		}
+	} else if config.Mail.Outbox == "" {
+		problems = append(problems, errors.New("mail.outbox must not be empty without mail.smtp_addr"))
+	}
+
+	if _, found := logLevels[config.Log.Level]; !found {
+		problems = append(problems, fmt.Errorf("log.level must be debug, info, warn or error, not %q", config.Log.Level))
+	}
+
+	if config.Log.Format != "text" && config.Log.Format != "json" {
+		problems = append(problems, fmt.Errorf("log.format must be text or json, not %q", config.Log.Format))
+	}
+
+	return errors.Join(problems...)
+}
+
+// parseTOML reads the subset of TOML used by the configuration file: [tables] and key = value pairs
+// whose values are strings, integers or booleans. The keys are returned with their table, like session.lifetime.
+func parseTOML(text string) (map[string]string, error) {
+	values := map[string]string{}
+	table := ""
+
+	for number, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(stripTOMLComment(line))
+		if line == "" {
+			continue
+		}
+
+		if strings.HasPrefix(line, "[") {
+			if !strings.HasSuffix(line, "]") || strings.HasPrefix(line, "[[") {
+				return nil, fmt.Errorf("line %d: invalid table", number+1)
+			}
+			table = strings.TrimSpace(line[1:len(line)-1]) + "."
+			continue
+		}
+
+		key, value, found := strings.Cut(line, "=")
+		if !found {
+			return nil, fmt.Errorf("line %d: expected key = value", number+1)
+		}
+
+		key = table + strings.TrimSpace(key)
+		value = strings.TrimSpace(value)
+
+		switch {
+		case strings.HasPrefix(value, `"`):
+			unquoted, err := strconv.Unquote(value)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: invalid string", number+1)
+			}
+			value = unquoted
+		case strings.HasPrefix(value, "'"):
+			if len(value) < 2 || !strings.HasSuffix(value, "'") {
+				return nil, fmt.Errorf("line %d: invalid string", number+1)
+			}
+			value = value[1 : len(value)-1]
+		case value == "" || strings.ContainsAny(value, "[{"):
+			return nil, fmt.Errorf("line %d: unsupported value", number+1)
+		}
+
+		if _, duplicate := values[key]; duplicate {
+			return nil, fmt.Errorf("line %d: %s is set twice", number+1, key)
+		}
+		values[key] = value
+	}
+
+	return values, nil
+}
+
+// stripTOMLComment removes a # comment that isn't inside a string.
+func stripTOMLComment(line string) string {
+	var quote rune
+	escaped := false
+
+	for i, char := range line {
+		switch {
+		case escaped:
+			escaped = false
+		case quote == '"' && char == '\\':
+			escaped = true
+		case quote != 0:
+			if char == quote {
+				quote = 0
+			}
+		case char == '"' || char == '\'':
+			quote = char
+		case char == '#':
+			return line[:i]
+		}
+	}
+
+	return line
+}
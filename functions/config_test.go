@@ -0,0 +1,281 @@
+package functions
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeConfigFile writes a TOML configuration file and returns its path.
+func writeConfigFile(t *testing.T, text string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "forum.toml")
+	if err := os.WriteFile(path, []byte(text), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+// environment returns a getenv reading env.
+func environment(env map[string]string) func(string) string {
+	return func(name string) string { return env[name] }
+}
+
+func TestLoadConfigDefaults(t *testing.T) {
+	config, err := LoadConfig(nil, environment(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(config, DefaultConfig()) {
+		t.Errorf("without settings the configuration is\n%+v\nnot the defaults\n%+v", config, DefaultConfig())
+	}
+}
+
+func TestLoadConfigPrecedence(t *testing.T) {
+	file := writeConfigFile(t, `
+addr = ":1001"
+db = "file.db"
+base_url = "https://file.test/"
+
+[session]
+lifetime = "2h"
+
+[log]
+level = "warn"
+`)
+
+	env := map[string]string{
+		"FORUM_CONFIG":                file,
+		"FORUM_ADDR":                  ":1002",
+		"FORUM_DB":                    "env.db",
+		"FORUM_CONTENT_MAX_TITLE":     "90",
+		"FORUM_SECURITY_CSP":          "", // empty variables are ignored
+		"FORUM_ATTACHMENTS_MAX_FILES": "3",
+	}
+	args := []string{"-addr", ":1003", "-content-max-title=80", "-session-secure-cookies"}
+
+	config, err := LoadConfig(args, environment(env))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	checks := []struct {
+		setting   string
+		got, want any
+	}{
+		{"addr, from the flags over the environment and the file", config.Addr, ":1003"},
+		{"content.max_title, from the flags over the environment", config.Content.MaxTitle, 80},
+		{"session.secure_cookies, from a flag without value", config.Session.SecureCookies, true},
+		{"db, from the environment over the file", config.DBPath, "env.db"},
+		{"attachments.max_files, from the environment", config.Attachments.MaxFiles, 3},
+		{"session.lifetime, from the file", config.Session.Lifetime, 2 * time.Hour},
+		{"log.level, from the file", config.Log.Level, "warn"},
+		{"base_url, from the file without its last slash", config.BaseURL, "https://file.test"},
+		{"security.csp, the default", config.Security.CSP, DefaultCSP},
+		{"mail.from, the default", config.Mail.From, DefaultConfig().Mail.From},
+	}
+
+	for _, check := range checks {
+		if check.got != check.want {
+			t.Errorf("%s: got %v, want %v", check.setting, check.got, check.want)
+		}
+	}
+
+	// -config names the file over FORUM_CONFIG
+	other := writeConfigFile(t, `addr = ":1004"`)
+	delete(env, "FORUM_ADDR")
+
+	config, err = LoadConfig([]string{"-config", other}, environment(env))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Addr != ":1004" || config.DBPath != "env.db" {
+		t.Errorf("-config read addr %q and db %q", config.Addr, config.DBPath)
+	}
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		env  map[string]string
+		file string
+		want string
+	}{
+		{"unknown setting in the file", nil, nil, "[session]\nlength = \"1h\"", `unknown setting "session.length"`},
+		{"bad value in the file", nil, nil, "[session]\nlifetime = \"soon\"", `invalid value "soon" for session.lifetime`},
+		{"bad value in the environment", nil, map[string]string{"FORUM_CONTENT_MAX_POST": "big"}, "", `environment: invalid value "big" for content.max_post`},
+		{"bad value in the flags", []string{"-session-secure-cookies=maybe"}, nil, "", `flags: invalid value "maybe" for session.secure_cookies`},
+		{"unknown flag", []string{"-colour"}, nil, "", "flag provided but not defined: -colour"},
+		{"argument", []string{"serve"}, nil, "", `unexpected argument "serve"`},
+		{"missing file", []string{"-config", "/no/such/forum.toml"}, nil, "", "no such file"},
+		{"invalid file", nil, nil, "addr", "line 1: expected key = value"},
+		{"invalid settings", []string{"-log-level", "loud"}, nil, "", `log.level must be debug, info, warn or error, not "loud"`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			env := map[string]string{}
+			for name, value := range test.env {
+				env[name] = value
+			}
+
+			if test.file != "" {
+				env["FORUM_CONFIG"] = writeConfigFile(t, test.file)
+			}
+
+			_, err := LoadConfig(test.args, environment(env))
+			if err == nil || !strings.Contains(err.Error(), test.want) {
+				t.Errorf("got the error %v, want %q", err, test.want)
+			}
+
+			if err != nil && test.file != "" && !strings.Contains(err.Error(), env["FORUM_CONFIG"]) {
+				t.Errorf("the error %q doesn't name the file", err)
+			}
+		})
+	}
+}
+
+func TestParseTOML(t *testing.T) {
+	values, err := parseTOML(`
+# a comment line
+addr = ":8080" # a trailing comment
+base_url = "https://a.test/#top" # the # of the string is kept
+db = 'single # quoted'
+escaped = "a \" # b"
+
+[server]
+max_body_size = 1_048_576
+trusted_proxies = "10.0.0.0/8"
+
+[ security ]
+csp_report_only = true
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]string{
+		"addr":                     ":8080",
+		"base_url":                 "https://a.test/#top",
+		"db":                       "single # quoted",
+		"escaped":                  `a " # b`,
+		"server.max_body_size":     "1_048_576",
+		"server.trusted_proxies":   "10.0.0.0/8",
+		"security.csp_report_only": "true",
+	}
+
+	if !reflect.DeepEqual(values, want) {
+		t.Errorf("parseTOML read\n%v\nwant\n%v", values, want)
+	}
+}
+
+func TestParseTOMLErrors(t *testing.T) {
+	tests := []struct {
+		name, text, want string
+	}{
+		{"duplicate key", "addr = \":1\"\naddr = \":2\"", "line 2: addr is set twice"},
+		{"duplicate key in a table", "[log]\nlevel = \"info\"\n[log]\nlevel = \"warn\"", "line 4: log.level is set twice"},
+		{"unclosed table", "[log", "line 1: invalid table"},
+		{"array of tables", "[[log]]", "line 1: invalid table"},
+		{"no value", "addr", "line 1: expected key = value"},
+		{"empty value", "addr =", "line 1: unsupported value"},
+		{"array", "proxies = [\"a\"]", "line 1: unsupported value"},
+		{"inline table", "log = { level = \"info\" }", "line 1: unsupported value"},
+		{"unterminated string", "addr = \":1", "line 1: invalid string"},
+		{"unterminated single quoted string", "addr = ':1", "line 1: invalid string"},
+		{"text after a string", "addr = \":1\" :2", "line 1: invalid string"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := parseTOML(test.text)
+			if err == nil || err.Error() != test.want {
+				t.Errorf("got the error %v, want %q", err, test.want)
+			}
+		})
+	}
+}
+
+func TestStripTOMLComment(t *testing.T) {
+	tests := []struct {
+		line, want string
+	}{
+		{`addr = ":1" # comment`, `addr = ":1" `},
+		{`# comment`, ``},
+		{`url = "a#b"`, `url = "a#b"`},
+		{`url = 'a#b' # c`, `url = 'a#b' `},
+		{`s = "a\"#b" # c`, `s = "a\"#b" `},
+		{`s = 'a\' # c`, `s = 'a\' `}, // no escapes in single quotes
+		{`s = "it's" # c`, `s = "it's" `},
+	}
+
+	for _, test := range tests {
+		if got := stripTOMLComment(test.line); got != test.want {
+			t.Errorf("stripTOMLComment(%q) = %q, want %q", test.line, got, test.want)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(config *Config)
+		want   string // empty when the configuration is valid
+	}{
+		{"defaults", func(config *Config) {}, ""},
+		{"TLS", func(config *Config) { config.TLS.CertFile, config.TLS.KeyFile = "cert.pem", "key.pem" }, ""},
+		{"TLS without key", func(config *Config) { config.TLS.CertFile = "cert.pem" }, "tls.cert_file and tls.key_file must be set together"},
+		{"TLS without certificate", func(config *Config) { config.TLS.KeyFile = "key.pem" }, "tls.cert_file and tls.key_file must be set together"},
+		{"redirect without TLS", func(config *Config) { config.TLS.RedirectAddr = ":80" }, "tls.redirect_addr needs tls.cert_file and tls.key_file"},
+		{"HSTS off", func(config *Config) { config.TLS.HSTSMaxAge = 0 }, ""},
+		{"negative HSTS", func(config *Config) { config.TLS.HSTSMaxAge = -time.Second }, "tls.hsts_max_age must not be negative"},
+		{"negative duration", func(config *Config) { config.Server.ReadTimeout = -time.Second }, "server.read_timeout must be positive"},
+		{"zero duration", func(config *Config) { config.Webhooks.Backoff = 0 }, "webhooks.backoff must be positive"},
+		{"zero size", func(config *Config) { config.Content.MaxPost = 0 }, "content.max_post must be positive"},
+		{"zero body size", func(config *Config) { config.Server.MaxBodySize = 0 }, "server.max_body_size must be positive"},
+		{"short session", func(config *Config) { config.Session.Lifetime = time.Second }, "session.lifetime must be at least 1m"},
+		{"proxies", func(config *Config) { config.Server.TrustedProxies = "10.0.0.0/8, 192.168.1.1, ::1" }, ""},
+		{"proxy network", func(config *Config) { config.Server.TrustedProxies = "10.0.0.0/33" }, `server.trusted_proxies: invalid proxy network "10.0.0.0/33"`},
+		{"proxy address", func(config *Config) { config.Server.TrustedProxies = "10.0.0.0, proxy.local" }, `server.trusted_proxies: invalid proxy address "proxy.local"`},
+		{"addr", func(config *Config) { config.Addr = "8080" }, `addr must be host:port or :port, not "8080"`},
+		{"base URL", func(config *Config) { config.BaseURL = "agora.test" }, `base_url must be an http or https URL, not "agora.test"`},
+		{"frame options", func(config *Config) { config.Security.FrameOptions = "ALLOW" }, `security.frame_options must be DENY or SAMEORIGIN, not "ALLOW"`},
+		{"CSP on two lines", func(config *Config) { config.Security.CSP = "default-src 'self'\r\nX-Evil: 1" }, "security.csp must be on one line"},
+		{"empty directory", func(config *Config) { config.AvatarDir = "" }, "avatar_dir must not be empty"},
+		{"no attachment types", func(config *Config) { config.Attachments.AllowedTypes = nil }, "attachments.allowed_types must not be empty"},
+		{"bad attachment type", func(config *Config) { config.Attachments.AllowedTypes = []string{"Image/PNG"} }, `attachments.allowed_types: "Image/PNG" is not a lowercase media type`},
+		{"SMTP address", func(config *Config) { config.Mail.SMTPAddr = "smtp.test" }, `mail.smtp_addr must be host:port, not "smtp.test"`},
+		{"log format", func(config *Config) { config.Log.Format = "xml" }, `log.format must be text or json, not "xml"`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := DefaultConfig()
+			test.change(&config)
+
+			err := config.Validate()
+			switch {
+			case test.want == "" && err != nil:
+				t.Errorf("the configuration is refused: %v", err)
+			case test.want != "" && (err == nil || !strings.Contains(err.Error(), test.want)):
+				t.Errorf("got the error %v, want %q", err, test.want)
+			}
+		})
+	}
+
+	// every problem is reported at once
+	config := DefaultConfig()
+	config.Addr = "nowhere"
+	config.Log.Level = "loud"
+	err := config.Validate()
+	if err == nil || !strings.Contains(err.Error(), "addr must be") || !strings.Contains(err.Error(), "log.level must be") {
+		t.Errorf("Validate reported %v", err)
+	}
+}
@@ -2,22 +2,18 @@ package functions
 
 import (
 	"database/sql"
-	"fmt"
+	"log/slog"
 	"net/http"
 )
 // CreateComment handles displaying a post's comments page and submitting a new comment.
 func (database Database) CreateComment(w http.ResponseWriter, r *http.Request) {
-	postID, err := extractPostID(r.URL.Path)
+	postID, err := pathID(r, "id")
 	if err != nil {
 		RenderError(w, "this post doesn't exist", 404)
 		return
 	}
 
-	storedToken, userID, err1 := authenticateUser(r, database.Db)
-	if userID == -1 {
-		RenderError(w, "please try later", 500)
-		return
-	}
+	userID, storedToken := sessionOf(r)
 
 	post, err := getPostWithDetails(postID, database.Db, storedToken, userID)
 	if err != nil {
@@ -26,49 +22,26 @@ func (database Database) CreateComment(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		fmt.Println("Failed to retrieve post", err)
+		slog.ErrorContext(r.Context(), "failed to retrieve post", "err", err)
 		RenderError(w, errPleaseTryLater, http.StatusInternalServerError)
 		return
 	}
 
-	data := CommentPageData{Post: *post}
+	data := CommentPageData{Post: *post, Content: database.Content}
 
-	if err1 == nil {
-		data.Token = storedToken
-
-		err := database.Db.QueryRow(Select_UserName, userID).Scan(&data.UserName)
-		if err != nil {
-			RenderError(w, "please try later", 500)
-			return
-		}
+	if user := CurrentUser(r); user != nil {
+		data.Token = user.Token
+		data.UserName = user.Name
+		data.Unread = unreadCount(database.Db, userID)
+		data.UnreadMessages = unreadMessagesCount(database.Db, userID)
 	}
 
-	switch r.Method {
-	case http.MethodGet:
-		if len(r.URL.RawQuery) > 0 {
-			RenderError(w, "Method not allowed", 405)
-			return
-		}
-
+	if r.Method == http.MethodGet {
 		ExecuteTemplate(w, "comments.html", &data, 200)
-
-	case http.MethodPost:
-		if err1 != nil {
-			http.Redirect(w, r, "/login", http.StatusSeeOther)
-			return
-		}
-
-		if !ValidCSRF(r, storedToken) {
-			RenderError(w, "Forbidden: CSRF Token Invalid", http.StatusForbidden)
-			return
-		}
-
-		handleComment(w, r, &data, database.Db, userID)
-
-	default:
-
-		RenderError(w, errMethodNotAllowed, http.StatusMethodNotAllowed)
+		return
 	}
+
+	handleComment(w, r, &data, database.Db, database.Hub, database.Content, userID)
 }
 
 // getPostWithDetails retrieves a post and loads its comments and metadata (token, user info).
@@ -80,6 +53,16 @@ func getPostWithDetails(postID int, db *sql.DB, storedToken string, userId int)
 
 	post.Token = storedToken
 
+	if userId > 0 {
+		if err := getUserSubscription(post, db, userId); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := getPostAttachments(post, db); err != nil {
+		return nil, err
+	}
+
 	if err := getPostComments(post, db, storedToken, userId); err != nil {
 		return nil, err
 	}
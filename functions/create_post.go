@@ -4,64 +4,49 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"log/slog"
 	"net/http"
 	"strings"
 	"unicode"
 )
 
-// CreatePost handles the /create/post route and displays or submits the post creation form.
+// CreatePost displays the post creation form on GET and submits it on POST.
 func (database Database) CreatePost(w http.ResponseWriter, r *http.Request) {
-	if r.URL.Path != "/create/post" {
-		RenderError(w, "Page not found", http.StatusNotFound)
-		return
-	}
+	user := CurrentUser(r)
 
-	storedToken, userID, err := authenticateUser(r, database.Db)
-	if userID == -1 {
-		RenderError(w, "please try later", 500)
+	if r.Method == http.MethodGet {
+		ExecuteTemplate(w, "post.html", PostPageData{CSRFToken: user.Token, Limits: database.Attachments, Content: database.Content}, 200)
 		return
 	}
 
-	if err != nil {
-		http.Redirect(w, r, "/login", http.StatusSeeOther)
-		return
-	}
+	CreatePostHandler(w, r, database, user.Id, user.Token)
+}
 
-	db := database.Db
+// CreatePostHandler validates the form and its attachments, and inserts the post into the database.
+func CreatePostHandler(w http.ResponseWriter, r *http.Request, database Database, userID int, storedToken string) {
+	err := r.ParseMultipartForm(32 << 20)
+	if err == http.ErrNotMultipart {
+		err = r.ParseForm()
+	}
 
-	switch r.Method {
-	case http.MethodGet:
-		if len(r.URL.RawQuery) > 0 {
-			RenderError(w, "Method not allowed", 405)
+	if err != nil {
+		var tooBig *http.MaxBytesError
+		if errors.As(err, &tooBig) {
+			RenderError(w, "your post and its files are too big", http.StatusRequestEntityTooLarge)
 			return
 		}
 
-		ExecuteTemplate(w, "post.html", PostPageData{CSRFToken: storedToken}, 200)
-
-	case http.MethodPost:
-		CreatePostHandler(w, r, db, userID, storedToken)
-
-	default:
-		RenderError(w, "Method not allowed", 405)
-	}
-}
-
-// CreatePostHandler validates the form, checks CSRF, and inserts the post into the database.
-func CreatePostHandler(w http.ResponseWriter, r *http.Request, db *sql.DB, userID int, storedToken string) {
-	err := r.ParseForm()
-	if err != nil {
 		RenderError(w, "Please try later", 500)
 		return
 	}
 
-	if !ValidCSRF(r, storedToken) {
-		RenderError(w, "Forbidden: CSRF Token Invalid", http.StatusForbidden)
-		return
+	if r.MultipartForm != nil {
+		defer r.MultipartForm.RemoveAll()
 	}
 
 	post := MY_Post{
 		Title:    r.FormValue("Title"),
-		Content:  r.FormValue("Content"),
+		Content:  strings.ReplaceAll(r.FormValue("Content"), "\r\n", "\n"),
 		Category: r.Form["Category"],
 	}
 	seen := map[string]bool{}
@@ -71,6 +56,8 @@ func CreatePostHandler(w http.ResponseWriter, r *http.Request, db *sql.DB, userI
 				ErrorMessege: errors.New("duplicated category"),
 				Post:         post,
 				CSRFToken:    storedToken,
+				Limits:       database.Attachments,
+				Content:      database.Content,
 			}
 			ExecuteTemplate(w, "post.html", PostPageData, 400)
 			return
@@ -79,21 +66,46 @@ func CreatePostHandler(w http.ResponseWriter, r *http.Request, db *sql.DB, userI
 		seen[cat] = true
 	}
 
-	err = validate_post(&post)
+	err = validate_post(&post, database.Content)
+	if err != nil {
+		PostPageData := PostPageData{
+			ErrorMessege: err,
+			Post:         post,
+			CSRFToken:    storedToken,
+			Limits:       database.Attachments,
+			Content:      database.Content,
+		}
+
+		ExecuteTemplate(w, "post.html", PostPageData, 400)
+		return
+	}
+
+	attachments, files, err := getAttachmentFiles(r.MultipartForm, database.Attachments)
 	if err != nil {
 		PostPageData := PostPageData{
 			ErrorMessege: err,
 			Post:         post,
 			CSRFToken:    storedToken,
+			Limits:       database.Attachments,
+			Content:      database.Content,
 		}
 
 		ExecuteTemplate(w, "post.html", PostPageData, 400)
 		return
 	}
 
-	err = InsertPostToDB(w, db, &post, userID)
+	if err := storeAttachments(database.Blobs, attachments, files); err != nil {
+		slog.ErrorContext(r.Context(), "failed to store attachments", "err", err)
+		RenderError(w, "please try later", 500)
+		return
+	}
+
+	post.Attachments = attachments
+
+	err = InsertPostToDB(w, database.Db, database.Hub, &post, userID)
 	if err != nil {
-		fmt.Println("failed to insert post in database: ", err)
+		deleteBlobs(database.Blobs, attachments)
+		slog.ErrorContext(r.Context(), "failed to insert post in database", "err", err)
 		RenderError(w, "please try later", 500)
 		return
 	}
@@ -102,7 +114,7 @@ func CreatePostHandler(w http.ResponseWriter, r *http.Request, db *sql.DB, userI
 }
 
 // validate_post checks title, content, characters, and categories for correctness.
-func validate_post(data *MY_Post) error {
+func validate_post(data *MY_Post, limits ContentLimits) error {
 	title := strings.TrimSpace(data.Title)
 	contenue := strings.TrimSpace(data.Content)
 
@@ -114,12 +126,12 @@ func validate_post(data *MY_Post) error {
 		return errors.New("content is empty")
 	}
 
-	if len(title) > 150 {
-		return errors.New("maximum number of title's character is 150")
+	if len(title) > limits.MaxTitle {
+		return fmt.Errorf("maximum number of title's character is %d", limits.MaxTitle)
 	}
 
-	if len(contenue) > 50000 {
-		return errors.New(" maximum number of  content's character 50000")
+	if len(contenue) > limits.MaxPost {
+		return fmt.Errorf("maximum number of content's character is %d", limits.MaxPost)
 	}
 
 	if len(data.Category) == 0 {
@@ -132,10 +144,8 @@ func validate_post(data *MY_Post) error {
 		}
 	}
 
-	for _, char := range contenue {
-		if !unicode.IsPrint(char) {
-			return fmt.Errorf("only printable characters are allowed")
-		}
+	if !IsPrintableText(contenue) {
+		return fmt.Errorf("only printable characters are allowed")
 	}
 
 	allowed := map[string]bool{
@@ -155,8 +165,9 @@ func validate_post(data *MY_Post) error {
 	return nil
 }
 
-// InsertPostToDB inserts a post and its categories inside a transaction.
-func InsertPostToDB(w http.ResponseWriter, db *sql.DB, data *MY_Post, UserId int) error {
+// InsertPostToDB inserts a post, its categories and its attachments inside a transaction,
+// queues it for the webhooks, then announces it on the live home feed.
+func InsertPostToDB(w http.ResponseWriter, db *sql.DB, hub *Hub, data *MY_Post, UserId int) error {
 	tx, err := db.Begin()
 	if err != nil {
 		return err
@@ -164,7 +175,14 @@ func InsertPostToDB(w http.ResponseWriter, db *sql.DB, data *MY_Post, UserId int
 
 	defer tx.Rollback()
 
-	result, err := tx.Exec(Insert_Post, UserId, data.Title, data.Content)
+	users, err := findMentions(tx, data.Content)
+	if err != nil {
+		return err
+	}
+
+	html, mentioned := RenderContent(data.Content, users)
+
+	result, err := tx.Exec(Insert_Post, UserId, data.Title, data.Content, string(html), markdownRevision)
 	if err != nil {
 		return err
 	}
@@ -174,15 +192,47 @@ func InsertPostToDB(w http.ResponseWriter, db *sql.DB, data *MY_Post, UserId int
 		return err
 	}
 
+	if err := saveMentions(tx, UserId, int(PostID), 0, mentioned); err != nil {
+		return err
+	}
+
+	// authors follow the discussion of their posts
+	if _, err := tx.Exec(Insert_Subscription, UserId, PostID); err != nil {
+		return err
+	}
+
 	categories_id, err := getCategoriesId(data.Category, tx)
 	if err != nil {
 		return err
 	}
 
+	if err := insertAttachments(tx, int(PostID), data.Attachments); err != nil {
+		return err
+	}
+
+	var author string
+	if err := tx.QueryRow(Select_UserName, UserId).Scan(&author); err != nil {
+		return err
+	}
+
+	// queued with the post, so a webhook never hears of a post that was rolled back
+	err = emitWebhook(tx, WebhookPostCreated, WebhookPost{
+		Id:         int(PostID),
+		Title:      data.Title,
+		Content:    data.Content,
+		Author:     author,
+		Categories: data.Category,
+	})
+	if err != nil {
+		return err
+	}
+
 	if err := insertInPost_Category(tx, int(PostID), categories_id); err != nil {
 		return err
 	}
 
+	publishPost(db, hub, int(PostID), data.Title, UserId)
+
 	return nil
 }
 
@@ -228,6 +278,7 @@ func getCategoriesId(Categories []string, tx *sql.Tx) ([]int, error) {
 func insertInPost_Category(tx *sql.Tx, postId int, categories_id []int) error {
 	stmt, err := tx.Prepare(INsert_Post_Category)
 	if err != nil {
+		return err
 	}
 
 	defer stmt.Close()
@@ -0,0 +1,143 @@
+package functions
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"html/template"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+// nonceWriter carries the CSP nonce of a response to the templates.
+type nonceWriter struct {
+	http.ResponseWriter
+	nonce string
+}
+
+// Unwrap lets http.ResponseController reach the connection, for the live streams and the chat.
+func (w *nonceWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// SecurityHeaders sets the security headers of every response, with a new CSP nonce each time.
+func SecurityHeaders(config SecurityConfig) Middleware {
+	policyHeader := "Content-Security-Policy"
+	if config.CSPReportOnly {
+		policyHeader = "Content-Security-Policy-Report-Only"
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			bytes := make([]byte, 16)
+			rand.Read(bytes)
+			nonce := base64.StdEncoding.EncodeToString(bytes)
+
+			header := w.Header()
+			header.Set("X-Content-Type-Options", "nosniff")
+			header.Set("X-Frame-Options", config.FrameOptions)
+			if config.ReferrerPolicy != "" {
+				header.Set("Referrer-Policy", config.ReferrerPolicy)
+			}
+			if config.CSP != "" {
+				header.Set(policyHeader, strings.ReplaceAll(config.CSP, "{nonce}", nonce))
+			}
+
+			next.ServeHTTP(&nonceWriter{ResponseWriter: w, nonce: nonce}, r)
+		})
+	}
+}
+
+// Nonce returns the CSP nonce of the response, templates get it with {{nonce}}.
+// It is empty outside of SecurityHeaders.
+func Nonce(w http.ResponseWriter) string {
+	if writer, ok := unwrapTo[*nonceWriter](w); ok {
+		return writer.nonce
+	}
+
+	return ""
+}
+
+// templateFuncs are the functions every page template can use.
+func templateFuncs(w http.ResponseWriter) template.FuncMap {
+	return template.FuncMap{
+		"nonce": func() string { return Nonce(w) },
+	}
+}
+
+// CSPReport logs the CSP violations that the browsers send to /csp-report, in the old
+// application/csp-report format or in the application/reports+json of the Reporting API.
+func CSPReport(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, 64<<10))
+	if err != nil {
+		RenderError(w, "bad request", 400)
+		return
+	}
+
+	violations, err := parseCSPReports(body)
+	if err != nil {
+		RenderError(w, "bad request", 400)
+		return
+	}
+
+	for _, violation := range violations {
+		slog.WarnContext(r.Context(), "csp violation", "directive", clip(violation.Directive()),
+			"blocked", clip(violation.BlockedURI), "document", clip(violation.DocumentURI),
+			"source", clip(violation.SourceFile), "line", violation.LineNumber,
+			"disposition", clip(violation.Disposition), "sample", clip(violation.ScriptSample))
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// parseCSPReports reads the violations of a report, in either format.
+func parseCSPReports(body []byte) ([]CSPViolation, error) {
+	var old CSPReportBody
+	if err := json.Unmarshal(body, &old); err == nil && old.Report != nil {
+		return []CSPViolation{*old.Report}, nil
+	}
+
+	var entries []CSPReportEntry
+	if err := json.Unmarshal(body, &entries); err != nil {
+		return nil, err
+	}
+
+	violations := []CSPViolation{}
+	for _, entry := range entries {
+		if entry.Type != "csp-violation" {
+			continue
+		}
+
+		violations = append(violations, CSPViolation{
+			DocumentURI:        entry.Body.DocumentURL,
+			EffectiveDirective: entry.Body.EffectiveDirective,
+			BlockedURI:         entry.Body.BlockedURL,
+			SourceFile:         entry.Body.SourceFile,
+			LineNumber:         entry.Body.LineNumber,
+			Disposition:        entry.Body.Disposition,
+			ScriptSample:       entry.Body.Sample,
+		})
+	}
+
+	return violations, nil
+}
+
+// Directive returns the directive that was violated, browsers fill one name or the other.
+func (violation CSPViolation) Directive() string {
+	if violation.EffectiveDirective != "" {
+		return violation.EffectiveDirective
+	}
+
+	return violation.ViolatedDirective
+}
+
+// clip shortens what the browsers report before it is logged.
+func clip(text string) string {
+	if len(text) > 200 {
+		return text[:200]
+	}
+
+	return text
+}
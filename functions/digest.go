@@ -0,0 +1,336 @@
+package functions
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	texttemplate "text/template"
+	"time"
+)
+
+// the frequencies of the email digest
+const (
+	DigestOff    = "off"
+	DigestDaily  = "daily"
+	DigestWeekly = "weekly"
+)
+
+// digestPeriods is the time covered by a digest of each frequency.
+var digestPeriods = map[string]time.Duration{
+	DigestDaily:  24 * time.Hour,
+	DigestWeekly: 7 * 24 * time.Hour,
+}
+
+// DigestJob sends the email digests from inside the server process.
+type DigestJob struct {
+	Db       *sql.DB
+	Mailer   Mailer
+	Secret   []byte        // signs the unsubscribe links
+	BaseURL  string        // of the links in the emails, like https://agora.example
+	Interval time.Duration // between two checks of the digests due
+}
+
+// Run sends the digests due now and then every Interval, until ctx is done.
+// It returns after the digest being sent when ctx is done.
+func (job DigestJob) Run(ctx context.Context) {
+	ticker := time.NewTicker(job.Interval)
+	defer ticker.Stop()
+
+	for {
+		job.SendDue(ctx, time.Now())
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// digestUser is a user who wants a digest.
+type digestUser struct {
+	id        int
+	name      string
+	email     string
+	frequency string
+	sentAt    sql.NullTime
+}
+
+// SendDue sends the digest of every user whose last one is older than its period, until ctx is done.
+// A digest with nothing new isn't sent, but it counts as sent.
+func (job DigestJob) SendDue(ctx context.Context, now time.Time) {
+	rows, err := job.Db.Query(Select_Digest_Users)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to load digest users", "err", err)
+		return
+	}
+
+	users := []digestUser{}
+	for rows.Next() {
+		var user digestUser
+		if err := rows.Scan(&user.id, &user.name, &user.email, &user.frequency, &user.sentAt); err != nil {
+			slog.ErrorContext(ctx, "failed to load digest users", "err", err)
+			rows.Close()
+			return
+		}
+		users = append(users, user)
+	}
+	rows.Close()
+
+	for _, user := range users {
+		if ctx.Err() != nil {
+			return
+		}
+
+		period := digestPeriods[user.frequency]
+
+		since := now.Add(-period)
+		if user.sentAt.Valid {
+			// a check a bit early is better than one interval late
+			if now.Sub(user.sentAt.Time) < period-job.Interval/2 {
+				continue
+			}
+			since = user.sentAt.Time
+		}
+
+		if err := job.sendDigest(user, since, now); err != nil {
+			slog.ErrorContext(ctx, "failed to send a digest", "user", user.name, "err", err)
+			continue
+		}
+
+		if _, err := job.Db.Exec(Update_Digest_Sent, now.UTC().Format(sqliteTime), user.id); err != nil {
+			slog.ErrorContext(ctx, "failed to save digest time", "err", err)
+		}
+	}
+}
+
+// sqliteTime is the format of CURRENT_TIMESTAMP, to compare with the dates stored by SQLite.
+const sqliteTime = "2006-01-02 15:04:05"
+
+// sendDigest gathers what happened since the last digest of the user and mails it.
+func (job DigestJob) sendDigest(user digestUser, since, now time.Time) error {
+	data, err := job.getDigestData(user, since.UTC().Format(sqliteTime))
+	if err != nil {
+		return err
+	}
+
+	if len(data.Categories) == 0 && len(data.Replies) == 0 && len(data.Discussions) == 0 {
+		return nil
+	}
+
+	var html bytes.Buffer
+	htmlTemplate, err := template.ParseFiles("templates/digest.html")
+	if err != nil {
+		return err
+	}
+
+	if err := htmlTemplate.Execute(&html, data); err != nil {
+		return err
+	}
+
+	var text bytes.Buffer
+	textTemplate, err := texttemplate.ParseFiles("templates/digest.txt")
+	if err != nil {
+		return err
+	}
+
+	if err := textTemplate.Execute(&text, data); err != nil {
+		return err
+	}
+
+	subject := "Your daily AGORA digest"
+	if user.frequency == DigestWeekly {
+		subject = "Your weekly AGORA digest"
+	}
+
+	return job.Mailer.Send(Mail{
+		To:      user.email,
+		Subject: subject,
+		Text:    text.String(),
+		HTML:    html.String(),
+		Headers: map[string]string{
+			// one-click unsubscribe (RFC 8058): mail clients POST to the link
+			"List-Unsubscribe":      "<" + data.UnsubscribeURL + ">",
+			"List-Unsubscribe-Post": "List-Unsubscribe=One-Click",
+		},
+	})
+}
+
+// getDigestData loads the top posts of the user's categories, the replies to their posts
+// and the discussions they follow by email, since the given SQLite time.
+func (job DigestJob) getDigestData(user digestUser, since string) (DigestData, error) {
+	data := DigestData{
+		UserName:       user.name,
+		Frequency:      user.frequency,
+		BaseURL:        job.BaseURL,
+		UnsubscribeURL: job.BaseURL + "/unsubscribe?token=" + signToken(job.Secret, "digest", strconv.Itoa(user.id)),
+	}
+
+	rows, err := job.Db.Query(Select_Digest_Categories, user.id)
+	if err != nil {
+		return data, err
+	}
+
+	categories := []DigestCategory{}
+	for rows.Next() {
+		var category DigestCategory
+		if err := rows.Scan(&category.Id, &category.Name); err != nil {
+			rows.Close()
+			return data, err
+		}
+		categories = append(categories, category)
+	}
+	rows.Close()
+
+	for _, category := range categories {
+		rows, err := job.Db.Query(Digest_Top_Posts, category.Id, since, user.id)
+		if err != nil {
+			return data, err
+		}
+
+		for rows.Next() {
+			var post DigestPost
+			if err := rows.Scan(&post.Id, &post.Title, &post.Author, &post.Likes, &post.Comments); err != nil {
+				rows.Close()
+				return data, err
+			}
+			category.Posts = append(category.Posts, post)
+		}
+		rows.Close()
+
+		if len(category.Posts) > 0 {
+			data.Categories = append(data.Categories, category)
+		}
+	}
+
+	rows, err = job.Db.Query(Digest_Replies, user.id, since)
+	if err != nil {
+		return data, err
+	}
+
+	for rows.Next() {
+		var reply DigestReply
+		if err := rows.Scan(&reply.PostId, &reply.PostTitle, &reply.Author, &reply.Excerpt); err != nil {
+			rows.Close()
+			return data, err
+		}
+
+		reply.Excerpt = strings.Join(strings.Fields(reply.Excerpt), " ")
+		if len(reply.Excerpt) > 140 {
+			reply.Excerpt = strings.ToValidUTF8(reply.Excerpt[:140], "") + "…"
+		}
+
+		data.Replies = append(data.Replies, reply)
+	}
+	rows.Close()
+
+	rows, err = job.Db.Query(Digest_Discussions, user.id, since)
+	if err != nil {
+		return data, err
+	}
+
+	for rows.Next() {
+		var discussion DigestDiscussion
+		if err := rows.Scan(&discussion.PostId, &discussion.Title, &discussion.Comments); err != nil {
+			rows.Close()
+			return data, err
+		}
+
+		discussion.UnsubscribeURL = job.BaseURL + "/unsubscribe?token=" + unsubscribeToken(job.Secret, user.id, discussion.PostId)
+		data.Discussions = append(data.Discussions, discussion)
+	}
+	rows.Close()
+
+	return data, rows.Err()
+}
+
+// AccountDigest saves the frequency and the categories of the email digest.
+func (database Database) AccountDigest(w http.ResponseWriter, r *http.Request) {
+	user := CurrentUser(r)
+
+	frequency := r.FormValue("frequency")
+	if frequency != DigestOff && digestPeriods[frequency] == 0 {
+		RenderError(w, "unknown digest frequency", 400)
+		return
+	}
+
+	categories := []int{}
+	for _, value := range r.Form["category"] {
+		id, err := strconv.Atoi(value)
+		if err != nil {
+			RenderError(w, "unknown category", 400)
+			return
+		}
+
+		var name string
+		if err := database.Db.QueryRow(Verify_CategoryID, id).Scan(&name); err != nil {
+			RenderError(w, "unknown category", 400)
+			return
+		}
+
+		categories = append(categories, id)
+	}
+
+	if err := saveDigest(database.Db, user.Id, frequency, categories); err != nil {
+		slog.ErrorContext(r.Context(), "failed to save digest settings", "err", err)
+		RenderError(w, errPleaseTryLater, 500)
+		return
+	}
+
+	http.Redirect(w, r, "/account", http.StatusSeeOther)
+}
+
+// saveDigest replaces the digest settings of the user.
+func saveDigest(db *sql.DB, userID int, frequency string, categories []int) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(Update_Digest, frequency, userID); err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(Delete_Digest_Categories, userID); err != nil {
+		return err
+	}
+
+	for _, categoryID := range categories {
+		if _, err := tx.Exec(Insert_Digest_Category, userID, categoryID); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// getDigestChoices loads the digest frequency of the user and every category, checked when it is in the digest.
+func getDigestChoices(db *sql.DB, userID int, data *AccountPageData) error {
+	if err := db.QueryRow(Select_Digest, userID).Scan(&data.Digest); err != nil {
+		return err
+	}
+
+	rows, err := db.Query(Select_Digest_Choices, userID)
+	if err != nil {
+		return err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var choice DigestChoice
+		if err := rows.Scan(&choice.Id, &choice.Name, &choice.Checked); err != nil {
+			return err
+		}
+		data.DigestCategories = append(data.DigestCategories, choice)
+	}
+
+	return rows.Err()
+}
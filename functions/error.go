@@ -3,8 +3,8 @@ package functions
 import (
 	"bytes"
 	"errors"
-	"fmt"
 	"html/template"
+	"log/slog"
 	"net/http"
 	"syscall"
 )
@@ -12,15 +12,16 @@ import (
 // RenderError renders a custom HTML error page with the given message and status code.
 func RenderError(w http.ResponseWriter, msg string, code int) {
 
-	tmpl, err := template.ParseFiles("templates/error.html")
+	tmpl, err := template.New("error.html").Funcs(templateFuncs(w)).ParseFiles("templates/error.html")
 	if err != nil {
 		http.Error(w, "Template parsing error", http.StatusInternalServerError)
 		return
 	}
 
 	data := ErrorPage{
-		Code:    code,
-		Message: msg,
+		Code:      code,
+		Message:   msg,
+		RequestID: RequestID(w),
 	}
 
 	var buf bytes.Buffer
@@ -33,7 +34,7 @@ func RenderError(w http.ResponseWriter, msg string, code int) {
 
 	if _, err := buf.WriteTo(w); err != nil {
 		if !errors.Is(err, syscall.EPIPE) {
-			fmt.Println("Failed to write buffer:", err)
+			slog.Error("failed to write buffer", "err", err, "request_id", RequestID(w))
 		}
 		return
 	}
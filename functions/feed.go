@@ -0,0 +1,388 @@
+package functions
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/xml"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const feedEntries = 50 // newest entries of a feed
+
+// feed is what the Atom and RSS versions of a feed are made of.
+type feed struct {
+	Title   string
+	Link    string // of the page the feed follows
+	Self    string // of the feed itself
+	Updated time.Time
+	Entries []feedEntry
+}
+
+type feedEntry struct {
+	Id         string // stable: the permalink of the post or the comment
+	Title      string
+	Link       string
+	Author     string
+	AuthorLink string
+	Published  time.Time
+	Categories []string
+	HTML       string
+}
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	Title   string      `xml:"title"`
+	Id      string      `xml:"id"`
+	Updated string      `xml:"updated"`
+	Links   []atomLink  `xml:"link"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Rel  string `xml:"rel,attr,omitempty"`
+	Type string `xml:"type,attr,omitempty"`
+	Href string `xml:"href,attr"`
+}
+
+type atomEntry struct {
+	Title      string         `xml:"title"`
+	Id         string         `xml:"id"`
+	Updated    string         `xml:"updated"`
+	Published  string         `xml:"published"`
+	Link       atomLink       `xml:"link"`
+	Author     atomAuthor     `xml:"author"`
+	Categories []atomCategory `xml:"category"`
+	Content    atomContent    `xml:"content"`
+}
+
+type atomAuthor struct {
+	Name string `xml:"name"`
+	URI  string `xml:"uri,omitempty"`
+}
+
+type atomCategory struct {
+	Term string `xml:"term,attr"`
+}
+
+type atomContent struct {
+	Type string `xml:"type,attr"`
+	Body string `xml:",chardata"`
+}
+
+type rssFeed struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	DC      string     `xml:"xmlns:dc,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title         string    `xml:"title"`
+	Link          string    `xml:"link"`
+	Description   string    `xml:"description"`
+	LastBuildDate string    `xml:"lastBuildDate,omitempty"`
+	Items         []rssItem `xml:"item"`
+}
+
+type rssItem struct {
+	Title       string   `xml:"title"`
+	Link        string   `xml:"link"`
+	Guid        rssGuid  `xml:"guid"`
+	PubDate     string   `xml:"pubDate"`
+	Creator     string   `xml:"dc:creator"`
+	Categories  []string `xml:"category"`
+	Description string   `xml:"description"`
+}
+
+type rssGuid struct {
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+	Value       string `xml:",chardata"`
+}
+
+// Feed serves the Atom and RSS 2.0 feeds of /feeds/all, /feeds/categories/{name}, /feeds/users/{name}
+// and /feeds/posts/{id} (the comments of a post), with the .atom or .rss extension.
+// Readers polling with If-None-Match or If-Modified-Since get a 304 when nothing changed.
+func (database Database) Feed(w http.ResponseWriter, r *http.Request) {
+	file := r.PathValue("file")
+	format := path.Ext(file)
+	if format != ".atom" && format != ".rss" {
+		RenderError(w, errPageNotFound, 404)
+		return
+	}
+
+	// /feeds/all.atom has no kind
+	kind, value := r.PathValue("kind"), strings.TrimSuffix(file, format)
+	if kind == "" {
+		kind, value = value, ""
+	}
+	// the configured URL, not the Host header: the ids must stay the same, and the feeds are cached
+	base := database.BaseURL
+
+	var data feed
+	var err error
+
+	switch {
+	case kind == "all" && value == "":
+		data, err = postsFeed(database.Db, base, Feed_All, feedEntries)
+		data.Title, data.Link = "AGORA FORUM", base+"/"
+
+	case kind == "categories" && value != "" && AreValidCategories([]string{value}):
+		data, err = postsFeed(database.Db, base, Feed_Category, value, feedEntries)
+		data.Title, data.Link = "AGORA FORUM: "+value, base+"/?category="+url.QueryEscape(value)
+
+	case kind == "users" && value != "" && value != DeletedUserName:
+		var profile *UserProfile
+		profile, err = getProfile(database.Db, value)
+		if err == sql.ErrNoRows {
+			RenderError(w, "this user doesn't exist", 404)
+			return
+		}
+
+		if err == nil {
+			data, err = postsFeed(database.Db, base, Feed_Author, profile.Id, feedEntries)
+			data.Title, data.Link = "AGORA FORUM: posts of "+profile.Name, base+"/users/"+profile.Name
+		}
+
+	case kind == "posts":
+		postID, convErr := strconv.Atoi(value)
+		if convErr != nil {
+			RenderError(w, errPageNotFound, 404)
+			return
+		}
+
+		var title string
+		if database.Db.QueryRow(Verify_PostID, postID).Scan(&title) != nil {
+			RenderError(w, "this post doesn't exist", 404)
+			return
+		}
+
+		data, err = commentsFeed(database.Db, base, postID)
+
+	default:
+		RenderError(w, errPageNotFound, 404)
+		return
+	}
+
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to load feed", "err", err)
+		RenderError(w, errPleaseTryLater, 500)
+		return
+	}
+
+	data.Self = base + r.URL.Path
+
+	var body []byte
+	if format == ".atom" {
+		body, err = data.atom()
+		w.Header().Set("Content-Type", "application/atom+xml; charset=utf-8")
+	} else {
+		body, err = data.rss()
+		w.Header().Set("Content-Type", "application/rss+xml; charset=utf-8")
+	}
+
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to encode feed", "err", err)
+		RenderError(w, errPleaseTryLater, 500)
+		return
+	}
+
+	sum := sha256.Sum256(body)
+	w.Header().Set("ETag", `"`+hex.EncodeToString(sum[:16])+`"`)
+	w.Header().Set("Cache-Control", "public, max-age=300")
+
+	// answers the conditional requests and sets Last-Modified, unless the feed is empty
+	http.ServeContent(w, r, "", data.Updated, bytes.NewReader(body))
+}
+
+// postsFeed builds a feed of the posts whose ids query selects with args, the newest first.
+func postsFeed(db *sql.DB, base string, query string, args ...any) (feed, error) {
+	data := feed{}
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return data, err
+	}
+	defer rows.Close()
+
+	postIDs := []int{}
+	for rows.Next() {
+		var postID int
+		if err := rows.Scan(&postID); err != nil {
+			return data, err
+		}
+		postIDs = append(postIDs, postID)
+	}
+
+	if err := rows.Err(); err != nil {
+		return data, err
+	}
+
+	// the rows must be closed before getPost caches the HTML, sqlite would see the update as a conflicting write
+	rows.Close()
+
+	for _, postID := range postIDs {
+		post, err := getPost(postID, db, 0)
+		if err != nil {
+			return data, err
+		}
+
+		link := base + "/posts/" + strconv.Itoa(post.Id)
+
+		data.Entries = append(data.Entries, feedEntry{
+			Id:         link,
+			Title:      post.Title,
+			Link:       link,
+			Author:     post.AuthorName,
+			AuthorLink: base + "/users/" + post.AuthorName,
+			Published:  post.CreatedAt,
+			Categories: post.Categories,
+			HTML:       string(post.HTML),
+		})
+
+		data.Updated = latest(data.Updated, post.CreatedAt)
+	}
+
+	return data, nil
+}
+
+// commentsFeed builds the feed of the newest comments of a post with getPostComments.
+func commentsFeed(db *sql.DB, base string, postID int) (feed, error) {
+	post, err := getPost(postID, db, 0)
+	if err != nil {
+		return feed{}, err
+	}
+
+	if err := getPostComments(post, db, "", 0); err != nil {
+		return feed{}, err
+	}
+
+	link := base + "/posts/" + strconv.Itoa(post.Id)
+	data := feed{
+		Title:   "AGORA FORUM: comments on " + post.Title,
+		Link:    link,
+		Updated: post.CreatedAt,
+	}
+
+	comments := post.Comments
+	if len(comments) > feedEntries {
+		comments = comments[:feedEntries]
+	}
+
+	for _, comment := range comments {
+		commentLink := link + "#comment-" + strconv.Itoa(comment.Id)
+
+		data.Entries = append(data.Entries, feedEntry{
+			Id:         commentLink,
+			Title:      "Comment by " + comment.AuthorName + " on " + post.Title,
+			Link:       commentLink,
+			Author:     comment.AuthorName,
+			AuthorLink: base + "/users/" + comment.AuthorName,
+			Published:  comment.CreatedAt,
+			HTML:       string(comment.HTML),
+		})
+
+		data.Updated = latest(data.Updated, comment.CreatedAt)
+	}
+
+	return data, nil
+}
+
+func latest(a, b time.Time) time.Time {
+	if b.After(a) {
+		return b
+	}
+
+	return a
+}
+
+// atom encodes the feed as Atom (RFC 4287).
+func (data feed) atom() ([]byte, error) {
+	updated := data.Updated
+	if updated.IsZero() {
+		updated = time.Unix(0, 0)
+	}
+
+	out := atomFeed{
+		Title:   data.Title,
+		Id:      data.Self,
+		Updated: updated.UTC().Format(time.RFC3339),
+		Links: []atomLink{
+			{Rel: "self", Type: "application/atom+xml", Href: data.Self},
+			{Rel: "alternate", Type: "text/html", Href: data.Link},
+		},
+	}
+
+	for _, entry := range data.Entries {
+		date := entry.Published.UTC().Format(time.RFC3339)
+
+		atom := atomEntry{
+			Title:     entry.Title,
+			Id:        entry.Id,
+			Updated:   date, // posts and comments aren't edited
+			Published: date,
+			Link:      atomLink{Rel: "alternate", Type: "text/html", Href: entry.Link},
+			Author:    atomAuthor{Name: entry.Author},
+			Content:   atomContent{Type: "html", Body: entry.HTML},
+		}
+
+		if entry.Author != DeletedUserName {
+			atom.Author.URI = entry.AuthorLink
+		}
+
+		for _, category := range entry.Categories {
+			atom.Categories = append(atom.Categories, atomCategory{Term: category})
+		}
+
+		out.Entries = append(out.Entries, atom)
+	}
+
+	return encodeXML(out)
+}
+
+// rss encodes the feed as RSS 2.0.
+func (data feed) rss() ([]byte, error) {
+	out := rssFeed{
+		Version: "2.0",
+		DC:      "http://purl.org/dc/elements/1.1/",
+		Channel: rssChannel{
+			Title:       data.Title,
+			Link:        data.Link,
+			Description: data.Title,
+		},
+	}
+
+	if !data.Updated.IsZero() {
+		out.Channel.LastBuildDate = data.Updated.UTC().Format(time.RFC1123Z)
+	}
+
+	for _, entry := range data.Entries {
+		out.Channel.Items = append(out.Channel.Items, rssItem{
+			Title:       entry.Title,
+			Link:        entry.Link,
+			Guid:        rssGuid{IsPermaLink: true, Value: entry.Id},
+			PubDate:     entry.Published.UTC().Format(time.RFC1123Z),
+			Creator:     entry.Author,
+			Categories:  entry.Categories,
+			Description: entry.HTML,
+		})
+	}
+
+	return encodeXML(out)
+}
+
+func encodeXML(value any) ([]byte, error) {
+	body, err := xml.MarshalIndent(value, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	return append([]byte(xml.Header), body...), nil
+}
@@ -4,39 +4,107 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"html/template"
+	"log/slog"
 	"net/http"
 	"strings"
+	"time"
 )
 
-// handleComment validates and stores a new comment, then reloads the same post page.
-func handleComment(w http.ResponseWriter, r *http.Request, data *CommentPageData, db *sql.DB, userID int) {
+// handleComment validates and stores a new comment, publishes it to the live streams and the webhooks, then reloads the same post page.
+func handleComment(w http.ResponseWriter, r *http.Request, data *CommentPageData, db *sql.DB, hub *Hub, limits ContentLimits, userID int) {
 	if err := r.ParseForm(); err != nil {
-		fmt.Println("Failed to parse comment form", err)
+		slog.ErrorContext(r.Context(), "failed to parse comment form", "err", err)
 		RenderError(w, "please try later", 500)
 		return
 	}
 
-	content := strings.TrimSpace(r.FormValue("content"))
+	content := strings.TrimSpace(strings.ReplaceAll(r.FormValue("content"), "\r\n", "\n"))
 
-	if err := isValidComment(content); err != nil {
+	if err := isValidComment(content, limits); err != nil {
 		data.Error = err.Error()
 		data.PrevContent = content
 		ExecuteTemplate(w, "comments.html", data, 400)
 		return
 	}
 
-	_, err := db.Exec(Insert_Comment, data.Post.Id, userID, content)
+	commentID, html, err := insertComment(db, data.Post.Id, userID, content)
 	if err != nil {
-		fmt.Println("Failed to insert comment: %w", err)
+		slog.ErrorContext(r.Context(), "failed to insert comment", "err", err)
 		RenderError(w, errPleaseTryLater, http.StatusInternalServerError)
 		return
 	}
 
+	hub.Publish(PostTopic(data.Post.Id), "comment", LiveComment{
+		Id:     commentID,
+		PostId: data.Post.Id,
+		Author: data.UserName,
+		HTML:   string(html),
+		Date:   time.Now().Format("2006 Jan 2 15:04"),
+	})
+	publishPostCounts(db, hub, data.Post.Id)
+
+	// the comment is saved, a missing webhook is not worth an error page
+	err = emitWebhook(db, WebhookCommentCreated, WebhookComment{
+		Id:        commentID,
+		PostId:    data.Post.Id,
+		PostTitle: data.Post.Title,
+		Content:   content,
+		Author:    data.UserName,
+	})
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to queue comment webhook", "err", err)
+	}
+
 	http.Redirect(w, r, r.URL.Path, http.StatusSeeOther)
 }
 
-// HandleReaction inserts, updates or removes a like/dislike for a post or comment.
-func HandleReaction(db *sql.DB, userID, targetID int, target, reactionType string) error {
+// insertComment stores a comment with its rendered HTML and the users it mentions,
+// it returns the id and the HTML of the new comment.
+func insertComment(db *sql.DB, postID, userID int, content string) (int, template.HTML, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, "", err
+	}
+
+	defer tx.Rollback()
+
+	users, err := findMentions(tx, content)
+	if err != nil {
+		return 0, "", err
+	}
+
+	html, mentioned := RenderContent(content, users)
+
+	result, err := tx.Exec(Insert_Comment, postID, userID, content, string(html), markdownRevision)
+	if err != nil {
+		return 0, "", err
+	}
+
+	commentID, err := result.LastInsertId()
+	if err != nil {
+		return 0, "", err
+	}
+
+	if err := saveMentions(tx, userID, postID, int(commentID), mentioned); err != nil {
+		return 0, "", err
+	}
+
+	// commenters follow the rest of the discussion
+	if _, err := tx.Exec(Insert_Subscription, userID, postID); err != nil {
+		return 0, "", err
+	}
+
+	if err := notifySubscribers(tx, userID, postID, int(commentID)); err != nil {
+		return 0, "", err
+	}
+
+	return int(commentID), html, tx.Commit()
+}
+
+// HandleReaction inserts, updates or removes a like/dislike for a post or comment,
+// then publishes the new counts to the live streams and the webhooks.
+func HandleReaction(db *sql.DB, hub *Hub, userID, targetID int, target, reactionType string) error {
 	isLike := (reactionType == "like")
 
 	targetColumn := "post_id"
@@ -49,30 +117,51 @@ func HandleReaction(db *sql.DB, userID, targetID int, target, reactionType strin
 
 	query := "SELECT id, is_like FROM reaction WHERE user_id = ? AND " + targetColumn + " = ?"
 	err := db.QueryRow(query, userID, targetID).Scan(&reactionID, &existingLike)
+	reaction := reactionType
 
 	switch {
 	case err == sql.ErrNoRows:
 		insert := "INSERT INTO reaction (user_id, " + targetColumn + ", is_like) VALUES (?, ?, ?)"
 		_, err = db.Exec(insert, userID, targetID, isLike)
-		return err
 
 	case err != nil:
 		return err
 
+	case existingLike == isLike:
+		_, err = db.Exec("DELETE FROM reaction WHERE id = ?", reactionID)
+		reaction = "none"
+
 	default:
+		_, err = db.Exec("UPDATE reaction SET is_like = ? WHERE id = ?", isLike, reactionID)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if target == "comment" {
+		publishCommentCounts(db, hub, targetID)
+	} else {
+		publishPostCounts(db, hub, targetID)
+	}
 
-		if existingLike == isLike {
-			_, err = db.Exec("DELETE FROM reaction WHERE id = ?", reactionID)
-		} else {
-			_, err = db.Exec("UPDATE reaction SET is_like = ? WHERE id = ?", isLike, reactionID)
+	// removing a reaction tells nobody
+	if isLike && target == "comment" && !(reactionID > 0 && existingLike) {
+		// the like is saved, a missing notification is not worth an error page
+		if err := notifyCommentAuthor(db, userID, targetID); err != nil {
+			slog.Error("failed to notify comment author", "err", err)
 		}
+	}
 
-		return err
+	if err := emitReactionWebhook(db, userID, targetID, target, reaction); err != nil {
+		slog.Error("failed to queue reaction webhook", "err", err)
 	}
+
+	return nil
 }
 
 
-// GetFilteredPosts retrieves posts based on the selected filter (mine, liked, or all) and category constraints.
+// GetFilteredPosts retrieves posts based on the selected filter (mine, liked, saved, following, or all) and category constraints.
 func GetFilteredPosts(db *sql.DB, categories []string, UserId int, filter, storedToken string, data *HomePageData) ([]Post, error) {
 	posts := []Post{}
 	var rows *sql.Rows
@@ -84,7 +173,7 @@ func GetFilteredPosts(db *sql.DB, categories []string, UserId int, filter, store
 		guest = true
 	}
 
-	if guest && (filter == "mine" || filter == "liked") {
+	if guest && (filter == "mine" || filter == "liked" || filter == "saved" || filter == "following") {
 		return nil, errors.New("redirect")
 	}
 
@@ -97,6 +186,14 @@ func GetFilteredPosts(db *sql.DB, categories []string, UserId int, filter, store
 		data.Filter = filter
 		rows, err = db.Query(Filter_Liked, UserId)
 
+	case "saved": // only get the posts bookmarked by the user, the last saved first
+		data.Filter = filter
+		rows, err = db.Query(Filter_Saved, UserId)
+
+	case "following": // only get the posts of the users followed by the user
+		data.Filter = filter
+		rows, err = db.Query(Filter_Following, UserId)
+
 	case "": // get all the posts
 		rows, err = db.Query(No_Filter)
 	default:
@@ -117,6 +214,7 @@ func GetFilteredPosts(db *sql.DB, categories []string, UserId int, filter, store
 		allowed[category] = true
 	}
 
+	postIds := []int{}
 	for rows.Next() {
 		var postId int
 
@@ -125,6 +223,17 @@ func GetFilteredPosts(db *sql.DB, categories []string, UserId int, filter, store
 			return nil, err
 		}
 
+		postIds = append(postIds, postId)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// the rows must be closed before getPost caches the HTML, sqlite would see the update as a conflicting write
+	rows.Close()
+
+	for _, postId := range postIds {
 		post, err := getPost(postId, db, UserId)
 		if err != nil {
 			return nil, err
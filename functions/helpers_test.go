@@ -0,0 +1,118 @@
+package functions
+
+import (
+	"database/sql"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func TestMain(m *testing.M) {
+	// the templates are read from the root of the repository, like the server does
+	if err := os.Chdir(".."); err != nil {
+		panic(err)
+	}
+
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	os.Exit(m.Run())
+}
+
+// newTestDatabase returns a Database on a new SQLite file, with the tables of the server.
+func newTestDatabase(t *testing.T) Database {
+	t.Helper()
+
+	dir := t.TempDir()
+	db, err := sql.Open("sqlite3", filepath.Join(dir, "forum.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	if _, err := db.Exec(Initialize); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := Migrate(db); err != nil {
+		t.Fatal(err)
+	}
+
+	return Database{
+		Db:          db,
+		Blobs:       DiskBlobStore{Root: filepath.Join(dir, "attachments")},
+		Attachments: DefaultAttachmentLimits,
+		Content:     DefaultContentLimits,
+		Session:     SessionConfig{Lifetime: time.Hour},
+		Hub:         NewHub(),
+		Secret:      []byte("test secret"),
+		AvatarDir:   filepath.Join(dir, "avatars"),
+		BaseURL:     "http://agora.test",
+	}
+}
+
+// testUser is a user of the test database with a session.
+type testUser struct {
+	Id      int
+	Name    string
+	Session string
+	Token   string
+}
+
+// addTestUser creates the user name and logs them in.
+func addTestUser(t *testing.T, database Database, name string) testUser {
+	t.Helper()
+
+	result, err := database.Db.Exec(Insert_User, name, name+"@agora.test", "not a hash")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	id, _ := result.LastInsertId()
+	user := testUser{Id: int(id), Name: name, Session: name + "-session", Token: name + "-token"}
+
+	_, err = database.Db.Exec(addCookie, user.Session, user.Token, user.Id, time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return user
+}
+
+// serve sends a request to handler as user, nil for a visitor. The form is sent with the CSRF token of user.
+func serve(handler http.Handler, user *testUser, method, target string, form url.Values) *httptest.ResponseRecorder {
+	var body io.Reader
+	if form != nil {
+		if user != nil {
+			form.Set("csrf_token", user.Token)
+		}
+		body = strings.NewReader(form.Encode())
+	}
+
+	r := httptest.NewRequest(method, target, body)
+	if form != nil {
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	if user != nil {
+		r.AddCookie(&http.Cookie{Name: "session", Value: user.Session})
+	}
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	return w
+}
+
+// newTestRouter returns a router with the middleware of the server.
+func newTestRouter(database Database) *Router {
+	router := NewRouter()
+	router.Use(RequestLog, Recover, database.Authenticate, CheckCSRF())
+	return router
+}
@@ -0,0 +1,334 @@
+package functions
+
+import (
+	"html"
+	"regexp"
+	"strings"
+)
+
+// syntax describes how to find the tokens of a language for Highlight.
+type syntax struct {
+	keywords        map[string]bool
+	builtins        map[string]bool
+	lineComments    []string
+	blockComment    [2]string
+	quotes          string
+	tripleQuotes    bool // python """docstrings"""
+	variables       bool // shell $NAME and ${NAME}
+	caseInsensitive bool // SQL keywords
+}
+
+func words(list string) map[string]bool {
+	set := map[string]bool{}
+	for _, word := range strings.Fields(list) {
+		set[word] = true
+	}
+	return set
+}
+
+var syntaxes = map[string]syntax{
+	"go": {
+		keywords: words(`break case chan const continue default defer else fallthrough for func go goto if
+			import interface map package range return select struct switch type var`),
+		builtins: words(`append bool byte cap close complex copy delete error false float32 float64 int int8
+			int16 int32 int64 iota len make new nil panic print println recover rune string true uint uint8
+			uint16 uint32 uint64 uintptr any min max clear`),
+		lineComments: []string{"//"},
+		blockComment: [2]string{"/*", "*/"},
+		quotes:       "\"'`",
+	},
+	"python": {
+		keywords: words(`and as assert async await break class continue def del elif else except finally for
+			from global if import in is lambda nonlocal not or pass raise return try while with yield`),
+		builtins: words(`True False None self print len range str int float list dict set tuple open
+			isinstance super enumerate zip map filter sorted`),
+		lineComments: []string{"#"},
+		quotes:       "\"'",
+		tripleQuotes: true,
+	},
+	"js": {
+		keywords: words(`async await break case catch class const continue debugger default delete do else
+			export extends finally for from function if import in instanceof let new of return static super
+			switch this throw try typeof var void while yield`),
+		builtins: words(`true false null undefined NaN Infinity console window document JSON Math Promise
+			Array Object String Number Boolean Map Set Error require module`),
+		lineComments: []string{"//"},
+		blockComment: [2]string{"/*", "*/"},
+		quotes:       "\"'`",
+	},
+	"sql": {
+		keywords: words(`select from where insert into values update set delete create table drop alter add
+			column index primary key foreign references join inner left right outer full on as and or not
+			null is in like between group by order having limit offset union all distinct case when then
+			else end exists default unique check if begin commit rollback transaction asc desc with`),
+		builtins: words(`count sum avg min max coalesce ifnull nullif length lower upper substr trim
+			integer text real blob datetime boolean varchar current_timestamp true false`),
+		lineComments:    []string{"--"},
+		blockComment:    [2]string{"/*", "*/"},
+		quotes:          "'\"",
+		caseInsensitive: true,
+	},
+	"shell": {
+		keywords: words(`if then else elif fi for while until do done case esac in function return exit
+			export local readonly`),
+		builtins: words(`echo cd ls cat grep sed awk find rm cp mv mkdir chmod chown sudo apt git go
+			docker curl printf read source test true false`),
+		lineComments: []string{"#"},
+		quotes:       "\"'",
+		variables:    true,
+	},
+}
+
+var languageAliases = map[string]string{
+	"go":         "go",
+	"golang":     "go",
+	"python":     "python",
+	"py":         "python",
+	"python3":    "python",
+	"js":         "js",
+	"javascript": "js",
+	"node":       "js",
+	"jsx":        "js",
+	"ts":         "js",
+	"typescript": "js",
+	"sql":        "sql",
+	"sqlite":     "sql",
+	"mysql":      "sql",
+	"postgresql": "sql",
+	"sh":         "shell",
+	"bash":       "shell",
+	"shell":      "shell",
+	"zsh":        "shell",
+	"console":    "shell",
+}
+
+// detectHints are scored against code blocks without a language name.
+var detectHints = map[string][]*regexp.Regexp{
+	"go": {
+		regexp.MustCompile(`(?m)^package \w+`),
+		regexp.MustCompile(`\bfunc\b`),
+		regexp.MustCompile(`:=`),
+		regexp.MustCompile(`\bfmt\.`),
+		regexp.MustCompile(`\berr != nil\b`),
+	},
+	"python": {
+		regexp.MustCompile(`(?m)^\s*def \w+\(.*\):`),
+		regexp.MustCompile(`(?m)^\s*(from \w+ )?import \w+`),
+		regexp.MustCompile(`\bself\b`),
+		regexp.MustCompile(`\belif\b`),
+		regexp.MustCompile(`(?m):\s*$`),
+	},
+	"js": {
+		regexp.MustCompile(`\bfunction\b`),
+		regexp.MustCompile(`\b(const|let)\s+\w+\s*=`),
+		regexp.MustCompile(`=>`),
+		regexp.MustCompile(`\bconsole\.`),
+		regexp.MustCompile(`;\s*$`),
+	},
+	"sql": {
+		regexp.MustCompile(`(?i)\bselect\b.*\bfrom\b`),
+		regexp.MustCompile(`(?i)\binsert\s+into\b`),
+		regexp.MustCompile(`(?i)\bcreate\s+table\b`),
+		regexp.MustCompile(`(?i)\bwhere\b`),
+		regexp.MustCompile(`(?i)\bupdate\s+\w+\s+set\b`),
+	},
+	"shell": {
+		regexp.MustCompile(`^#!.*\b(ba|z)?sh\b`),
+		regexp.MustCompile(`(?m)^\$ `),
+		regexp.MustCompile(`(?m)^\s*(sudo|echo|cd|export|apt|git|docker|curl)\b`),
+		regexp.MustCompile(`\|\s*(grep|awk|sed|xargs)\b`),
+		regexp.MustCompile(`\$\{?\w+\}?`),
+	},
+}
+
+// canonicalLanguage resolves a fence language name, or guesses it from the code when the name is unknown.
+func canonicalLanguage(name, code string) string {
+	if language, ok := languageAliases[name]; ok {
+		return language
+	}
+
+	if name != "" {
+		return ""
+	}
+
+	return DetectLanguage(code)
+}
+
+// DetectLanguage guesses the language of a code block, it returns "" when nothing matches well enough.
+func DetectLanguage(code string) string {
+	best, bestScore := "", 1
+
+	for _, language := range []string{"go", "python", "js", "sql", "shell"} {
+		score := 0
+		for _, hint := range detectHints[language] {
+			if hint.MatchString(code) {
+				score++
+			}
+		}
+
+		if score > bestScore {
+			best, bestScore = language, score
+		}
+	}
+
+	return best
+}
+
+// Highlight returns the escaped code with its tokens wrapped in <span class="hl-..."> elements:
+// hl-kw (keywords), hl-bi (builtins), hl-str, hl-num, hl-com (comments) and hl-var (shell variables).
+// Unknown languages are only escaped.
+func Highlight(code, language string) string {
+	lang, ok := syntaxes[language]
+	if !ok {
+		return html.EscapeString(code)
+	}
+
+	var out strings.Builder
+	plain := 0 // start of the text not written yet
+
+	flush := func(end int) {
+		out.WriteString(html.EscapeString(code[plain:end]))
+	}
+	emit := func(start, end int, class string) {
+		flush(start)
+		out.WriteString(`<span class="` + class + `">` + html.EscapeString(code[start:end]) + "</span>")
+		plain = end
+	}
+
+	for i := 0; i < len(code); {
+		rest := code[i:]
+
+		if lineComment(lang, rest) {
+			end := strings.IndexByte(rest, '\n')
+			if end < 0 {
+				end = len(rest)
+			}
+			emit(i, i+end, "hl-com")
+			i += end
+			continue
+		}
+
+		if lang.blockComment[0] != "" && strings.HasPrefix(rest, lang.blockComment[0]) {
+			end := strings.Index(rest[2:], lang.blockComment[1])
+			if end < 0 {
+				end = len(rest)
+			} else {
+				end += 2 + len(lang.blockComment[1])
+			}
+			emit(i, i+end, "hl-com")
+			i += end
+			continue
+		}
+
+		ch := rest[0]
+
+		switch {
+		case strings.IndexByte(lang.quotes, ch) >= 0:
+			// raw strings and single-quoted shell words have no escapes
+			escapes := ch != '`' && !(language == "shell" && ch == '\'')
+			end := stringEnd(rest, lang.tripleQuotes, escapes)
+			emit(i, i+end, "hl-str")
+			i += end
+
+		case lang.variables && ch == '$' && len(rest) > 1:
+			end := variableEnd(rest)
+			if end == 1 {
+				i++
+				continue
+			}
+			emit(i, i+end, "hl-var")
+			i += end
+
+		case ch >= '0' && ch <= '9' && (i == 0 || !isWordChar(code[i-1])):
+			end := 1
+			for end < len(rest) && (isWordChar(rest[end]) || rest[end] == '.') {
+				end++
+			}
+			emit(i, i+end, "hl-num")
+			i += end
+
+		case isWordChar(ch) && (i == 0 || !isWordChar(code[i-1])):
+			end := 1
+			for end < len(rest) && isWordChar(rest[end]) {
+				end++
+			}
+
+			word := rest[:end]
+			if lang.caseInsensitive {
+				word = strings.ToLower(word)
+			}
+
+			switch {
+			case lang.keywords[word]:
+				emit(i, i+end, "hl-kw")
+			case lang.builtins[word]:
+				emit(i, i+end, "hl-bi")
+			}
+			i += end
+
+		default:
+			i++
+		}
+	}
+
+	flush(len(code))
+	return out.String()
+}
+
+func lineComment(lang syntax, rest string) bool {
+	for _, prefix := range lang.lineComments {
+		if strings.HasPrefix(rest, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// stringEnd returns the length of the string literal at the start of s, up to the closing quote.
+func stringEnd(s string, triple, escapes bool) int {
+	quote := s[:1]
+
+	if triple && (strings.HasPrefix(s, `"""`) || strings.HasPrefix(s, `'''`)) {
+		end := strings.Index(s[3:], s[:3])
+		if end < 0 {
+			return len(s)
+		}
+		return end + 6
+	}
+
+	for i := 1; i < len(s); i++ {
+		switch {
+		case escapes && s[i] == '\\':
+			i++
+		case s[i:i+1] == quote:
+			return i + 1
+		case s[i] == '\n' && quote != "`":
+			return i
+		}
+	}
+
+	return len(s)
+}
+
+// variableEnd returns the length of a shell variable ($NAME, ${NAME}, $1, $?) at the start of s.
+func variableEnd(s string) int {
+	if s[1] == '{' {
+		end := strings.IndexByte(s, '}')
+		if end < 0 {
+			return 1
+		}
+		return end + 1
+	}
+
+	if strings.IndexByte("?#@*!$0123456789", s[1]) >= 0 {
+		return 2
+	}
+
+	end := 1
+	for end < len(s) && isWordChar(s[end]) {
+		end++
+	}
+
+	return end
+}
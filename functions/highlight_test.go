@@ -0,0 +1,96 @@
+package functions
+
+import "testing"
+
+// span is the markup Highlight wraps a token in.
+func span(class, token string) string {
+	return `<span class="hl-` + class + `">` + token + "</span>"
+}
+
+func TestHighlight(t *testing.T) {
+	tests := []struct {
+		name, language, code, want string
+	}{
+		{"go", "go", "func main() { x := len(\"a\") + 42 // done\n}",
+			span("kw", "func") + " main() { x := " + span("bi", "len") + "(" + span("str", "&#34;a&#34;") + ") + " +
+				span("num", "42") + " " + span("com", "// done") + "\n}"},
+		{"python", "python", "def f(self):\n    return None  # x\n'''doc'''",
+			span("kw", "def") + " f(" + span("bi", "self") + "):\n    " + span("kw", "return") + " " + span("bi", "None") +
+				"  " + span("com", "# x") + "\n" + span("str", "&#39;&#39;&#39;doc&#39;&#39;&#39;")},
+		{"js", "js", "const x = `a${b}` /* c */ && null;",
+			span("kw", "const") + " x = " + span("str", "`a${b}`") + " " + span("com", "/* c */") + " &amp;&amp; " + span("bi", "null") + ";"},
+		{"sql", "sql", "SELECT count(*) FROM post WHERE id = 'a' -- c",
+			span("kw", "SELECT") + " " + span("bi", "count") + "(*) " + span("kw", "FROM") + " post " + span("kw", "WHERE") +
+				" id = " + span("str", "&#39;a&#39;") + " " + span("com", "-- c")},
+		{"shell", "shell", "echo \"$HOME\" ${USER} $1 '$x' # c",
+			span("bi", "echo") + " " + span("str", "&#34;$HOME&#34;") + " " + span("var", "${USER}") + " " + span("var", "$1") +
+				" " + span("str", "&#39;$x&#39;") + " " + span("com", "# c")},
+		{"unknown language", "", `<x> & "y"`, "&lt;x&gt; &amp; &#34;y&#34;"},
+
+		{"escaped in strings", "go", `s := "<b>\"x"`, "s := " + span("str", `&#34;&lt;b&gt;\&#34;x&#34;`)},
+		{"escaped in comments", "go", `// a < b && "c"`, span("com", "// a &lt; b &amp;&amp; &#34;c&#34;")},
+		{"escaped in block comments", "js", "/* </span><script> */", span("com", "/* &lt;/span&gt;&lt;script&gt; */")},
+		{"quotes in shell words", "shell", `echo 'a\' b`, span("bi", "echo") + " " + span("str", `&#39;a\&#39;`) + " b"},
+		{"raw string", "go", "`raw \\` + 1", span("str", "`raw \\`") + " + " + span("num", "1")},
+
+		{"unterminated string", "go", "s := \"unterminated\nx := 1", "s := " + span("str", "&#34;unterminated") + "\nx := " + span("num", "1")},
+		{"unterminated escape", "go", `"a\`, span("str", `&#34;a\`)},
+		{"unterminated block comment", "go", "/* never closed <x>", span("com", "/* never closed &lt;x&gt;")},
+		{"unterminated docstring", "python", "'''never closed\nstill", span("str", "&#39;&#39;&#39;never closed\nstill")},
+		{"unterminated variable", "shell", "echo ${unterminated", span("bi", "echo") + " ${unterminated"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := Highlight(test.code, test.language)
+			if got != test.want {
+				t.Errorf("Highlight(%q, %q)\n got %q\nwant %q", test.code, test.language, got, test.want)
+			}
+
+			// the spans are allowed by the sanitiser, which must keep the output as it is
+			if sanitized := SanitizeHTML(got); sanitized != got {
+				t.Errorf("the sanitiser changed %q into %q", got, sanitized)
+			}
+		})
+	}
+}
+
+func TestDetectLanguage(t *testing.T) {
+	tests := []struct {
+		code, want string
+	}{
+		{"package main\n\nfunc main() {\n\tfmt.Println(1)\n}", "go"},
+		{"import os\n\ndef main():\n    print(os.name)", "python"},
+		{"const x = 1;\nconsole.log(x);", "js"},
+		{"SELECT id FROM post WHERE id = 1", "sql"},
+		{"#!/bin/bash\necho $HOME | grep x", "shell"},
+		{"hello world", ""},
+		{"x := 1", ""}, // one hint is not enough
+	}
+
+	for _, test := range tests {
+		if got := DetectLanguage(test.code); got != test.want {
+			t.Errorf("DetectLanguage(%q) = %q, want %q", test.code, got, test.want)
+		}
+	}
+}
+
+func TestCanonicalLanguage(t *testing.T) {
+	tests := []struct {
+		name, code, want string
+	}{
+		{"golang", "", "go"},
+		{"py", "", "python"},
+		{"typescript", "", "js"},
+		{"sqlite", "", "sql"},
+		{"bash", "", "shell"},
+		{"brainfuck", "SELECT a FROM b WHERE c", ""}, // a name that is not ours is not guessed
+		{"", "SELECT a FROM b WHERE c", "sql"},
+	}
+
+	for _, test := range tests {
+		if got := canonicalLanguage(test.name, test.code); got != test.want {
+			t.Errorf("canonicalLanguage(%q, %q) = %q, want %q", test.name, test.code, got, test.want)
+		}
+	}
+}
@@ -1,29 +1,17 @@
 package functions
 
 import (
-	"fmt"
+	"log/slog"
 	"net/http"
 )
 
 // Home handles the main page, loading posts with optional filters and rendering the homepage.
 func (database Database) Home(w http.ResponseWriter, r *http.Request) {
-	if r.URL.Path != "/" {
-		RenderError(w, "page not found", 404)
-		return
-	}
-
-	if r.Method != http.MethodGet {
-		RenderError(w, "method not allowed", 405)
-		return
-	}
-
-	storedToken, data, user_id, err := InitializeData(w, r, database.Db)
-	if err != nil {
-		return
-	}
+	user_id, storedToken := sessionOf(r)
+	data := InitializeData(database.Db, CurrentUser(r))
 
 	if err := r.ParseForm(); err != nil {
-		fmt.Println("failed to parse form", err)
+		slog.ErrorContext(r.Context(), "failed to parse form", "err", err)
 		RenderError(w, errPleaseTryLater, 500)
 		return
 	}
@@ -48,7 +36,7 @@ func (database Database) Home(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		fmt.Println("failed to load posts in home", err)
+		slog.ErrorContext(r.Context(), "failed to load posts in home", "err", err)
 		RenderError(w, errPleaseTryLater, 500)
 		return
 	}
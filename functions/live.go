@@ -0,0 +1,269 @@
+package functions
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// the topics of the hub
+const (
+	HomeTopic = "home"
+)
+
+// PostTopic is the topic of the events about one post and its comments.
+func PostTopic(postID int) string {
+	return "post:" + strconv.Itoa(postID)
+}
+
+// ErrTooManyStreams is returned by Subscribe when the hub already serves MaxStreams streams.
+var ErrTooManyStreams = errors.New("too many live streams")
+
+// ErrHubClosed is returned by Subscribe once the server is shutting down.
+var ErrHubClosed = errors.New("live streams are closed")
+
+// LiveEvent is one server-sent event, Data is sent as JSON.
+type LiveEvent struct {
+	Name string
+	Data any
+}
+
+// Subscriber receives the events of one topic until it unsubscribes or is evicted,
+// in which case Events is closed.
+type Subscriber struct {
+	Events chan LiveEvent
+	topic  string
+	closed bool // set before Events is closed when the hub closes, rather than evicting it
+}
+
+// Hub is an in-process publish/subscribe hub for the live streams.
+// A subscriber that doesn't read its events fast enough is evicted instead of slowing down the publishers.
+type Hub struct {
+	MaxStreams int           // streams served at the same time, over all topics
+	Buffer     int           // events kept for each subscriber before it is evicted
+	Heartbeat  time.Duration // time between two keep-alive comments on idle streams
+
+	mu          sync.Mutex
+	subscribers map[string]map[*Subscriber]bool
+	count       int
+	closed      bool
+}
+
+// NewHub returns a hub with the default limits.
+func NewHub() *Hub {
+	return &Hub{
+		MaxStreams:  1000,
+		Buffer:      16,
+		Heartbeat:   20 * time.Second,
+		subscribers: map[string]map[*Subscriber]bool{},
+	}
+}
+
+// Subscribe starts receiving the events of topic.
+func (hub *Hub) Subscribe(topic string) (*Subscriber, error) {
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+
+	if hub.closed {
+		return nil, ErrHubClosed
+	}
+
+	if hub.count >= hub.MaxStreams {
+		return nil, ErrTooManyStreams
+	}
+
+	subscriber := &Subscriber{Events: make(chan LiveEvent, hub.Buffer), topic: topic}
+
+	if hub.subscribers[topic] == nil {
+		hub.subscribers[topic] = map[*Subscriber]bool{}
+	}
+	hub.subscribers[topic][subscriber] = true
+	hub.count++
+
+	return subscriber, nil
+}
+
+// Unsubscribe stops the events of subscriber, it does nothing if it was already evicted.
+func (hub *Hub) Unsubscribe(subscriber *Subscriber) {
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+
+	hub.remove(subscriber)
+}
+
+// remove must be called with the lock held.
+func (hub *Hub) remove(subscriber *Subscriber) {
+	subscribers := hub.subscribers[subscriber.topic]
+	if !subscribers[subscriber] {
+		return
+	}
+
+	delete(subscribers, subscriber)
+	if len(subscribers) == 0 {
+		delete(hub.subscribers, subscriber.topic)
+	}
+
+	close(subscriber.Events)
+	hub.count--
+}
+
+// Close ends every stream and refuses new ones, for the shutdown of the server.
+func (hub *Hub) Close() {
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+
+	hub.closed = true
+
+	for _, subscribers := range hub.subscribers {
+		for subscriber := range subscribers {
+			subscriber.closed = true
+			hub.remove(subscriber)
+		}
+	}
+}
+
+// Publish sends an event to every subscriber of topic without ever blocking.
+// It is safe to call on a nil hub, which drops the event.
+func (hub *Hub) Publish(topic, name string, data any) {
+	if hub == nil {
+		return
+	}
+
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+
+	for subscriber := range hub.subscribers[topic] {
+		select {
+		case subscriber.Events <- LiveEvent{Name: name, Data: data}:
+		default:
+			// its buffer is full: the client is too slow, it will reconnect and reload
+			hub.remove(subscriber)
+		}
+	}
+}
+
+// publishPost announces a new post on the home feed.
+func publishPost(db *sql.DB, hub *Hub, postID int, title string, authorID int) {
+	var author string
+	if err := db.QueryRow(Select_UserName, authorID).Scan(&author); err != nil {
+		slog.Error("failed to load post author for live streams", "err", err)
+		return
+	}
+
+	hub.Publish(HomeTopic, "post", LivePost{Id: postID, Title: title, Author: author})
+}
+
+// publishPostCounts sends the reaction and comment counts of a post to the home feed and to the post page.
+func publishPostCounts(db *sql.DB, hub *Hub, postID int) {
+	counts := LiveCounts{Target: "post", Id: postID}
+
+	err := db.QueryRow(Select_Number, postID, postID, postID).Scan(&counts.Likes, &counts.Dislikes, &counts.Comments)
+	if err != nil {
+		slog.Error("failed to count post reactions for live streams", "err", err)
+		return
+	}
+
+	hub.Publish(HomeTopic, "counts", counts)
+	hub.Publish(PostTopic(postID), "counts", counts)
+}
+
+// publishCommentCounts sends the reaction counts of a comment to the page of its post.
+func publishCommentCounts(db *sql.DB, hub *Hub, commentID int) {
+	counts := LiveCounts{Target: "comment", Id: commentID}
+	var postID int
+
+	err := db.QueryRow(Select_Comment_Counts, commentID).Scan(&postID, &counts.Likes, &counts.Dislikes)
+	if err != nil {
+		slog.Error("failed to count comment reactions for live streams", "err", err)
+		return
+	}
+
+	hub.Publish(PostTopic(postID), "counts", counts)
+}
+
+// Live streams the events of the home feed on /live/home and of a post on /live/posts/{id}.
+func (database Database) Live(w http.ResponseWriter, r *http.Request) {
+	topic := HomeTopic
+	if r.PathValue("id") != "" {
+		postID, err := pathID(r, "id")
+		if err != nil {
+			RenderError(w, errPageNotFound, 404)
+			return
+		}
+
+		var title string
+		if err := database.Db.QueryRow(Verify_PostID, postID).Scan(&title); err != nil {
+			RenderError(w, "this post doesn't exist", 404)
+			return
+		}
+
+		topic = PostTopic(postID)
+	}
+
+	subscriber, err := database.Hub.Subscribe(topic)
+	if err != nil {
+		w.Header().Set("Retry-After", "30")
+		RenderError(w, errPleaseTryLater, http.StatusServiceUnavailable)
+		return
+	}
+
+	defer database.Hub.Unsubscribe(subscriber)
+
+	flusher := http.NewResponseController(w)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, "retry: 5000\n\n")
+	if err := flusher.Flush(); err != nil {
+		slog.ErrorContext(r.Context(), "live streams need a flushable response", "err", err)
+		return
+	}
+
+	heartbeat := time.NewTicker(database.Hub.Heartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		var message string
+
+		select {
+		case <-r.Context().Done():
+			return
+
+		case <-heartbeat.C:
+			message = ": ping\n\n"
+
+		case event, ok := <-subscriber.Events:
+			if !ok {
+				// evicted by the hub
+				return
+			}
+
+			data, err := json.Marshal(event.Data)
+			if err != nil {
+				slog.ErrorContext(r.Context(), "failed to encode live event", "err", err)
+				continue
+			}
+
+			message = fmt.Sprintf("event: %s\ndata: %s\n\n", event.Name, data)
+		}
+
+		// a client that stopped reading must not hold the stream forever
+		flusher.SetWriteDeadline(time.Now().Add(10 * time.Second))
+
+		if _, err := fmt.Fprint(w, message); err != nil {
+			return
+		}
+
+		if err := flusher.Flush(); err != nil {
+			return
+		}
+	}
+}
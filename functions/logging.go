@@ -0,0 +1,160 @@
+package functions
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"time"
+)
+
+// logLevels are the values of log.level.
+var logLevels = map[string]slog.Level{
+	"debug": slog.LevelDebug,
+	"info":  slog.LevelInfo,
+	"warn":  slog.LevelWarn,
+	"error": slog.LevelError,
+}
+
+// NewLogger returns the logger described by config, writing to output. The records logged
+// with the context of a request carry its request_id.
+func NewLogger(config LogConfig, output io.Writer) *slog.Logger {
+	options := &slog.HandlerOptions{Level: logLevels[config.Level]}
+
+	var handler slog.Handler = slog.NewTextHandler(output, options)
+	if config.Format == "json" {
+		handler = slog.NewJSONHandler(output, options)
+	}
+
+	return slog.New(requestHandler{handler})
+}
+
+// requestHandler adds the request id of the context to the records.
+type requestHandler struct {
+	slog.Handler
+}
+
+func (handler requestHandler) Handle(ctx context.Context, record slog.Record) error {
+	if info := requestInfoOf(ctx); info != nil {
+		record.AddAttrs(slog.String("request_id", info.id))
+	}
+
+	return handler.Handler.Handle(ctx, record)
+}
+
+func (handler requestHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return requestHandler{handler.Handler.WithAttrs(attrs)}
+}
+
+func (handler requestHandler) WithGroup(name string) slog.Handler {
+	return requestHandler{handler.Handler.WithGroup(name)}
+}
+
+// requestInfo is what the access log knows about a request, Authenticate fills userID.
+type requestInfo struct {
+	id     string
+	userID int
+}
+
+type requestInfoKey struct{}
+
+func requestInfoOf(ctx context.Context) *requestInfo {
+	info, _ := ctx.Value(requestInfoKey{}).(*requestInfo)
+	return info
+}
+
+// logWriter records the status of a response for the access log.
+type logWriter struct {
+	http.ResponseWriter
+	id     string
+	status int
+}
+
+func (w *logWriter) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+	}
+
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *logWriter) Write(body []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+
+	return w.ResponseWriter.Write(body)
+}
+
+// Unwrap lets http.ResponseController reach the connection, for the live streams.
+func (w *logWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// Hijack hands the connection to the chat, which answers 101 itself.
+func (w *logWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, buffered, err := http.NewResponseController(w.ResponseWriter).Hijack()
+	if err == nil {
+		w.status = http.StatusSwitchingProtocols
+	}
+
+	return conn, buffered, err
+}
+
+// RequestLog gives every request an id, sent back in X-Request-Id, and logs the request once
+// it is answered: its method, path, status, latency and user.
+func RequestLog(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		bytes := make([]byte, 8)
+		rand.Read(bytes)
+		info := &requestInfo{id: hex.EncodeToString(bytes)}
+
+		w.Header().Set("X-Request-Id", info.id)
+		writer := &logWriter{ResponseWriter: w, id: info.id}
+		ctx := context.WithValue(r.Context(), requestInfoKey{}, info)
+
+		// deferred, to log the requests cut by a panic too
+		defer func() {
+			status := writer.status
+			if status == 0 {
+				status = http.StatusOK
+			}
+
+			slog.InfoContext(ctx, "request", "method", r.Method, "path", r.URL.Path, "status", status,
+				"latency", time.Since(start), "user_id", info.userID)
+		}()
+
+		next.ServeHTTP(writer, r.WithContext(ctx))
+	})
+}
+
+// RequestID returns the id of the request that w answers, it is empty outside of RequestLog.
+func RequestID(w http.ResponseWriter) string {
+	if writer, ok := unwrapTo[*logWriter](w); ok {
+		return writer.id
+	}
+
+	return ""
+}
+
+// unwrapTo returns the writer of type T that w is or wraps.
+func unwrapTo[T http.ResponseWriter](w http.ResponseWriter) (T, bool) {
+	for {
+		if writer, ok := w.(T); ok {
+			return writer, true
+		}
+
+		unwrapper, ok := w.(interface{ Unwrap() http.ResponseWriter })
+		if !ok {
+			var none T
+			return none, false
+		}
+		w = unwrapper.Unwrap()
+	}
+}
@@ -2,40 +2,25 @@ package functions
 
 import (
 	"database/sql"
-	"fmt"
+	"log/slog"
 	"net/http"
 	"strings"
 
 	"golang.org/x/crypto/bcrypt"
 )
 
-// Login handles login requests and renders the login page or processes credentials.
+// Login renders the login page on GET and processes the credentials on POST.
 func (database Database) Login(w http.ResponseWriter, r *http.Request) {
-	if r.URL.Path != "/login" {
-		RenderError(w, "Page not found", 404)
-		return
-	}
-
-	switch r.Method {
-	case http.MethodGet:
-		if len(r.URL.RawQuery) > 0 {
-			RenderError(w, "Method not allowed", 405)
-			return
-		}
-
+	if r.Method == http.MethodGet {
 		ExecuteTemplate(w, "login.html", nil, 200)
-
-	case http.MethodPost:
-		HandleLogin(w, r, database.Db)
-
-	default:
-		RenderError(w, "Method not allowed", 405)
 		return
 	}
+
+	HandleLogin(w, r, database.Db, database.Session)
 }
 
 // HandleLogin validates user credentials, manages sessions, and logs the user in.
-func HandleLogin(w http.ResponseWriter, r *http.Request, DB *sql.DB) {
+func HandleLogin(w http.ResponseWriter, r *http.Request, DB *sql.DB, session SessionConfig) {
 	username := strings.TrimSpace(r.FormValue("username"))
 	password := strings.TrimSpace(r.FormValue("password"))
 	var data LoginData
@@ -58,7 +43,7 @@ func HandleLogin(w http.ResponseWriter, r *http.Request, DB *sql.DB) {
 		return
 
 	} else if err != nil {
-		fmt.Println("DB query error:", err)
+		slog.ErrorContext(r.Context(), "failed to load user", "err", err)
 		RenderError(w, "something wrong happened, please try again later", 500)
 		return
 	}
@@ -76,7 +61,7 @@ func HandleLogin(w http.ResponseWriter, r *http.Request, DB *sql.DB) {
 	
 	_, err = DB.Exec(Delete_User_Session, userID)
 	if err != nil {
-		fmt.Println(err)
+		slog.ErrorContext(r.Context(), "failed to delete previous session", "err", err)
 		RenderError(w, "please try later", 500)
 		return
 	}
@@ -86,15 +71,15 @@ func HandleLogin(w http.ResponseWriter, r *http.Request, DB *sql.DB) {
 
 	
 	if err == sql.ErrNoRows {
-		err := SetNewSession(w, DB, userID)
+		err := SetNewSession(w, r, DB, session, userID)
 		if err != nil {
-			fmt.Println(err)
+			slog.ErrorContext(r.Context(), "failed to create session", "err", err)
 			RenderError(w, "please try later", 500)
 			return
 		}
 
 	} else {
-		fmt.Println(err)
+		slog.ErrorContext(r.Context(), "failed to load session", "err", err)
 		RenderError(w, "please try later", 500)
 		return
 	}
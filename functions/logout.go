@@ -1,22 +1,12 @@
 package functions
 
 import (
-	"fmt"
+	"log/slog"
 	"net/http"
 )
 
 // Logout deletes the user's session and clears the session cookie.
 func (database Database) Logout(w http.ResponseWriter, r *http.Request) {
-	if r.URL.Path != "/logout" {
-		RenderError(w, "Page not found", 404)
-		return
-	}
-
-	if r.Method != http.MethodPost {
-		RenderError(w, "Method not allowed", 405)
-		return
-	}
-
 	
 	cookie, err := r.Cookie("session")
 	if err != nil {
@@ -28,12 +18,12 @@ func (database Database) Logout(w http.ResponseWriter, r *http.Request) {
 	
 	_, err = database.Db.Exec(Delete_Session_by_ID, cookie.Value)
 	if err != nil {
-		fmt.Println(err)
+		slog.ErrorContext(r.Context(), "failed to delete session", "err", err)
 		RenderError(w, "please try later", 500)
 		return
 	}
 
-	RemoveCookie(w)
+	RemoveCookie(w, r, database.Session)
 
 
 	http.Redirect(w, r, "/", http.StatusSeeOther)
@@ -0,0 +1,173 @@
+package functions
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Mail is an email with a plain text and an HTML version of the same content.
+type Mail struct {
+	To      string
+	Subject string
+	Text    string
+	HTML    string
+	Headers map[string]string // extra headers, like List-Unsubscribe
+}
+
+// Mailer sends emails.
+type Mailer interface {
+	Send(email Mail) error
+}
+
+// SMTPMailer sends emails through an SMTP server, with PLAIN authentication when Username is set.
+type SMTPMailer struct {
+	Addr     string // host:port
+	From     string
+	Username string
+	Password string
+}
+
+// Send delivers the mail to the SMTP server.
+func (mailer SMTPMailer) Send(email Mail) error {
+	message, err := buildMail(mailer.From, email)
+	if err != nil {
+		return err
+	}
+
+	var auth smtp.Auth
+	if mailer.Username != "" {
+		host, _, err := net.SplitHostPort(mailer.Addr)
+		if err != nil {
+			return err
+		}
+		auth = smtp.PlainAuth("", mailer.Username, mailer.Password, host)
+	}
+
+	from, err := mail.ParseAddress(mailer.From)
+	if err != nil {
+		return err
+	}
+
+	return smtp.SendMail(mailer.Addr, auth, from.Address, []string{email.To}, message)
+}
+
+// FileMailer writes every email as a .eml file in Dir instead of sending it, for development and tests.
+type FileMailer struct {
+	Dir  string
+	From string
+}
+
+// Send writes the mail to a new file of Dir.
+func (mailer FileMailer) Send(email Mail) error {
+	message, err := buildMail(mailer.From, email)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(mailer.Dir, 0o755); err != nil {
+		return err
+	}
+
+	to := strings.Map(func(r rune) rune {
+		if r == '@' || r == '.' || r == '-' || r == '_' || ('a' <= r && r <= 'z') || ('A' <= r && r <= 'Z') || ('0' <= r && r <= '9') {
+			return r
+		}
+		return '_'
+	}, email.To)
+
+	name := time.Now().UTC().Format("20060102-150405.000000000") + "-" + to + ".eml"
+	return os.WriteFile(filepath.Join(mailer.Dir, name), message, 0o644)
+}
+
+// buildMail encodes the mail as a multipart/alternative message, the text part first as RFC 2046 asks.
+func buildMail(from string, email Mail) ([]byte, error) {
+	sender, err := mail.ParseAddress(from)
+	if err != nil {
+		return nil, fmt.Errorf("invalid sender %q: %w", from, err)
+	}
+
+	if _, err := mail.ParseAddress(email.To); err != nil {
+		return nil, fmt.Errorf("invalid recipient %q: %w", email.To, err)
+	}
+
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+
+	_, domain, _ := strings.Cut(sender.Address, "@")
+
+	var body bytes.Buffer
+	parts := multipart.NewWriter(&body)
+
+	headers := map[string]string{
+		"From":         sender.String(),
+		"To":           email.To,
+		"Subject":      mime.QEncoding.Encode("utf-8", email.Subject),
+		"Date":         time.Now().Format(time.RFC1123Z),
+		"Message-ID":   "<" + hex.EncodeToString(id) + "@" + domain + ">",
+		"MIME-Version": "1.0",
+		"Content-Type": "multipart/alternative; boundary=" + parts.Boundary(),
+	}
+
+	for name, value := range email.Headers {
+		headers[name] = value
+	}
+
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var message bytes.Buffer
+	for _, name := range names {
+		// a line break in a value would let it add headers of its own
+		value := strings.NewReplacer("\r", "", "\n", "").Replace(headers[name])
+		fmt.Fprintf(&message, "%s: %s\r\n", name, value)
+	}
+	message.WriteString("\r\n")
+
+	for _, part := range []struct{ contentType, content string }{
+		{"text/plain; charset=utf-8", email.Text},
+		{"text/html; charset=utf-8", email.HTML},
+	} {
+		writer, err := parts.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		encoder := quotedprintable.NewWriter(writer)
+		if _, err := encoder.Write([]byte(part.content)); err != nil {
+			return nil, err
+		}
+
+		if err := encoder.Close(); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := parts.Close(); err != nil {
+		return nil, err
+	}
+
+	message.Write(body.Bytes())
+	return message.Bytes(), nil
+}
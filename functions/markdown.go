@@ -0,0 +1,447 @@
+package functions
+
+import (
+	"html"
+	"html/template"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// markdownRevision must be increased every time the output of RenderMarkdown changes,
+// so the HTML cached in the post and comment tables is rendered again.
+const markdownRevision = 3
+
+var (
+	orderedItem   = regexp.MustCompile(`^(\d{1,9})[.)]\s+`)
+	unorderedItem = regexp.MustCompile(`^[-*+]\s+`)
+	headingLine   = regexp.MustCompile(`^(#{1,6})\s+(.*?)\s*#*\s*$`)
+	ruleLine      = regexp.MustCompile(`^(?:(?:-\s*){3,}|(?:\*\s*){3,}|(?:_\s*){3,})$`)
+	bareURL       = regexp.MustCompile(`^https?://[^\s<>"]+`)
+)
+
+// RenderMarkdown turns user content into sanitised HTML. It supports paragraphs, headings,
+// fenced code blocks, block quotes, lists, rules, links, emphasis and inline code.
+func RenderMarkdown(source string) template.HTML {
+	source = strings.ReplaceAll(source, "\r\n", "\n")
+	source = strings.ReplaceAll(source, "\t", "    ")
+
+	var out strings.Builder
+	renderBlocks(&out, strings.Split(source, "\n"))
+
+	return template.HTML(SanitizeHTML(out.String()))
+}
+
+// renderBlocks renders the block level elements found in lines.
+func renderBlocks(out *strings.Builder, lines []string) {
+	for i := 0; i < len(lines); {
+		line := lines[i]
+		trimmed := strings.TrimSpace(line)
+
+		switch {
+		case trimmed == "":
+			i++
+
+		case strings.HasPrefix(trimmed, "```") || strings.HasPrefix(trimmed, "~~~"):
+			i = renderFence(out, lines, i)
+
+		case headingLine.MatchString(trimmed):
+			match := headingLine.FindStringSubmatch(trimmed)
+			// h1 and h2 are kept for the page itself, so the levels start at h3
+			level := strconv.Itoa(min(len(match[1])+2, 6))
+			out.WriteString("<h" + level + ">")
+			renderInline(out, match[2])
+			out.WriteString("</h" + level + ">\n")
+			i++
+
+		case ruleLine.MatchString(trimmed):
+			out.WriteString("<hr>\n")
+			i++
+
+		case strings.HasPrefix(trimmed, ">"):
+			i = renderQuote(out, lines, i)
+
+		case unorderedItem.MatchString(trimmed) || orderedItem.MatchString(trimmed):
+			i = renderList(out, lines, i)
+
+		default:
+			i = renderParagraph(out, lines, i)
+		}
+	}
+}
+
+// renderFence renders a fenced code block starting at lines[start] and returns the next line to read.
+func renderFence(out *strings.Builder, lines []string, start int) int {
+	opening := strings.TrimSpace(lines[start])
+	fence := opening[:3]
+	language := strings.ToLower(strings.TrimSpace(strings.TrimLeft(opening, fence[:1])))
+	if fields := strings.Fields(language); len(fields) > 0 {
+		language = fields[0]
+	}
+
+	code := []string{}
+	i := start + 1
+	for ; i < len(lines); i++ {
+		if strings.HasPrefix(strings.TrimSpace(lines[i]), fence) {
+			i++
+			break
+		}
+		code = append(code, lines[i])
+	}
+
+	source := strings.Join(code, "\n")
+	highlighted := canonicalLanguage(language, source)
+	if language == "" {
+		language = highlighted
+	}
+
+	out.WriteString("<pre><code")
+	if isLanguageName(language) {
+		out.WriteString(` class="language-` + language + `"`)
+	}
+	out.WriteString(">")
+	out.WriteString(Highlight(source, highlighted))
+	out.WriteString("</code></pre>\n")
+
+	return i
+}
+
+// renderQuote renders consecutive "> " lines as a block quote, its content can hold any block.
+func renderQuote(out *strings.Builder, lines []string, start int) int {
+	quoted := []string{}
+	i := start
+	for ; i < len(lines); i++ {
+		trimmed := strings.TrimSpace(lines[i])
+		if !strings.HasPrefix(trimmed, ">") {
+			break
+		}
+
+		trimmed = strings.TrimPrefix(trimmed, ">")
+		quoted = append(quoted, strings.TrimPrefix(trimmed, " "))
+	}
+
+	out.WriteString("<blockquote>\n")
+	renderBlocks(out, quoted)
+	out.WriteString("</blockquote>\n")
+
+	return i
+}
+
+// renderList renders a list whose items all use the marker type of the first one.
+// Lines indented under an item belong to it, which allows nested lists.
+func renderList(out *strings.Builder, lines []string, start int) int {
+	first := strings.TrimSpace(lines[start])
+	ordered := orderedItem.MatchString(first)
+	marker := unorderedItem
+	tag := "ul"
+
+	if ordered {
+		marker = orderedItem
+		tag = "ol"
+		number := orderedItem.FindStringSubmatch(first)[1]
+		if number != "1" {
+			n, _ := strconv.Atoi(number)
+			tag = `ol start="` + strconv.Itoa(n) + `"`
+		}
+	}
+
+	out.WriteString("<" + tag + ">\n")
+
+	baseIndent := indentation(lines[start])
+	i := start
+
+	for i < len(lines) {
+		trimmed := strings.TrimSpace(lines[i])
+		if trimmed == "" || indentation(lines[i]) != baseIndent || !marker.MatchString(trimmed) {
+			break
+		}
+
+		item := []string{marker.ReplaceAllString(trimmed, "")}
+		i++
+
+		for i < len(lines) {
+			if strings.TrimSpace(lines[i]) == "" {
+				// a blank line only continues the item if indented content follows
+				if i+1 < len(lines) && indentation(lines[i+1]) > baseIndent && strings.TrimSpace(lines[i+1]) != "" {
+					item = append(item, "")
+					i++
+					continue
+				}
+				break
+			}
+
+			if indentation(lines[i]) <= baseIndent {
+				break
+			}
+
+			item = append(item, lines[i])
+			i++
+		}
+
+		out.WriteString("<li>")
+		if len(item) == 1 {
+			renderInline(out, item[0])
+		} else {
+			renderBlocks(out, dedent(item))
+		}
+		out.WriteString("</li>\n")
+	}
+
+	if ordered {
+		tag = "ol"
+	}
+	out.WriteString("</" + tag + ">\n")
+
+	return i
+}
+
+// dedent removes the indentation shared by the continuation lines of a list item,
+// so nested lists keep their relative indentation.
+func dedent(item []string) []string {
+	shared := -1
+	for _, line := range item[1:] {
+		if strings.TrimSpace(line) != "" && (shared < 0 || indentation(line) < shared) {
+			shared = indentation(line)
+		}
+	}
+
+	lines := []string{item[0]}
+	for _, line := range item[1:] {
+		if len(line) >= shared {
+			line = line[shared:]
+		}
+		lines = append(lines, line)
+	}
+
+	return lines
+}
+
+// renderParagraph renders lines until a blank line or another block, keeping line breaks.
+func renderParagraph(out *strings.Builder, lines []string, start int) int {
+	paragraph := []string{}
+	i := start
+
+	for ; i < len(lines); i++ {
+		trimmed := strings.TrimSpace(lines[i])
+		if trimmed == "" || (i > start && startsBlock(trimmed)) {
+			break
+		}
+
+		paragraph = append(paragraph, trimmed)
+	}
+
+	out.WriteString("<p>")
+	for n, line := range paragraph {
+		if n > 0 {
+			out.WriteString("<br>\n")
+		}
+		renderInline(out, line)
+	}
+	out.WriteString("</p>\n")
+
+	return i
+}
+
+func startsBlock(trimmed string) bool {
+	return strings.HasPrefix(trimmed, "```") || strings.HasPrefix(trimmed, "~~~") ||
+		strings.HasPrefix(trimmed, ">") || headingLine.MatchString(trimmed) ||
+		unorderedItem.MatchString(trimmed) || orderedItem.MatchString(trimmed)
+}
+
+func indentation(line string) int {
+	return len(line) - len(strings.TrimLeft(line, " "))
+}
+
+func isLanguageName(name string) bool {
+	if name == "" || len(name) > 20 {
+		return false
+	}
+
+	for _, ch := range name {
+		if !(ch >= 'a' && ch <= 'z' || ch >= '0' && ch <= '9' || ch == '+' || ch == '#' || ch == '-') {
+			return false
+		}
+	}
+
+	return true
+}
+
+// renderInline renders code spans, links, emphasis and escapes everything else.
+func renderInline(out *strings.Builder, text string) {
+	for i := 0; i < len(text); {
+		rest := text[i:]
+
+		switch {
+		case rest[0] == '\\' && len(rest) > 1 && strings.ContainsRune("\\`*_~[]()#>-+.!@", rune(rest[1])):
+			out.WriteString(html.EscapeString(rest[1:2]))
+			i += 2
+
+		case rest[0] == '`':
+			ticks := len(rest) - len(strings.TrimLeft(rest, "`"))
+			end := strings.Index(rest[ticks:], rest[:ticks])
+			if end < 0 {
+				out.WriteString(html.EscapeString(rest[:ticks]))
+				i += ticks
+				continue
+			}
+
+			out.WriteString("<code>" + html.EscapeString(strings.TrimSpace(rest[ticks:ticks+end])) + "</code>")
+			i += 2*ticks + end
+
+		case rest[0] == '[':
+			label, url, length := parseLink(rest)
+			if length == 0 {
+				out.WriteString("[")
+				i++
+				continue
+			}
+
+			out.WriteString(`<a href="` + html.EscapeString(url) + `">`)
+			renderInline(out, label)
+			out.WriteString("</a>")
+			i += length
+
+		case bareURL.MatchString(rest) && (i == 0 || !isWordChar(text[i-1])):
+			url := strings.TrimRight(bareURL.FindString(rest), ".,;:!?)")
+			out.WriteString(`<a href="` + html.EscapeString(url) + `">` + html.EscapeString(url) + "</a>")
+			i += len(url)
+
+		case strings.HasPrefix(rest, "**") || strings.HasPrefix(rest, "__") || strings.HasPrefix(rest, "~~"):
+			length := renderDelimited(out, text, i, rest[:2])
+			if length == 0 {
+				out.WriteString(html.EscapeString(rest[:2]))
+				length = 2
+			}
+			i += length
+
+		case rest[0] == '*' || rest[0] == '_':
+			length := renderDelimited(out, text, i, rest[:1])
+			if length == 0 {
+				out.WriteString(rest[:1])
+				length = 1
+			}
+			i += length
+
+		default:
+			next := strings.IndexAny(rest[1:], "\\`[*_~h")
+			if next < 0 {
+				next = len(rest) - 1
+			}
+			out.WriteString(html.EscapeString(rest[:next+1]))
+			i += next + 1
+		}
+	}
+}
+
+// renderDelimited renders the emphasis opened by delimiter at text[start] and returns
+// how many bytes it used, or 0 when it is not closed.
+func renderDelimited(out *strings.Builder, text string, start int, delimiter string) int {
+	inner := text[start+len(delimiter):]
+
+	// "_" inside words (snake_case) and "* " with a space after are not emphasis
+	if inner == "" || inner[0] == ' ' || (delimiter[0] == '_' && start > 0 && isWordChar(text[start-1])) {
+		return 0
+	}
+
+	end := -1
+	for from := 0; from < len(inner); {
+		n := strings.Index(inner[from:], delimiter)
+		if n < 0 {
+			break
+		}
+
+		// a run of delimiters closes "**" before "*", so "*a **b***" nests instead of closing early
+		n += from
+		run := len(inner[n:]) - len(strings.TrimLeft(inner[n:], delimiter[:1]))
+		after := n + run
+		closesWord := n > 0 && inner[n-1] != ' '
+		if closesWord && !(delimiter[0] == '_' && after < len(inner) && isWordChar(inner[after])) {
+			if len(delimiter) == 2 && run >= 2 {
+				end = after - 2
+				break
+			}
+			if len(delimiter) == 1 && run%2 == 1 {
+				end = after - 1
+				break
+			}
+		}
+		from = after
+	}
+
+	if end <= 0 {
+		return 0
+	}
+
+	tag := "em"
+	switch delimiter {
+	case "**", "__":
+		tag = "strong"
+	case "~~":
+		tag = "del"
+	}
+
+	out.WriteString("<" + tag + ">")
+	renderInline(out, inner[:end])
+	out.WriteString("</" + tag + ">")
+
+	return 2*len(delimiter) + end
+}
+
+// parseLink reads [label](url) at the start of text and returns the label, the url and
+// the length of the whole link, or a length of 0 when it is not a valid link.
+func parseLink(text string) (string, string, int) {
+	depth := 0
+	closing := -1
+	for i := 0; i < len(text); i++ {
+		if text[i] == '[' {
+			depth++
+		} else if text[i] == ']' {
+			depth--
+			if depth == 0 {
+				closing = i
+				break
+			}
+		}
+	}
+
+	if closing < 1 || closing+1 >= len(text) || text[closing+1] != '(' {
+		return "", "", 0
+	}
+
+	end := strings.IndexByte(text[closing+2:], ')')
+	if end < 0 {
+		return "", "", 0
+	}
+
+	url := strings.TrimSpace(text[closing+2 : closing+2+end])
+	if !isSafeURL(url) {
+		return "", "", 0
+	}
+
+	return text[1:closing], url, closing + 3 + end
+}
+
+// isSafeURL allows web and mail links, and links inside the forum. Browsers read "/\" as "//"
+// and drop tabs and newlines, so backslashes and control characters are refused everywhere.
+func isSafeURL(url string) bool {
+	if url == "" || strings.ContainsAny(url, " \"<>\\") {
+		return false
+	}
+
+	for i := 0; i < len(url); i++ {
+		if url[i] < 0x20 || url[i] == 0x7f {
+			return false
+		}
+	}
+
+	lower := strings.ToLower(url)
+	if strings.HasPrefix(lower, "http://") || strings.HasPrefix(lower, "https://") || strings.HasPrefix(lower, "mailto:") {
+		return true
+	}
+
+	return url[0] == '/' && (len(url) == 1 || url[1] != '/' && url[1] != '\\')
+}
+
+func isWordChar(ch byte) bool {
+	return ch >= 'a' && ch <= 'z' || ch >= 'A' && ch <= 'Z' || ch >= '0' && ch <= '9' || ch == '_'
+}
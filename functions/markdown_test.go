@@ -0,0 +1,115 @@
+package functions
+
+import (
+	"regexp"
+	"testing"
+)
+
+func TestRenderMarkdown(t *testing.T) {
+	tests := []struct {
+		name, source, want string
+	}{
+		{"paragraph", "a < b & c", "<p>a &lt; b &amp; c</p>\n"},
+		{"line breaks", "one\ntwo", "<p>one<br>\ntwo</p>\n"},
+		{"heading starts at h3", "# Title", "<h3>Title</h3>\n"},
+		{"emphasis", "*a* **b** ~~c~~ `d`", "<p><em>a</em> <strong>b</strong> <del>c</del> <code>d</code></p>\n"},
+		{"strong inside emphasis", "*a **b***", "<p><em>a <strong>b</strong></em></p>\n"},
+		{"emphasis inside strong", "**a *b***", "<p><strong>a <em>b</em></strong></p>\n"},
+		{"strong emphasis", "***a***", "<p><strong><em>a</em></strong></p>\n"},
+		{"strong in the middle of emphasis", "*a **b** c*", "<p><em>a <strong>b</strong> c</em></p>\n"},
+		{"snake_case", "snake_case_name", "<p>snake_case_name</p>\n"},
+		{"lone stars", "a * b * c", "<p>a * b * c</p>\n"},
+		{"unclosed strong", "**a*", "<p>**a*</p>\n"},
+		{"escaped star", `\*a\*`, "<p>*a*</p>\n"},
+
+		{"link", "[x](/posts/1)", `<p><a href="/posts/1" rel="nofollow noopener noreferrer">x</a></p>` + "\n"},
+		{"link with emphasis", "[**b**](mailto:a@b.test)", `<p><a href="mailto:a@b.test" rel="nofollow noopener noreferrer"><strong>b</strong></a></p>` + "\n"},
+		{"bare link", "see https://a.test/x.", `<p>see <a href="https://a.test/x" rel="nofollow noopener noreferrer">https://a.test/x</a>.</p>` + "\n"},
+		{"javascript link", "[x](javascript:alert(1))", "<p>[x](javascript:alert(1))</p>\n"},
+		{"javascript link in mixed case", "[x](JaVaScRiPt:alert(1))", "<p>[x](JaVaScRiPt:alert(1))</p>\n"},
+		{"entity in the scheme", "[x](jav&#x61;script:alert(1))", "<p>[x](jav&amp;#x61;script:alert(1))</p>\n"},
+		{"protocol relative link", "[x](//evil.com)", "<p>[x](//evil.com)</p>\n"},
+		{"backslash link", `[x](/\evil.com)`, "<p>[x](/\\evil.com)</p>\n"},
+		{"quote in a link", `[x](/a"onmouseover="alert(1))`, "<p>[x](/a&#34;onmouseover=&#34;alert(1))</p>\n"},
+		{"quote after a bare link", `https://a.test/"onmouseover=x`, `<p><a href="https://a.test/" rel="nofollow noopener noreferrer">https://a.test/</a>&#34;onmouseover=x</p>` + "\n"},
+
+		{"script", "<script>alert(1)</script>", "<p>&lt;script&gt;alert(1)&lt;/script&gt;</p>\n"},
+		{"img onerror", `<img src=x onerror="alert(1)">`, "<p>&lt;img src=x onerror=&#34;alert(1)&#34;&gt;</p>\n"},
+
+		{"fenced code", "```go\nfmt.Println(\"<b>\")\n```", `<pre><code class="language-go">fmt.Println(<span class="hl-str">&#34;&lt;b&gt;&#34;</span>)</code></pre>` + "\n"},
+		{"unclosed fence", "~~~\n<i>x</i>", "<pre><code>&lt;i&gt;x&lt;/i&gt;</code></pre>\n"},
+		{"fence with a bad language", "```\"><script>\nx\n```", "<pre><code>x</code></pre>\n"},
+		{"unordered list", "- a\n- b", "<ul>\n<li>a</li>\n<li>b</li>\n</ul>\n"},
+		{"ordered list", "3. a\n4. b", "<ol start=\"3\">\n<li>a</li>\n<li>b</li>\n</ol>\n"},
+		{"nested list", "- a\n  - b", "<ul>\n<li><p>a</p>\n<ul>\n<li>b</li>\n</ul>\n</li>\n</ul>\n"},
+		{"quote", "> a\n> b", "<blockquote>\n<p>a<br>\nb</p>\n</blockquote>\n"},
+		{"quote with a list", "> - a", "<blockquote>\n<ul>\n<li>a</li>\n</ul>\n</blockquote>\n"},
+		{"rule", "---", "<hr>\n"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := string(RenderMarkdown(test.source)); got != test.want {
+				t.Errorf("RenderMarkdown(%q)\n got %q\nwant %q", test.source, got, test.want)
+			}
+		})
+	}
+}
+
+func TestRenderMarkdownHasNoActiveContent(t *testing.T) {
+	sources := []string{
+		"[x](javascript:alert(1))",
+		"[x](JAVASCRIPT:alert(1))",
+		"[x](data:text/html;base64,PHNjcmlwdD4=)",
+		"[x](vbscript:msgbox)",
+		"<script>alert(1)</script>",
+		"<img src=x onerror=alert(1)>",
+		"<a href=\"javascript:alert(1)\">x</a>",
+		"[a\"onclick=\"x](/ok)",
+		"**<svg onload=alert(1)>**",
+		"```\n</code><script>alert(1)</script>\n```",
+	}
+
+	// the text may mention anything, only the tags and attributes matter
+	active := regexp.MustCompile(`(?i)<(script|img|svg)|<[^>]*\son[a-z]+=|href="(javascript|data|vbscript):`)
+
+	for _, source := range sources {
+		if got := string(RenderMarkdown(source)); active.MatchString(got) {
+			t.Errorf("RenderMarkdown(%q) = %q has active content", source, got)
+		}
+	}
+}
+
+func TestIsSafeURL(t *testing.T) {
+	tests := []struct {
+		url  string
+		safe bool
+	}{
+		{"https://a.test/x?y=1", true},
+		{"HTTP://a.test", true},
+		{"mailto:a@b.test", true},
+		{"/posts/1", true},
+		{"/", true},
+		{"", false},
+		{"javascript:alert(1)", false},
+		{"JavaScript:alert(1)", false},
+		{"posts/1", false},
+		{"//evil.com", false},
+		{`/\evil.com`, false},
+		{`\\evil.com`, false},
+		{"/\t/evil.com", false},
+		{"/\n/evil.com", false},
+		{"/a\x00b", false},
+		{"/a\x7fb", false},
+		{"https://a.test/\tjavascript:x", false},
+		{`/a"b`, false},
+		{"/a<b", false},
+		{"/a b", false},
+	}
+
+	for _, test := range tests {
+		if got := isSafeURL(test.url); got != test.safe {
+			t.Errorf("isSafeURL(%q) = %v, want %v", test.url, got, test.safe)
+		}
+	}
+}
@@ -0,0 +1,171 @@
+package functions
+
+import (
+	"database/sql"
+	"html/template"
+	"regexp"
+	"strings"
+)
+
+// mentionToken matches @name, with the same rules as the user names accepted at registration.
+var mentionToken = regexp.MustCompile(`@([A-Za-z][A-Za-z0-9_]{2,19})`)
+
+// dbExecutor is implemented by both *sql.DB and *sql.Tx.
+type dbExecutor interface {
+	Exec(query string, args ...any) (sql.Result, error)
+	Query(query string, args ...any) (*sql.Rows, error)
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+// findMentions resolves the @name tokens of content against the user table and returns the ids by name.
+// Unknown names are left out, they stay plain text.
+func findMentions(db dbExecutor, content string) (map[string]int, error) {
+	users := map[string]int{}
+
+	for _, match := range mentionToken.FindAllStringSubmatchIndex(content, -1) {
+		if !isMentionAt(content, match[0], match[1]) {
+			continue
+		}
+
+		name := content[match[2]:match[3]]
+		if _, done := users[name]; done {
+			continue
+		}
+
+		var id int
+		err := db.QueryRow(Select_UserID_By_Name, name).Scan(&id)
+		if err == sql.ErrNoRows {
+			continue
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		users[name] = id
+	}
+
+	return users, nil
+}
+
+// getMentions loads the users mentioned in a post (commentID 0) or in a comment, to render it again.
+func getMentions(db *sql.DB, postID, commentID int) (map[string]int, error) {
+	rows, err := db.Query(Select_Mentions, postID, commentID)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	users := map[string]int{}
+	for rows.Next() {
+		var name string
+		var id int
+
+		if err := rows.Scan(&name, &id); err != nil {
+			return nil, err
+		}
+
+		users[name] = id
+	}
+
+	return users, rows.Err()
+}
+
+// RenderContent renders markdown like RenderMarkdown and links the @name of the given users to their profile.
+// It also returns the ids of the users really mentioned: a name inside code or a link doesn't count.
+func RenderContent(source string, users map[string]int) (template.HTML, []int) {
+	rendered := string(RenderMarkdown(source))
+	if len(users) == 0 {
+		return template.HTML(rendered), nil
+	}
+
+	var out strings.Builder
+	mentioned := []int{}
+	seen := map[int]bool{}
+	skip := 0 // depth of the code and a elements around the text
+
+	for len(rendered) > 0 {
+		if rendered[0] == '<' {
+			end := strings.IndexByte(rendered, '>') + 1
+			tag := rendered[:end]
+
+			name := strings.TrimPrefix(strings.Trim(tag, "<>"), "/")
+			if fields := strings.Fields(name); len(fields) > 0 {
+				name = fields[0]
+			}
+
+			if name == "a" || name == "code" {
+				if tag[1] == '/' {
+					skip--
+				} else {
+					skip++
+				}
+			}
+
+			out.WriteString(tag)
+			rendered = rendered[end:]
+			continue
+		}
+
+		end := strings.IndexByte(rendered, '<')
+		if end < 0 {
+			end = len(rendered)
+		}
+
+		text := rendered[:end]
+		rendered = rendered[end:]
+
+		if skip > 0 {
+			out.WriteString(text)
+			continue
+		}
+
+		last := 0
+		for _, match := range mentionToken.FindAllStringSubmatchIndex(text, -1) {
+			name := text[match[2]:match[3]]
+			id, ok := users[name]
+			if !ok || !isMentionAt(text, match[0], match[1]) {
+				continue
+			}
+
+			out.WriteString(text[last:match[0]])
+			out.WriteString(`<a class="mention" href="/users/` + name + `">@` + name + "</a>")
+			last = match[1]
+
+			if !seen[id] {
+				seen[id] = true
+				mentioned = append(mentioned, id)
+			}
+		}
+		out.WriteString(text[last:])
+	}
+
+	return template.HTML(out.String()), mentioned
+}
+
+// isMentionAt tells if text[start:end] is a whole @name, and not part of an email address or a longer word.
+func isMentionAt(text string, start, end int) bool {
+	if start > 0 && isWordChar(text[start-1]) {
+		return false
+	}
+
+	return end == len(text) || !isWordChar(text[end])
+}
+
+// saveMentions records the users mentioned in a post (commentID 0) or in a comment and notifies them.
+func saveMentions(db dbExecutor, authorID, postID, commentID int, mentioned []int) error {
+	comment := sql.NullInt64{Int64: int64(commentID), Valid: commentID > 0}
+
+	for _, userID := range mentioned {
+		if _, err := db.Exec(Insert_Mention, userID, postID, comment); err != nil {
+			return err
+		}
+
+		if err := notify(db, userID, authorID, NotifyMention, postID, commentID); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
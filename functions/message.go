@@ -0,0 +1,335 @@
+package functions
+
+import (
+	"database/sql"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+var errNoUser = errors.New("this user doesn't exist")
+
+// Inbox lists the conversations of the user on /messages.
+// Every query is made with the id of the logged in user, so only the two participants can read a conversation.
+func (database Database) Inbox(w http.ResponseWriter, r *http.Request) {
+	user := CurrentUser(r)
+
+	database.inbox(w, r, user.Id, user.Token)
+}
+
+// Messages shows the conversation with the user of /messages/{name} on GET, and sends them a message on POST.
+func (database Database) Messages(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+
+	storedToken, userID, otherID, ok := database.conversation(w, r, name)
+	if !ok {
+		return
+	}
+
+	if r.Method == http.MethodGet {
+		data, err := getThreadData(database.Db, database.Content, userID, otherID, name, storedToken)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "failed to load conversation", "err", err)
+			RenderError(w, errPleaseTryLater, 500)
+			return
+		}
+
+		ExecuteTemplate(w, "thread.html", data, 200)
+		return
+	}
+
+	database.sendMessage(w, r, userID, otherID, name, storedToken)
+}
+
+// Block blocks the user of /messages/{name}/block, or unblocks them.
+func (database Database) Block(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+
+	_, userID, otherID, ok := database.conversation(w, r, name)
+	if !ok {
+		return
+	}
+
+	database.block(w, r, userID, otherID, name)
+}
+
+// conversation finds the other participant called name for the logged in user.
+// When it returns false, it has already answered the request.
+func (database Database) conversation(w http.ResponseWriter, r *http.Request, name string) (string, int, int, bool) {
+	user := CurrentUser(r)
+
+	otherID, err := getMessageableUser(database.Db, name, user.Id)
+	if err == errNoUser {
+		RenderError(w, err.Error(), 404)
+		return "", 0, 0, false
+	}
+
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to load message recipient", "err", err)
+		RenderError(w, errPleaseTryLater, 500)
+		return "", 0, 0, false
+	}
+
+	return user.Token, user.Id, otherID, true
+}
+
+// inbox lists the conversations of the user, the most recent first.
+func (database Database) inbox(w http.ResponseWriter, r *http.Request, userID int, storedToken string) {
+	data := InboxPageData{Token: storedToken, Conversations: []Conversation{}}
+
+	// the "to" field of the inbox form opens a conversation with anyone
+	if to := strings.TrimSpace(r.URL.Query().Get("to")); to != "" {
+		http.Redirect(w, r, "/messages/"+url.PathEscape(to), http.StatusSeeOther)
+		return
+	}
+
+	if err := database.Db.QueryRow(Select_UserName, userID).Scan(&data.UserName); err != nil {
+		slog.ErrorContext(r.Context(), "failed to load inbox", "err", err)
+		RenderError(w, errPleaseTryLater, 500)
+		return
+	}
+
+	rows, err := database.Db.Query(Select_Inbox, userID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to load inbox", "err", err)
+		RenderError(w, errPleaseTryLater, 500)
+		return
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var conversation Conversation
+		var updatedAt time.Time
+
+		err := rows.Scan(&conversation.Id, &conversation.With, &updatedAt, &conversation.LastMessage, &conversation.Unread)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "failed to load inbox", "err", err)
+			RenderError(w, errPleaseTryLater, 500)
+			return
+		}
+
+		conversation.UpdatedAt = updatedAt.Format("2006 Jan 2 15:04")
+		if len(conversation.LastMessage) > 80 {
+			conversation.LastMessage = strings.ToValidUTF8(conversation.LastMessage[:80], "") + "…"
+		}
+
+		data.Conversations = append(data.Conversations, conversation)
+		data.UnreadMessages += conversation.Unread
+	}
+
+	if err := rows.Err(); err != nil {
+		slog.ErrorContext(r.Context(), "failed to load inbox", "err", err)
+		RenderError(w, errPleaseTryLater, 500)
+		return
+	}
+
+	data.Unread = unreadCount(database.Db, userID)
+
+	ExecuteTemplate(w, "inbox.html", data, 200)
+}
+
+// sendMessage validates and stores a message, unless one of the two users blocked the other.
+func (database Database) sendMessage(w http.ResponseWriter, r *http.Request, userID, otherID int, name, storedToken string) {
+	content := strings.TrimSpace(strings.ReplaceAll(r.FormValue("content"), "\r\n", "\n"))
+
+	data, err := getThreadData(database.Db, database.Content, userID, otherID, name, storedToken)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to load conversation", "err", err)
+		RenderError(w, errPleaseTryLater, 500)
+		return
+	}
+
+	switch {
+	case data.Blocked:
+		data.Error = "you blocked this user, unblock them to send a message"
+	case data.BlockedBy:
+		data.Error = "this user doesn't accept your messages"
+	default:
+		if err := isValidMessage(content, database.Content); err != nil {
+			data.Error = err.Error()
+		}
+	}
+
+	if data.Error != "" {
+		data.PrevContent = content
+		ExecuteTemplate(w, "thread.html", data, 400)
+		return
+	}
+
+	if err := insertMessage(database.Db, userID, otherID, content); err != nil {
+		slog.ErrorContext(r.Context(), "failed to send message", "err", err)
+		RenderError(w, errPleaseTryLater, 500)
+		return
+	}
+
+	http.Redirect(w, r, "/messages/"+name, http.StatusSeeOther)
+}
+
+// block blocks the other user, or unblocks them when the form says action=unblock.
+func (database Database) block(w http.ResponseWriter, r *http.Request, userID, otherID int, name string) {
+	query := Insert_Block
+	if r.FormValue("action") == "unblock" {
+		query = Delete_Block
+	}
+
+	if _, err := database.Db.Exec(query, userID, otherID); err != nil {
+		slog.ErrorContext(r.Context(), "failed to update block", "err", err)
+		RenderError(w, errPleaseTryLater, 500)
+		return
+	}
+
+	http.Redirect(w, r, "/messages/"+name, http.StatusSeeOther)
+}
+
+// getMessageableUser returns the id of the user called name, who must exist and not be the user themself.
+func getMessageableUser(db *sql.DB, name string, userID int) (int, error) {
+	if name == DeletedUserName {
+		return 0, errNoUser
+	}
+
+	var otherID int
+	err := db.QueryRow(Select_UserID_By_Name, name).Scan(&otherID)
+	if err == sql.ErrNoRows || otherID == userID {
+		return 0, errNoUser
+	}
+
+	return otherID, err
+}
+
+// conversationUsers orders two user ids the way the conversation table stores them.
+func conversationUsers(userID, otherID int) (int, int) {
+	if userID < otherID {
+		return userID, otherID
+	}
+
+	return otherID, userID
+}
+
+// getThreadData loads the conversation between the user and otherID and marks the messages received as read.
+func getThreadData(db *sql.DB, limits ContentLimits, userID, otherID int, name, storedToken string) (ThreadPageData, error) {
+	data := ThreadPageData{Token: storedToken, With: name, Messages: []Message{}, Content: limits}
+
+	if err := db.QueryRow(Select_UserName, userID).Scan(&data.UserName); err != nil {
+		return data, err
+	}
+
+	var count int
+	if err := db.QueryRow(Count_Block, userID, otherID).Scan(&count); err != nil {
+		return data, err
+	}
+	data.Blocked = count > 0
+
+	if err := db.QueryRow(Count_Block, otherID, userID).Scan(&count); err != nil {
+		return data, err
+	}
+	data.BlockedBy = count > 0
+
+	userA, userB := conversationUsers(userID, otherID)
+
+	var conversationID int
+	err := db.QueryRow(Select_Conversation_ID, userA, userB).Scan(&conversationID)
+	if err != nil && err != sql.ErrNoRows {
+		return data, err
+	}
+
+	if err == nil {
+		if _, err := db.Exec(Mark_Messages_Read, conversationID, userID); err != nil {
+			return data, err
+		}
+
+		if err := getMessages(db, conversationID, userID, &data); err != nil {
+			return data, err
+		}
+	}
+
+	data.Unread = unreadCount(db, userID)
+	data.UnreadMessages = unreadMessagesCount(db, userID)
+
+	return data, nil
+}
+
+// getMessages loads the messages of a conversation, the oldest first.
+func getMessages(db *sql.DB, conversationID, userID int, data *ThreadPageData) error {
+	rows, err := db.Query(Select_Messages, conversationID)
+	if err != nil {
+		return err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var message Message
+		var senderID int
+		var content string
+		var createdAt time.Time
+
+		if err := rows.Scan(&message.Id, &senderID, &message.AuthorName, &content, &createdAt); err != nil {
+			return err
+		}
+
+		message.Mine = senderID == userID
+		message.HTML = RenderMarkdown(content)
+		message.CreationDate = createdAt.Format("2006 Jan 2 15:04")
+
+		data.Messages = append(data.Messages, message)
+	}
+
+	return rows.Err()
+}
+
+// insertMessage stores a message, creating the conversation with its first message.
+func insertMessage(db *sql.DB, userID, otherID int, content string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	userA, userB := conversationUsers(userID, otherID)
+
+	var conversationID int64
+	err = tx.QueryRow(Select_Conversation_ID, userA, userB).Scan(&conversationID)
+	if err == sql.ErrNoRows {
+		result, err := tx.Exec(Insert_Conversation, userA, userB)
+		if err != nil {
+			return err
+		}
+
+		conversationID, err = result.LastInsertId()
+		if err != nil {
+			return err
+		}
+	} else if err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(Insert_Message, conversationID, userID, content); err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(Touch_Conversation, conversationID); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// unreadMessagesCount returns the number of messages the user received and didn't read, 0 for guests.
+func unreadMessagesCount(db *sql.DB, userID int) int {
+	if userID < 1 {
+		return 0
+	}
+
+	var count int
+	if err := db.QueryRow(Count_Unread_Messages, userID).Scan(&count); err != nil {
+		slog.Error("failed to count unread messages", "err", err)
+		return 0
+	}
+
+	return count
+}
@@ -0,0 +1,133 @@
+package functions
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func newMessagesRouter(database Database) *Router {
+	router := newTestRouter(database)
+	router.HandleFunc("GET /messages", database.Inbox, RequireLogin)
+	router.HandleFunc("GET /messages/{name}", database.Messages, RequireLogin)
+	router.HandleFunc("POST /messages/{name}", database.Messages, RequireLogin)
+	router.HandleFunc("POST /messages/{name}/block", database.Block, RequireLogin)
+	return router
+}
+
+func sendTestMessage(t *testing.T, router *Router, from testUser, to, content string) int {
+	t.Helper()
+
+	w := serve(router, &from, http.MethodPost, "/messages/"+to, url.Values{"content": {content}})
+	return w.Code
+}
+
+func TestMessagesOnlyParticipantsRead(t *testing.T) {
+	database := newTestDatabase(t)
+	router := newMessagesRouter(database)
+	alice := addTestUser(t, database, "alice")
+	bob := addTestUser(t, database, "bob")
+	carol := addTestUser(t, database, "carol")
+
+	if code := sendTestMessage(t, router, alice, "bob", "the secret plan"); code != http.StatusSeeOther {
+		t.Fatalf("sending a message answered %d", code)
+	}
+
+	if w := serve(router, &bob, http.MethodGet, "/messages/alice", nil); !strings.Contains(w.Body.String(), "the secret plan") {
+		t.Errorf("bob doesn't see the message of alice (%d)", w.Code)
+	}
+
+	for _, target := range []string{"/messages/alice", "/messages/bob", "/messages"} {
+		w := serve(router, &carol, http.MethodGet, target, nil)
+		if w.Code != http.StatusOK {
+			t.Errorf("GET %s for carol answered %d", target, w.Code)
+		}
+		if strings.Contains(w.Body.String(), "the secret plan") {
+			t.Errorf("carol reads the conversation of alice and bob on %s", target)
+		}
+	}
+
+	if w := serve(router, nil, http.MethodGet, "/messages/alice", nil); w.Code != http.StatusSeeOther {
+		t.Errorf("a visitor got %d instead of the login page", w.Code)
+	}
+}
+
+func TestMessagesMarkOnlyReceivedAsRead(t *testing.T) {
+	database := newTestDatabase(t)
+	alice := addTestUser(t, database, "alice")
+	bob := addTestUser(t, database, "bob")
+
+	// stored directly: sending through Messages opens the conversation, which reads it
+	for _, message := range []struct {
+		from, to int
+		content  string
+	}{{alice.Id, bob.Id, "from alice"}, {bob.Id, alice.Id, "from bob"}} {
+		if err := insertMessage(database.Db, message.from, message.to, message.content); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := getThreadData(database.Db, database.Content, alice.Id, bob.Id, "bob", alice.Token); err != nil {
+		t.Fatal(err)
+	}
+
+	read := map[string]bool{}
+	rows, err := database.Db.Query(`SELECT content, is_read FROM message`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var content string
+		var isRead bool
+		if err := rows.Scan(&content, &isRead); err != nil {
+			t.Fatal(err)
+		}
+		read[content] = isRead
+	}
+
+	if !read["from bob"] {
+		t.Error("the message alice received is still unread once the conversation is opened")
+	}
+	if read["from alice"] {
+		t.Error("the message alice sent was marked read by opening the conversation")
+	}
+
+	if count := unreadMessagesCount(database.Db, bob.Id); count != 1 {
+		t.Errorf("bob has %d unread messages, want 1", count)
+	}
+}
+
+func TestMessagesBlockBothDirections(t *testing.T) {
+	database := newTestDatabase(t)
+	router := newMessagesRouter(database)
+	alice := addTestUser(t, database, "alice")
+	bob := addTestUser(t, database, "bob")
+
+	if w := serve(router, &alice, http.MethodPost, "/messages/bob/block", url.Values{}); w.Code != http.StatusSeeOther {
+		t.Fatalf("blocking answered %d", w.Code)
+	}
+
+	if code := sendTestMessage(t, router, alice, "bob", "from the blocker"); code != http.StatusBadRequest {
+		t.Errorf("the blocker sent a message: %d", code)
+	}
+	if code := sendTestMessage(t, router, bob, "alice", "from the blocked"); code != http.StatusBadRequest {
+		t.Errorf("the blocked user sent a message: %d", code)
+	}
+
+	var count int
+	if err := database.Db.QueryRow(`SELECT COUNT(*) FROM message`).Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+	if count != 0 {
+		t.Errorf("%d messages were stored despite the block", count)
+	}
+
+	serve(router, &alice, http.MethodPost, "/messages/bob/block", url.Values{"action": {"unblock"}})
+
+	if code := sendTestMessage(t, router, bob, "alice", "after the unblock"); code != http.StatusSeeOther {
+		t.Errorf("sending after the unblock answered %d", code)
+	}
+}
@@ -0,0 +1,19 @@
+package functions
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// Migrate applies the Migrations so databases created by older versions match Initialize.
+func Migrate(db *sql.DB) error {
+	for _, migration := range Migrations {
+		_, err := db.Exec(migration)
+		if err != nil && !strings.Contains(err.Error(), "duplicate column name") {
+			return fmt.Errorf("migration %q failed: %w", migration, err)
+		}
+	}
+
+	return nil
+}
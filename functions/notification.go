@@ -0,0 +1,201 @@
+package functions
+
+import (
+	"database/sql"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// the kinds of notification
+const (
+	NotifyComment      = "comment"
+	NotifyCommentLiked = "comment_liked"
+	NotifyMention      = "mention"
+	NotifySubscribed   = "subscribed_comment"
+)
+
+// NotificationKinds lists every kind of notification with the label of its preference, in display order.
+var NotificationKinds = []NotificationPreference{
+	{Kind: NotifyComment, Label: "New comments on my posts"},
+	{Kind: NotifyCommentLiked, Label: "Likes on my comments"},
+	{Kind: NotifyMention, Label: "Mentions of my name"},
+	{Kind: NotifySubscribed, Label: "New comments on discussions I'm subscribed to"},
+}
+
+// notify tells userID that actorID did something about a post or one of its comments (commentID 0 for the post).
+// Nothing is sent for the user's own actions, for kinds the user turned off, or twice while the first is unread.
+func notify(db dbExecutor, userID, actorID int, kind string, postID, commentID int) error {
+	if userID == actorID {
+		return nil
+	}
+
+	enabled, err := notificationEnabled(db, userID, kind)
+	if err != nil || !enabled {
+		return err
+	}
+
+	var pending int
+	err = db.QueryRow(Count_Same_Unread, userID, actorID, kind, postID, commentID).Scan(&pending)
+	if err != nil || pending > 0 {
+		return err
+	}
+
+	comment := sql.NullInt64{Int64: int64(commentID), Valid: commentID > 0}
+
+	_, err = db.Exec(Insert_Notification, userID, actorID, kind, postID, comment)
+	return err
+}
+
+// notifyCommentAuthor tells the author of a comment that it was liked.
+func notifyCommentAuthor(db dbExecutor, actorID, commentID int) error {
+	var authorID, postID int
+	if err := db.QueryRow(Select_Comment_Author, commentID).Scan(&authorID, &postID); err != nil {
+		return err
+	}
+
+	return notify(db, authorID, actorID, NotifyCommentLiked, postID, commentID)
+}
+
+// notificationEnabled tells if the user wants this kind of notification, they are all on by default.
+func notificationEnabled(db dbExecutor, userID int, kind string) (bool, error) {
+	var enabled bool
+	err := db.QueryRow(Select_Notification_Preference, userID, kind).Scan(&enabled)
+	if err == sql.ErrNoRows {
+		return true, nil
+	}
+
+	return enabled, err
+}
+
+// unreadCount returns the number shown on the navbar badge, 0 for guests or when it can't be loaded.
+func unreadCount(db *sql.DB, userID int) int {
+	if userID < 1 {
+		return 0
+	}
+
+	var count int
+	if err := db.QueryRow(Count_Unread, userID).Scan(&count); err != nil {
+		slog.Error("failed to count notifications", "err", err)
+		return 0
+	}
+
+	return count
+}
+
+// Notifications shows the notifications of the user, newest first, with their preferences.
+func (database Database) Notifications(w http.ResponseWriter, r *http.Request) {
+	user := CurrentUser(r)
+
+	data, err := getNotificationsData(database.Db, user.Id, user.Token)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to load notifications", "err", err)
+		RenderError(w, errPleaseTryLater, 500)
+		return
+	}
+
+	ExecuteTemplate(w, "notifications.html", data, 200)
+}
+
+// NotificationsRead marks the notification of the form as read.
+func (database Database) NotificationsRead(w http.ResponseWriter, r *http.Request) {
+	database.markRead(w, r, false)
+}
+
+// NotificationsReadAll marks every notification of the user as read.
+func (database Database) NotificationsReadAll(w http.ResponseWriter, r *http.Request) {
+	database.markRead(w, r, true)
+}
+
+// markRead marks one notification, or all of them, as read and goes back to the notifications.
+func (database Database) markRead(w http.ResponseWriter, r *http.Request, all bool) {
+	user := CurrentUser(r)
+
+	var err error
+	if all {
+		_, err = database.Db.Exec(Mark_All_Read, user.Id)
+	} else {
+		id, convErr := strconv.Atoi(r.FormValue("id"))
+		if convErr != nil || id < 1 {
+			RenderError(w, "bad request", 400)
+			return
+		}
+
+		// the user id in the query keeps users from touching the notifications of others
+		_, err = database.Db.Exec(Mark_Notification_Read, id, user.Id)
+	}
+
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to mark notifications read", "err", err)
+		RenderError(w, errPleaseTryLater, 500)
+		return
+	}
+
+	http.Redirect(w, r, "/notifications", http.StatusSeeOther)
+}
+
+// NotificationPreferences saves which kinds of notification the user wants, from the checkboxes of the form.
+func (database Database) NotificationPreferences(w http.ResponseWriter, r *http.Request) {
+	user := CurrentUser(r)
+
+	for _, preference := range NotificationKinds {
+		enabled := r.FormValue(preference.Kind) == "on"
+
+		if _, err := database.Db.Exec(Upsert_Notification_Preference, user.Id, preference.Kind, enabled); err != nil {
+			slog.ErrorContext(r.Context(), "failed to save notification preference", "err", err)
+			RenderError(w, errPleaseTryLater, 500)
+			return
+		}
+	}
+
+	http.Redirect(w, r, "/notifications", http.StatusSeeOther)
+}
+
+// getNotificationsData loads what the notifications page needs to display.
+func getNotificationsData(db *sql.DB, userID int, storedToken string) (NotificationsPageData, error) {
+	data := NotificationsPageData{Token: storedToken, Notifications: []Notification{}}
+
+	if err := db.QueryRow(Select_UserName, userID).Scan(&data.UserName); err != nil {
+		return data, err
+	}
+
+	rows, err := db.Query(Select_Notifications, userID)
+	if err != nil {
+		return data, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var notification Notification
+		var createdAt time.Time
+
+		err := rows.Scan(&notification.Id, &notification.Kind, &notification.ActorName, &notification.PostId,
+			&notification.PostTitle, &notification.CommentId, &notification.IsRead, &createdAt)
+		if err != nil {
+			return data, err
+		}
+
+		notification.CreationDate = createdAt.Format("2006 Jan 2 15:04")
+		data.Notifications = append(data.Notifications, notification)
+	}
+
+	if err := rows.Err(); err != nil {
+		return data, err
+	}
+
+	data.Unread = unreadCount(db, userID)
+	data.UnreadMessages = unreadMessagesCount(db, userID)
+
+	for _, preference := range NotificationKinds {
+		preference.Enabled, err = notificationEnabled(db, userID, preference.Kind)
+		if err != nil {
+			return data, err
+		}
+
+		data.Preferences = append(data.Preferences, preference)
+	}
+
+	return data, nil
+}
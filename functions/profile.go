@@ -0,0 +1,203 @@
+package functions
+
+import (
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const postsPerPage = 10
+
+// Profile shows a user's public page: join date, activity counts and a paginated list of their posts.
+func (database Database) Profile(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	if name == DeletedUserName {
+		RenderError(w, errPageNotFound, 404)
+		return
+	}
+
+	page, err := getPageNumber(r)
+	if err != nil {
+		RenderError(w, "invalid page number", 400)
+		return
+	}
+
+	userID, storedToken := sessionOf(r)
+	home := InitializeData(database.Db, CurrentUser(r))
+
+	profile, err := getProfile(database.Db, name)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			RenderError(w, "this user doesn't exist", 404)
+			return
+		}
+
+		slog.ErrorContext(r.Context(), "failed to load profile", "err", err)
+		RenderError(w, errPleaseTryLater, 500)
+		return
+	}
+
+	data := ProfilePageData{
+		UserName:       home.UserName,
+		Unread:         home.Unread,
+		UnreadMessages: home.UnreadMessages,
+		Profile:        *profile,
+		Page:           page,
+	}
+
+	if userID > 0 {
+		data.Token = storedToken
+
+		var count int
+		if err := database.Db.QueryRow(Count_Follow, userID, profile.Id).Scan(&count); err != nil {
+			slog.ErrorContext(r.Context(), "failed to load follow", "err", err)
+			RenderError(w, errPleaseTryLater, 500)
+			return
+		}
+		data.Followed = count > 0
+	}
+
+	data.Posts, data.NextPage, err = getProfilePosts(database.Db, profile.Id, userID, storedToken, page)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to load profile posts", "err", err)
+		RenderError(w, errPleaseTryLater, 500)
+		return
+	}
+	data.PrevPage = page - 1
+
+	ExecuteTemplate(w, "profile.html", data, 200)
+}
+
+// Follow follows the user of /users/{name}/follow, or unfollows them when the form says action=unfollow.
+func (database Database) Follow(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	if name == DeletedUserName {
+		RenderError(w, errPageNotFound, 404)
+		return
+	}
+
+	user := CurrentUser(r)
+
+	var followedID int
+	err := database.Db.QueryRow(Select_UserID_By_Name, name).Scan(&followedID)
+	if err == sql.ErrNoRows {
+		RenderError(w, "this user doesn't exist", 404)
+		return
+	}
+
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to load followed user", "err", err)
+		RenderError(w, errPleaseTryLater, 500)
+		return
+	}
+
+	if followedID == user.Id {
+		RenderError(w, "you can't follow yourself", 400)
+		return
+	}
+
+	query := Insert_Follow
+	if r.FormValue("action") == "unfollow" {
+		query = Delete_Follow
+	}
+
+	if _, err := database.Db.Exec(query, user.Id, followedID); err != nil {
+		slog.ErrorContext(r.Context(), "failed to update follow", "err", err)
+		RenderError(w, errPleaseTryLater, 500)
+		return
+	}
+
+	http.Redirect(w, r, "/users/"+name, http.StatusSeeOther)
+}
+
+// getProfile loads the public information and activity counts of a user by name.
+func getProfile(db *sql.DB, name string) (*UserProfile, error) {
+	profile := &UserProfile{}
+	var joinedAt time.Time
+
+	err := db.QueryRow(Select_Profile, name).Scan(
+		&profile.Id,
+		&profile.Name,
+		&joinedAt,
+		&profile.Bio,
+		&profile.PostCount,
+		&profile.CommentCount,
+		&profile.Likes,
+		&profile.Dislikes,
+		&profile.Followers,
+		&profile.Following,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	profile.JoinDate = joinedAt.Format("2006 Jan 2")
+	profile.NetReactions = profile.Likes - profile.Dislikes
+
+	return profile, nil
+}
+
+// getPageNumber reads the optional ?page= parameter, pages start at 1.
+func getPageNumber(r *http.Request) (int, error) {
+	value := r.URL.Query().Get("page")
+	if value == "" {
+		return 1, nil
+	}
+
+	page, err := strconv.Atoi(value)
+	if err != nil || page < 1 {
+		return 0, fmt.Errorf("invalid page %q", value)
+	}
+
+	return page, nil
+}
+
+// getProfilePosts loads one page of the posts of a profile and the number of the next page, 0 when it is the last.
+// Only the posts of the page are built, one more id is read to know if another page follows.
+func getProfilePosts(db *sql.DB, authorID, userID int, storedToken string, page int) ([]Post, int, error) {
+	rows, err := db.Query(Select_Profile_Posts, authorID, postsPerPage+1, (page-1)*postsPerPage)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	postIDs := []int{}
+	for rows.Next() {
+		var postID int
+		if err := rows.Scan(&postID); err != nil {
+			return nil, 0, err
+		}
+		postIDs = append(postIDs, postID)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	// the rows must be closed before getPost caches the HTML, sqlite would see the update as a conflicting write
+	rows.Close()
+
+	next := 0
+	if len(postIDs) > postsPerPage {
+		postIDs = postIDs[:postsPerPage]
+		next = page + 1
+	}
+
+	posts := []Post{}
+	for _, postID := range postIDs {
+		post, err := getPost(postID, db, userID)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		if userID > 0 {
+			post.Token = storedToken
+		}
+		posts = append(posts, *post)
+	}
+
+	return posts, next, nil
+}
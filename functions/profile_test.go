@@ -0,0 +1,60 @@
+package functions
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestProfilePostsPages(t *testing.T) {
+	database := newTestDatabase(t)
+	alice := addTestUser(t, database, "alice")
+	bob := addTestUser(t, database, "bob")
+
+	for i := 1; i <= postsPerPage+2; i++ {
+		if _, err := database.Db.Exec(Insert_Post, alice.Id, "post "+strconv.Itoa(i), "content", "", 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := database.Db.Exec(Insert_Post, bob.Id, "post of bob", "content", "", 0); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		page, count, next int
+		first             string
+	}{
+		{1, postsPerPage, 2, "post 12"},
+		{2, 2, 0, "post 2"},
+		{3, 0, 0, ""},
+	}
+
+	for _, test := range tests {
+		posts, next, err := getProfilePosts(database.Db, alice.Id, bob.Id, bob.Token, test.page)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(posts) != test.count || next != test.next {
+			t.Errorf("page %d has %d posts and the next page %d, want %d and %d", test.page, len(posts), next, test.count, test.next)
+		}
+
+		if len(posts) > 0 && (posts[0].Title != test.first || posts[0].Token != bob.Token) {
+			t.Errorf("page %d starts with %q (token %q), want %q", test.page, posts[0].Title, posts[0].Token, test.first)
+		}
+	}
+
+	router := newTestRouter(database)
+	router.HandleFunc("GET /users/{name}", database.Profile)
+
+	w := serve(router, nil, http.MethodGet, "/users/alice?page=2", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("the second page answered %d", w.Code)
+	}
+
+	body := w.Body.String()
+	if !strings.Contains(body, "post 1<") || strings.Contains(body, "post 3<") || strings.Contains(body, "post of bob") {
+		t.Errorf("the second page doesn't list the two oldest posts of alice only")
+	}
+}
@@ -6,7 +6,13 @@ CREATE TABLE IF NOT EXISTS user (
     id INTEGER PRIMARY KEY AUTOINCREMENT,
     name TEXT UNIQUE NOT NULL,
     email TEXT UNIQUE NOT NULL,
-    password TEXT NOT NULL
+    password TEXT NOT NULL,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    bio TEXT NOT NULL DEFAULT '',
+    avatar TEXT NOT NULL DEFAULT '',
+    role TEXT NOT NULL DEFAULT 'user',
+    digest TEXT NOT NULL DEFAULT 'off',
+    digest_sent_at DATETIME
 );
 
 CREATE TABLE IF NOT EXISTS session (
@@ -23,6 +29,8 @@ CREATE TABLE IF NOT EXISTS post (
     user_id INTEGER NOT NULL,
     title TEXT NOT NULL,
     content TEXT NOT NULL,
+    content_html TEXT NOT NULL DEFAULT '',
+    html_rev INTEGER NOT NULL DEFAULT 0,
     created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
     FOREIGN KEY (user_id) REFERENCES user(id)
 );
@@ -32,6 +40,8 @@ CREATE TABLE IF NOT EXISTS comment (
     post_id INTEGER NOT NULL,
     user_id INTEGER NOT NULL,
     content TEXT NOT NULL,
+    content_html TEXT NOT NULL DEFAULT '',
+    html_rev INTEGER NOT NULL DEFAULT 0,
     created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
     FOREIGN KEY (post_id) REFERENCES post(id),
     FOREIGN KEY (user_id) REFERENCES user(id)
@@ -62,11 +72,220 @@ CREATE TABLE IF NOT EXISTS reaction (
     FOREIGN KEY (comment_id) REFERENCES comment(id),
     CONSTRAINT unique_reaction UNIQUE (user_id, post_id, comment_id)
 );
+
+CREATE TABLE IF NOT EXISTS attachment (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    post_id INTEGER NOT NULL,
+    name TEXT NOT NULL,
+    mime TEXT NOT NULL,
+    size INTEGER NOT NULL,
+    blob_key TEXT NOT NULL,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (post_id) REFERENCES post(id)
+);
+
+CREATE TABLE IF NOT EXISTS mention (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL,
+    post_id INTEGER NOT NULL,
+    comment_id INTEGER,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (user_id) REFERENCES user(id),
+    FOREIGN KEY (post_id) REFERENCES post(id),
+    FOREIGN KEY (comment_id) REFERENCES comment(id)
+);
+
+CREATE TABLE IF NOT EXISTS notification (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL,
+    actor_id INTEGER NOT NULL,
+    kind TEXT NOT NULL,
+    post_id INTEGER,
+    comment_id INTEGER,
+    is_read BOOLEAN NOT NULL DEFAULT false,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (user_id) REFERENCES user(id),
+    FOREIGN KEY (actor_id) REFERENCES user(id),
+    FOREIGN KEY (post_id) REFERENCES post(id),
+    FOREIGN KEY (comment_id) REFERENCES comment(id)
+);
+
+CREATE TABLE IF NOT EXISTS notification_preference (
+    user_id INTEGER NOT NULL,
+    kind TEXT NOT NULL,
+    enabled BOOLEAN NOT NULL,
+    FOREIGN KEY (user_id) REFERENCES user(id),
+    PRIMARY KEY (user_id, kind)
+);
+
+CREATE TABLE IF NOT EXISTS conversation (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_a INTEGER NOT NULL,
+    user_b INTEGER NOT NULL,
+    updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (user_a) REFERENCES user(id),
+    FOREIGN KEY (user_b) REFERENCES user(id),
+    CONSTRAINT unique_conversation UNIQUE (user_a, user_b)
+);
+
+CREATE TABLE IF NOT EXISTS message (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    conversation_id INTEGER NOT NULL,
+    sender_id INTEGER NOT NULL,
+    content TEXT NOT NULL,
+    is_read BOOLEAN NOT NULL DEFAULT false,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (conversation_id) REFERENCES conversation(id),
+    FOREIGN KEY (sender_id) REFERENCES user(id)
+);
+
+CREATE TABLE IF NOT EXISTS block (
+    blocker_id INTEGER NOT NULL,
+    blocked_id INTEGER NOT NULL,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (blocker_id) REFERENCES user(id),
+    FOREIGN KEY (blocked_id) REFERENCES user(id),
+    PRIMARY KEY (blocker_id, blocked_id)
+);
+
+CREATE TABLE IF NOT EXISTS chat_message (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    category_id INTEGER NOT NULL,
+    user_id INTEGER NOT NULL,
+    content TEXT NOT NULL,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (category_id) REFERENCES category(id),
+    FOREIGN KEY (user_id) REFERENCES user(id)
+);
+
+CREATE TABLE IF NOT EXISTS chat_mute (
+    category_id INTEGER NOT NULL,
+    user_id INTEGER NOT NULL,
+    until DATETIME NOT NULL,
+    FOREIGN KEY (category_id) REFERENCES category(id),
+    FOREIGN KEY (user_id) REFERENCES user(id),
+    PRIMARY KEY (category_id, user_id)
+);
+
+CREATE TABLE IF NOT EXISTS chat_ban (
+    category_id INTEGER NOT NULL,
+    user_id INTEGER NOT NULL,
+    until DATETIME NOT NULL,
+    FOREIGN KEY (category_id) REFERENCES category(id),
+    FOREIGN KEY (user_id) REFERENCES user(id),
+    PRIMARY KEY (category_id, user_id)
+);
+
+CREATE TABLE IF NOT EXISTS report (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    post_id INTEGER NOT NULL,
+    user_id INTEGER NOT NULL,
+    reason TEXT NOT NULL,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (post_id) REFERENCES post(id),
+    FOREIGN KEY (user_id) REFERENCES user(id),
+    UNIQUE (post_id, user_id)
+);
+
+CREATE TABLE IF NOT EXISTS bookmark (
+    user_id INTEGER NOT NULL,
+    post_id INTEGER NOT NULL,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (user_id) REFERENCES user(id),
+    FOREIGN KEY (post_id) REFERENCES post(id),
+    PRIMARY KEY (user_id, post_id)
+);
+
+CREATE TABLE IF NOT EXISTS subscription (
+    user_id INTEGER NOT NULL,
+    post_id INTEGER NOT NULL,
+    email BOOLEAN NOT NULL DEFAULT false,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (user_id) REFERENCES user(id),
+    FOREIGN KEY (post_id) REFERENCES post(id),
+    PRIMARY KEY (user_id, post_id)
+);
+
+CREATE TABLE IF NOT EXISTS digest_category (
+    user_id INTEGER NOT NULL,
+    category_id INTEGER NOT NULL,
+    FOREIGN KEY (user_id) REFERENCES user(id),
+    FOREIGN KEY (category_id) REFERENCES category(id),
+    PRIMARY KEY (user_id, category_id)
+);
+
+CREATE TABLE IF NOT EXISTS setting (
+    key TEXT PRIMARY KEY,
+    value TEXT NOT NULL
+);
+
+-- authors of the posts written before subscriptions existed follow their discussions, done once
+INSERT OR IGNORE INTO subscription (user_id, post_id)
+SELECT user_id, id FROM post
+WHERE user_id NOT IN (SELECT id FROM user WHERE name = '[deleted]')
+AND NOT EXISTS (SELECT 1 FROM setting WHERE key = 'subscriptions_backfilled');
+INSERT OR IGNORE INTO setting (key, value) VALUES ('subscriptions_backfilled', '1');
+
+CREATE TABLE IF NOT EXISTS follow (
+    follower_id INTEGER NOT NULL,
+    followed_id INTEGER NOT NULL,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (follower_id) REFERENCES user(id),
+    FOREIGN KEY (followed_id) REFERENCES user(id),
+    PRIMARY KEY (follower_id, followed_id)
+);
+
+CREATE TABLE IF NOT EXISTS webhook (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    url TEXT NOT NULL,
+    secret TEXT NOT NULL,
+    events TEXT NOT NULL,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS webhook_delivery (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    webhook_id INTEGER NOT NULL,
+    event TEXT NOT NULL,
+    payload TEXT NOT NULL,
+    status TEXT NOT NULL DEFAULT 'pending',
+    attempts INTEGER NOT NULL DEFAULT 0,
+    response_code INTEGER NOT NULL DEFAULT 0,
+    error TEXT NOT NULL DEFAULT '',
+    next_attempt_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    delivered_at DATETIME,
+    FOREIGN KEY (webhook_id) REFERENCES webhook(id)
+);
+
+INSERT INTO category (type) SELECT 'Technology' WHERE NOT EXISTS (SELECT 1 FROM category WHERE type = 'Technology');
+INSERT INTO category (type) SELECT 'Science' WHERE NOT EXISTS (SELECT 1 FROM category WHERE type = 'Science');
+INSERT INTO category (type) SELECT 'Art' WHERE NOT EXISTS (SELECT 1 FROM category WHERE type = 'Art');
+INSERT INTO category (type) SELECT 'Gaming' WHERE NOT EXISTS (SELECT 1 FROM category WHERE type = 'Gaming');
+INSERT INTO category (type) SELECT 'Other' WHERE NOT EXISTS (SELECT 1 FROM category WHERE type = 'Other');
+
+INSERT OR IGNORE INTO user (name, email, password) VALUES ('[deleted]', 'deleted@agora.invalid', '!');
 `
 
+// Migrations upgrade databases created before a column was added to Initialize.
+// They must be safe to run on every start: a "duplicate column name" error means it is already applied.
+var Migrations = []string{
+	`ALTER TABLE user ADD COLUMN created_at DATETIME`,
+	`ALTER TABLE user ADD COLUMN bio TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE user ADD COLUMN avatar TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE user ADD COLUMN role TEXT NOT NULL DEFAULT 'user'`,
+	`ALTER TABLE user ADD COLUMN digest TEXT NOT NULL DEFAULT 'off'`,
+	`ALTER TABLE user ADD COLUMN digest_sent_at DATETIME`,
+	`ALTER TABLE post ADD COLUMN content_html TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE post ADD COLUMN html_rev INTEGER NOT NULL DEFAULT 0`,
+	`ALTER TABLE comment ADD COLUMN content_html TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE comment ADD COLUMN html_rev INTEGER NOT NULL DEFAULT 0`,
+	`UPDATE user SET created_at = IFNULL((SELECT MIN(p.created_at) FROM post p WHERE p.user_id = user.id), CURRENT_TIMESTAMP) WHERE created_at IS NULL`,
+}
+
 // for register, login 	and logout
 const (
-	Insert_User          = `INSERT INTO user (name, email, password) VALUES (?, ?, ?)`
+	Insert_User          = `INSERT INTO user (name, email, password, created_at) VALUES (?, ?, ?, CURRENT_TIMESTAMP)`
 	Select_UserCount     = `SELECT COUNT(*) FROM user WHERE name = ? OR email = ?`
 	Select_UserID_and_Pw = `SELECT id, password FROM user WHERE name = ?`
 	Delete_User_Session  = `DELETE FROM session where user_id=? `
@@ -76,7 +295,7 @@ const (
 
 // for create post
 const (
-	Insert_Post          = `INSERT INTO post (user_id, title, content) VALUES(?,?,?)`
+	Insert_Post          = `INSERT INTO post (user_id, title, content, content_html, html_rev) VALUES(?,?,?,?,?)`
 	Insert_Category      = `INSERT INTO category(type) VALUES (?)`
 	Select_CategoryID    = `SELECT id FROM category WHERE type = ?`
 	INsert_Post_Category = `INSERT INTO post_category(post_id, category_id) VALUES (?, ?)`
@@ -85,7 +304,7 @@ const (
 // for retrieving post Data
 const (
 	Select_Post_Basics = `
-	SELECT p.user_id, p.title, p.content, p.created_at , u.name
+	SELECT p.user_id, p.title, p.content, p.content_html, p.html_rev, p.created_at , u.name
 	FROM post p
 	Join user u On u.id = p.user_id
 	WHERE p.id = ?
@@ -105,12 +324,13 @@ const (
 	`
 
 	Select_Reacted_On_Post = `SELECT is_like FROM reaction WHERE post_id = ? AND user_id = ?`
+	Select_Bookmarked      = `SELECT COUNT(*) FROM bookmark WHERE post_id = ? AND user_id = ?`
 )
 
 // for retrieving comment Data
 const (
 	Select_Comment_Basics = `
-	SELECT c.id, c.user_Id, c.content, c.created_at, u.name,
+	SELECT c.id, c.user_Id, c.content, c.content_html, c.html_rev, c.created_at, u.name,
     (SELECT COUNT(*) FROM reaction r WHERE r.comment_id = c.id AND r.is_like = true),
     (SELECT COUNT(*) FROM reaction r WHERE r.comment_id = c.id AND r.is_like = false)
 	FROM comment c
@@ -122,16 +342,16 @@ const (
 )
 
 // for create Comment
-const Insert_Comment = `INSERT INTO comment(post_id, user_id, content) VALUES (?, ?, ?)`
+const Insert_Comment = `INSERT INTO comment(post_id, user_id, content, content_html, html_rev) VALUES (?, ?, ?, ?, ?)`
+
+// for the rendered HTML cache, refreshed when the markdown renderer changes
+const (
+	Update_Post_HTML    = `UPDATE post SET content_html = ?, html_rev = ? WHERE id = ?`
+	Update_Comment_HTML = `UPDATE comment SET content_html = ?, html_rev = ? WHERE id = ?`
+)
 
 // for home
 const (
-	Select_UserId_Csrf_UserName = `
-	SELECT s.user_id, s.token, u.name
-	FROM session s
-	JOIN user u ON u.id = s.user_id
-	 WHERE s.id = ? AND expire_at > CURRENT_TIMESTAMP
-	`
 	Filter_Liked = `
 	SELECT p.id
 	FROM post p
@@ -139,20 +359,423 @@ const (
 	WHERE r.user_id = ? AND r.is_like = true
 	ORDER BY p.created_at DESC
 	`
+	Filter_Saved = `
+	SELECT p.id
+	FROM post p
+	JOIN bookmark b ON p.id = b.post_id
+	WHERE b.user_id = ?
+	ORDER BY b.created_at DESC
+	`
+	Filter_Following = `
+	SELECT p.id
+	FROM post p
+	JOIN follow f ON p.user_id = f.followed_id
+	WHERE f.follower_id = ?
+	ORDER BY p.created_at DESC
+	`
 	Filter_Mine = `SELECT id FROM post WHERE user_id = ? ORDER BY created_at DESC`
 	No_Filter   = `SELECT id FROM post ORDER BY created_at DESC`
 )
 
+// ?1 author, ?2 limit, ?3 offset: a page of the posts of a profile, the newest first
+const Select_Profile_Posts = `SELECT id FROM post WHERE user_id = ?1 ORDER BY created_at DESC, id DESC LIMIT ?2 OFFSET ?3`
+
+// for the feeds, the newest posts first
+const (
+	Feed_All      = `SELECT id FROM post ORDER BY created_at DESC LIMIT ?`
+	Feed_Author   = `SELECT id FROM post WHERE user_id = ? ORDER BY created_at DESC LIMIT ?`
+	Feed_Category = `
+	SELECT p.id
+	FROM post p
+	JOIN post_category pc ON pc.post_id = p.id
+	JOIN category c ON c.id = pc.category_id
+	WHERE c.type = ?
+	ORDER BY p.created_at DESC
+	LIMIT ?`
+)
+
+// for the live streams
+const Select_Comment_Counts = `
+	SELECT c.post_id,
+		(SELECT COUNT(*) FROM reaction r WHERE r.comment_id = c.id AND r.is_like = true),
+		(SELECT COUNT(*) FROM reaction r WHERE r.comment_id = c.id AND r.is_like = false)
+	FROM comment c
+	WHERE c.id = ?
+	`
+
 // for reaction
 const (
-	Verify_PostID    = `SELECT title FROM post WHERE id =?`
-	Verify_CommentID = `SELECT content FROM comment WHERE id =?`
+	Verify_PostID     = `SELECT title FROM post WHERE id =?`
+	Verify_CommentID  = `SELECT content FROM comment WHERE id =?`
+	Verify_CategoryID = `SELECT type FROM category WHERE id = ?`
 	// reaction have other query but they are dynamics
 )
 
+// for subscriptions
+const (
+	Insert_Subscription        = `INSERT OR IGNORE INTO subscription (user_id, post_id) VALUES (?, ?)`
+	Delete_Subscription        = `DELETE FROM subscription WHERE user_id = ? AND post_id = ?`
+	Enable_Subscription_Email  = `UPDATE subscription SET email = true WHERE user_id = ? AND post_id = ?`
+	Disable_Subscription_Email = `UPDATE subscription SET email = false WHERE user_id = ? AND post_id = ?`
+	Select_Subscription        = `SELECT email FROM subscription WHERE user_id = ? AND post_id = ?`
+	Select_Subscribers         = `SELECT user_id FROM subscription WHERE post_id = ?`
+
+	Insert_Setting = `INSERT OR IGNORE INTO setting (key, value) VALUES (?, ?)`
+	Select_Setting = `SELECT value FROM setting WHERE key = ?`
+)
+
+// for email digests
+const (
+	Select_Digest = `SELECT digest FROM user WHERE id = ?`
+	// the first digest comes one period after it was turned on, not right away
+	Update_Digest = `
+	UPDATE user SET digest = ?1,
+		digest_sent_at = CASE WHEN digest = 'off' THEN CURRENT_TIMESTAMP ELSE digest_sent_at END
+	WHERE id = ?2
+	`
+	Disable_Digest        = `UPDATE user SET digest = 'off' WHERE id = ?`
+	Select_Digest_Choices = `
+	SELECT c.id, c.type, EXISTS (SELECT 1 FROM digest_category d WHERE d.user_id = ? AND d.category_id = c.id)
+	FROM category c
+	ORDER BY c.id
+	`
+	Delete_Digest_Categories = `DELETE FROM digest_category WHERE user_id = ?`
+	Insert_Digest_Category   = `INSERT OR IGNORE INTO digest_category (user_id, category_id) VALUES (?, ?)`
+
+	Select_Digest_Users      = `SELECT id, name, email, digest, digest_sent_at FROM user WHERE digest IN ('daily', 'weekly')`
+	Update_Digest_Sent       = `UPDATE user SET digest_sent_at = ? WHERE id = ?`
+	Select_Digest_Categories = `
+	SELECT c.id, c.type
+	FROM digest_category d
+	JOIN category c ON c.id = d.category_id
+	WHERE d.user_id = ?
+	ORDER BY c.id
+	`
+	// ?1 category, ?2 since, ?3 reader: the most liked posts of others in the category
+	Digest_Top_Posts = `
+	SELECT p.id, p.title, u.name,
+		(SELECT COUNT(*) FROM reaction r WHERE r.post_id = p.id AND r.is_like = true) AS likes,
+		(SELECT COUNT(*) FROM comment c WHERE c.post_id = p.id)
+	FROM post p
+	JOIN user u ON u.id = p.user_id
+	JOIN post_category pc ON pc.post_id = p.id
+	WHERE pc.category_id = ?1 AND p.created_at > ?2 AND p.user_id != ?3
+	ORDER BY likes DESC, p.created_at DESC
+	LIMIT 5
+	`
+	// ?1 reader, ?2 since: the comments of others on the reader's posts
+	Digest_Replies = `
+	SELECT p.id, p.title, u.name, c.content
+	FROM comment c
+	JOIN post p ON p.id = c.post_id
+	JOIN user u ON u.id = c.user_id
+	WHERE p.user_id = ?1 AND c.user_id != ?1 AND c.created_at > ?2
+	ORDER BY c.created_at DESC
+	LIMIT 20
+	`
+	// ?1 reader, ?2 since: the discussions of others the reader wants by email, with their number of new comments
+	Digest_Discussions = `
+	SELECT p.id, p.title, COUNT(c.id)
+	FROM subscription s
+	JOIN post p ON p.id = s.post_id
+	JOIN comment c ON c.post_id = p.id
+	WHERE s.user_id = ?1 AND s.email = true AND p.user_id != ?1 AND c.user_id != ?1 AND c.created_at > ?2
+	GROUP BY p.id, p.title
+	ORDER BY MAX(c.created_at) DESC
+	LIMIT 20
+	`
+)
+
+// for reports, a user reports a post once
+const Insert_Report = `INSERT OR IGNORE INTO report (post_id, user_id, reason) VALUES (?, ?, ?)`
+
+// for bookmarks
+const (
+	Insert_Bookmark = `INSERT OR IGNORE INTO bookmark (user_id, post_id) VALUES (?, ?)`
+	Delete_Bookmark = `DELETE FROM bookmark WHERE user_id = ? AND post_id = ?`
+)
+
 // for utils
 const (
-	Select_UserID_and_Session = `SELECT user_id, token FROM session WHERE id = ? AND expire_at > CURRENT_TIMESTAMP`
-	Select_PostID             = `SELECT post_id FROM comment WHERE id = ?`
-	Select_UserName           = `SELECT name FROM user WHERE id = ?`
+	Select_Session_User = `
+	SELECT s.user_id, s.token, u.name, u.role
+	FROM session s
+	JOIN user u ON u.id = s.user_id
+	WHERE s.id = ? AND s.expire_at > CURRENT_TIMESTAMP`
+	Select_PostID   = `SELECT post_id FROM comment WHERE id = ?`
+	Select_UserName = `SELECT name FROM user WHERE id = ?`
+)
+
+// for account export and deletion
+const (
+	DeletedUserName = "[deleted]"
+
+	Select_Account       = `SELECT name, email, bio FROM user WHERE id = ?`
+	Select_Password      = `SELECT password FROM user WHERE id = ?`
+	Select_DeletedUserID = `SELECT id FROM user WHERE name = '[deleted]'`
+
+	Export_Posts = `
+	SELECT p.id, p.title, p.content, p.created_at,
+	IFNULL((SELECT GROUP_CONCAT(c.type) FROM category c JOIN post_category pc ON pc.category_id = c.id WHERE pc.post_id = p.id), '')
+	FROM post p
+	WHERE p.user_id = ?
+	ORDER BY p.created_at
+	`
+	Export_Comments = `SELECT id, post_id, content, created_at FROM comment WHERE user_id = ? ORDER BY created_at`
+	Export_Messages = `
+	SELECT u.name, m.content, m.created_at
+	FROM message m
+	JOIN conversation c ON c.id = m.conversation_id
+	JOIN user u ON u.id = CASE WHEN c.user_a = m.sender_id THEN c.user_b ELSE c.user_a END
+	WHERE m.sender_id = ?
+	ORDER BY m.created_at
+	`
+	Export_Chat_Messages = `
+	SELECT c.type, m.content, m.created_at
+	FROM chat_message m
+	JOIN category c ON c.id = m.category_id
+	WHERE m.user_id = ?
+	ORDER BY m.created_at
+	`
+	Export_Reactions     = `SELECT IFNULL(post_id, 0), IFNULL(comment_id, 0), is_like, created_at FROM reaction WHERE user_id = ? ORDER BY created_at`
+	Export_Bookmarks     = `SELECT post_id, created_at FROM bookmark WHERE user_id = ? ORDER BY created_at`
+	Export_Subscriptions = `SELECT post_id, email, created_at FROM subscription WHERE user_id = ? ORDER BY created_at`
+	Export_Following     = `
+	SELECT u.name, f.created_at
+	FROM follow f
+	JOIN user u ON u.id = f.followed_id
+	WHERE f.follower_id = ?
+	ORDER BY f.created_at
+	`
+)
+
+// Anonymise_User keeps the user's posts and comments but moves them to the "[deleted]" placeholder.
+// ?1 is the deleted user's id and ?2 the placeholder's id.
+var Anonymise_User = []string{
+	`UPDATE post SET user_id = ?2 WHERE user_id = ?1`,
+	`UPDATE comment SET user_id = ?2 WHERE user_id = ?1`,
+	`UPDATE chat_message SET user_id = ?2 WHERE user_id = ?1`,
+}
+
+// Hard_Delete_User removes everything the user wrote and everything attached to it, children first.
+// ?1 is the deleted user's id.
+var Hard_Delete_User = []string{
+	`DELETE FROM reaction WHERE comment_id IN (SELECT id FROM comment WHERE user_id = ?1 OR post_id IN (SELECT id FROM post WHERE user_id = ?1))`,
+	`DELETE FROM reaction WHERE post_id IN (SELECT id FROM post WHERE user_id = ?1)`,
+	`DELETE FROM mention WHERE post_id IN (SELECT id FROM post WHERE user_id = ?1) OR comment_id IN (SELECT id FROM comment WHERE user_id = ?1)`,
+	`DELETE FROM notification WHERE post_id IN (SELECT id FROM post WHERE user_id = ?1) OR comment_id IN (SELECT id FROM comment WHERE user_id = ?1)`,
+	`DELETE FROM comment WHERE user_id = ?1 OR post_id IN (SELECT id FROM post WHERE user_id = ?1)`,
+	`DELETE FROM post_category WHERE post_id IN (SELECT id FROM post WHERE user_id = ?1)`,
+	`DELETE FROM bookmark WHERE post_id IN (SELECT id FROM post WHERE user_id = ?1)`,
+	`DELETE FROM report WHERE post_id IN (SELECT id FROM post WHERE user_id = ?1)`,
+	`DELETE FROM subscription WHERE post_id IN (SELECT id FROM post WHERE user_id = ?1)`,
+	`DELETE FROM attachment WHERE post_id IN (SELECT id FROM post WHERE user_id = ?1)`,
+	`DELETE FROM post WHERE user_id = ?1`,
+	`DELETE FROM chat_message WHERE user_id = ?1`,
+}
+
+// Delete_User_Rows removes the rows that always belong to the user, whatever the deletion mode.
+// ?1 is the deleted user's id.
+var Delete_User_Rows = []string{
+	`DELETE FROM reaction WHERE user_id = ?1`,
+	// the links to the user's profile disappear from the content mentioning them
+	`UPDATE post SET html_rev = 0 WHERE id IN (SELECT post_id FROM mention WHERE user_id = ?1 AND comment_id IS NULL)`,
+	`UPDATE comment SET html_rev = 0 WHERE id IN (SELECT comment_id FROM mention WHERE user_id = ?1)`,
+	`DELETE FROM mention WHERE user_id = ?1`,
+	`DELETE FROM notification WHERE user_id = ?1 OR actor_id = ?1`,
+	`DELETE FROM notification_preference WHERE user_id = ?1`,
+	`DELETE FROM message WHERE conversation_id IN (SELECT id FROM conversation WHERE user_a = ?1 OR user_b = ?1)`,
+	`DELETE FROM conversation WHERE user_a = ?1 OR user_b = ?1`,
+	`DELETE FROM block WHERE blocker_id = ?1 OR blocked_id = ?1`,
+	`DELETE FROM chat_mute WHERE user_id = ?1`,
+	`DELETE FROM chat_ban WHERE user_id = ?1`,
+	`DELETE FROM bookmark WHERE user_id = ?1`,
+	`DELETE FROM report WHERE user_id = ?1`,
+	`DELETE FROM subscription WHERE user_id = ?1`,
+	`DELETE FROM digest_category WHERE user_id = ?1`,
+	`DELETE FROM follow WHERE follower_id = ?1 OR followed_id = ?1`,
+	`DELETE FROM session WHERE user_id = ?1`,
+	`DELETE FROM user WHERE id = ?1`,
+}
+
+// for profiles
+const (
+	Select_Profile = `
+	SELECT u.id, u.name, u.created_at, u.bio,
+		(SELECT COUNT(*) FROM post p WHERE p.user_id = u.id),
+		(SELECT COUNT(*) FROM comment c WHERE c.user_id = u.id),
+		(SELECT COUNT(*) FROM reaction r WHERE r.is_like = true AND (
+			r.post_id IN (SELECT id FROM post WHERE user_id = u.id)
+			OR r.comment_id IN (SELECT id FROM comment WHERE user_id = u.id))),
+		(SELECT COUNT(*) FROM reaction r WHERE r.is_like = false AND (
+			r.post_id IN (SELECT id FROM post WHERE user_id = u.id)
+			OR r.comment_id IN (SELECT id FROM comment WHERE user_id = u.id))),
+		(SELECT COUNT(*) FROM follow f WHERE f.followed_id = u.id),
+		(SELECT COUNT(*) FROM follow f WHERE f.follower_id = u.id)
+	FROM user u
+	WHERE u.name = ?
+	`
+	Update_Bio = `UPDATE user SET bio = ? WHERE id = ?`
+
+	Count_Follow  = `SELECT COUNT(*) FROM follow WHERE follower_id = ? AND followed_id = ?`
+	Insert_Follow = `INSERT OR IGNORE INTO follow (follower_id, followed_id) VALUES (?, ?)`
+	Delete_Follow = `DELETE FROM follow WHERE follower_id = ? AND followed_id = ?`
+)
+
+// for avatars
+const (
+	Select_Avatar       = `SELECT avatar FROM user WHERE name = ?`
+	Select_Avatar_By_ID = `SELECT avatar FROM user WHERE id = ?`
+	Update_Avatar       = `UPDATE user SET avatar = ? WHERE id = ?`
+	Count_Avatar_Users  = `SELECT COUNT(*) FROM user WHERE avatar = ?`
+)
+
+// for attachments
+const (
+	Insert_Attachment       = `INSERT INTO attachment (post_id, name, mime, size, blob_key) VALUES (?, ?, ?, ?, ?)`
+	Select_Attachment       = `SELECT name, mime, size, blob_key FROM attachment WHERE id = ?`
+	Select_Post_Attachments = `SELECT id, name, mime, size FROM attachment WHERE post_id = ? ORDER BY id`
+	Select_User_Blob_Keys   = `SELECT a.blob_key FROM attachment a JOIN post p ON p.id = a.post_id WHERE p.user_id = ?`
+)
+
+// for mentions and notifications
+const (
+	Select_UserID_By_Name = `SELECT id FROM user WHERE name = ?`
+	Insert_Mention        = `INSERT INTO mention (user_id, post_id, comment_id) VALUES (?, ?, ?)`
+	Select_Mentions       = `
+	SELECT u.name, u.id
+	FROM mention m
+	JOIN user u ON u.id = m.user_id
+	WHERE m.post_id = ? AND IFNULL(m.comment_id, 0) = ?
+	`
+	Insert_Notification = `INSERT INTO notification (user_id, actor_id, kind, post_id, comment_id) VALUES (?, ?, ?, ?, ?)`
+	Count_Same_Unread   = `
+	SELECT COUNT(*) FROM notification
+	WHERE user_id = ? AND actor_id = ? AND kind = ? AND post_id = ? AND IFNULL(comment_id, 0) = ? AND is_read = false
+	`
+	Select_Post_Author    = `SELECT user_id FROM post WHERE id = ?`
+	Select_Comment_Author = `SELECT user_id, post_id FROM comment WHERE id = ?`
+	Count_Unread          = `SELECT COUNT(*) FROM notification WHERE user_id = ? AND is_read = false`
+	Select_Notifications  = `
+	SELECT n.id, n.kind, u.name, n.post_id, p.title, IFNULL(n.comment_id, 0), n.is_read, n.created_at
+	FROM notification n
+	JOIN user u ON u.id = n.actor_id
+	JOIN post p ON p.id = n.post_id
+	WHERE n.user_id = ?
+	ORDER BY n.created_at DESC, n.id DESC
+	LIMIT 100
+	`
+	Mark_Notification_Read = `UPDATE notification SET is_read = true WHERE id = ? AND user_id = ?`
+	Mark_All_Read          = `UPDATE notification SET is_read = true WHERE user_id = ? AND is_read = false`
+
+	Select_Notification_Preference = `SELECT enabled FROM notification_preference WHERE user_id = ? AND kind = ?`
+	Upsert_Notification_Preference = `
+	INSERT INTO notification_preference (user_id, kind, enabled) VALUES (?, ?, ?)
+	ON CONFLICT (user_id, kind) DO UPDATE SET enabled = excluded.enabled
+	`
+)
+
+// for direct messages, a conversation stores its two users as user_a < user_b
+const (
+	Select_Conversation_ID = `SELECT id FROM conversation WHERE user_a = ? AND user_b = ?`
+	Insert_Conversation    = `INSERT INTO conversation (user_a, user_b) VALUES (?, ?)`
+	Touch_Conversation     = `UPDATE conversation SET updated_at = CURRENT_TIMESTAMP WHERE id = ?`
+	Insert_Message         = `INSERT INTO message (conversation_id, sender_id, content) VALUES (?, ?, ?)`
+	Select_Inbox           = `
+	SELECT c.id, u.name, c.updated_at,
+		IFNULL((SELECT m.content FROM message m WHERE m.conversation_id = c.id ORDER BY m.id DESC LIMIT 1), ''),
+		(SELECT COUNT(*) FROM message m WHERE m.conversation_id = c.id AND m.sender_id != ?1 AND m.is_read = false)
+	FROM conversation c
+	JOIN user u ON u.id = CASE WHEN c.user_a = ?1 THEN c.user_b ELSE c.user_a END
+	WHERE c.user_a = ?1 OR c.user_b = ?1
+	ORDER BY c.updated_at DESC, c.id DESC
+	`
+	Select_Messages = `
+	SELECT m.id, m.sender_id, u.name, m.content, m.created_at
+	FROM message m
+	JOIN user u ON u.id = m.sender_id
+	WHERE m.conversation_id = ?
+	ORDER BY m.id
+	`
+	Mark_Messages_Read    = `UPDATE message SET is_read = true WHERE conversation_id = ? AND sender_id != ? AND is_read = false`
+	Count_Unread_Messages = `
+	SELECT COUNT(*)
+	FROM message m
+	JOIN conversation c ON c.id = m.conversation_id
+	WHERE (c.user_a = ?1 OR c.user_b = ?1) AND m.sender_id != ?1 AND m.is_read = false
+	`
+	Count_Block  = `SELECT COUNT(*) FROM block WHERE blocker_id = ? AND blocked_id = ?`
+	Insert_Block = `INSERT OR IGNORE INTO block (blocker_id, blocked_id) VALUES (?, ?)`
+	Delete_Block = `DELETE FROM block WHERE blocker_id = ? AND blocked_id = ?`
+)
+
+// for roles
+const (
+	Select_User_Role_ByName = `SELECT id, role FROM user WHERE name = ?`
+)
+
+// for webhooks, events holds the names of the events of a webhook separated by commas
+const (
+	Insert_Webhook            = `INSERT INTO webhook (url, secret, events) VALUES (?, ?, ?)`
+	Delete_Webhook            = `DELETE FROM webhook WHERE id = ?`
+	Delete_Webhook_Deliveries = `DELETE FROM webhook_delivery WHERE webhook_id = ?`
+	Select_Webhooks           = `SELECT id, url, secret, events, created_at FROM webhook ORDER BY id`
+	Select_Webhook_Deliveries = `
+	SELECT d.id, w.url, d.event, d.status, d.attempts, d.response_code, d.error, d.created_at
+	FROM webhook_delivery d
+	JOIN webhook w ON w.id = d.webhook_id
+	ORDER BY d.id DESC
+	LIMIT 50
+	`
+	// ?1 event, ?2 payload: one delivery for every webhook subscribed to the event
+	Insert_Webhook_Deliveries = `
+	INSERT INTO webhook_delivery (webhook_id, event, payload)
+	SELECT id, ?1, ?2 FROM webhook WHERE instr(',' || events || ',', ',' || ?1 || ',') > 0
+	`
+	Select_Due_Deliveries = `
+	SELECT d.id, d.event, d.payload, d.attempts, d.created_at, w.url, w.secret
+	FROM webhook_delivery d
+	JOIN webhook w ON w.id = d.webhook_id
+	WHERE d.status = 'pending' AND d.next_attempt_at <= ?
+	ORDER BY d.id
+	LIMIT 100
+	`
+	Update_Delivery_Done = `
+	UPDATE webhook_delivery SET status = ?, attempts = attempts + 1, response_code = ?, error = '',
+		delivered_at = CURRENT_TIMESTAMP
+	WHERE id = ?
+	`
+	Update_Delivery_Failed = `
+	UPDATE webhook_delivery SET status = ?, attempts = attempts + 1, response_code = ?, error = ?, next_attempt_at = ?
+	WHERE id = ?
+	`
+	Delete_Old_Deliveries = `DELETE FROM webhook_delivery WHERE status != 'pending' AND created_at < ?`
+)
+
+// for the chat rooms, one per category
+const (
+	Select_Rooms         = `SELECT id, type FROM category ORDER BY id`
+	Select_Room          = `SELECT id, type FROM category WHERE id = ?`
+	Insert_Chat_Message  = `INSERT INTO chat_message (category_id, user_id, content) VALUES (?, ?, ?)`
+	Select_Chat_Backfill = `
+	SELECT id, name, content, created_at FROM (
+		SELECT m.id, u.name, m.content, m.created_at
+		FROM chat_message m
+		JOIN user u ON u.id = m.user_id
+		WHERE m.category_id = ?
+		ORDER BY m.id DESC
+		LIMIT ?
+	) ORDER BY id
+	`
+	Upsert_Chat_Mute = `
+	INSERT INTO chat_mute (category_id, user_id, until) VALUES (?, ?, ?)
+	ON CONFLICT (category_id, user_id) DO UPDATE SET until = excluded.until
+	`
+	Select_Chat_Mute = `SELECT until FROM chat_mute WHERE category_id = ? AND user_id = ?`
+	Delete_Chat_Mute = `DELETE FROM chat_mute WHERE category_id = ? AND user_id = ?`
+	Upsert_Chat_Ban  = `
+	INSERT INTO chat_ban (category_id, user_id, until) VALUES (?, ?, ?)
+	ON CONFLICT (category_id, user_id) DO UPDATE SET until = excluded.until
+	`
+	Select_Chat_Ban = `SELECT until FROM chat_ban WHERE category_id = ? AND user_id = ?`
 )
@@ -1,45 +1,21 @@
 package functions
 
 import (
-	"fmt"
+	"log/slog"
 	"net/http"
 	"strings"
 )
 
-// Reaction handles like/dislike actions, validates user/session/CSRF, and redirects back to the source page.
+// Reaction handles like/dislike actions and redirects back to the source page.
 func (database Database) Reaction(w http.ResponseWriter, r *http.Request) {
-	if r.URL.Path != "/reaction/" {
-		RenderError(w, errPageNotFound, 404)
-		return
-	}
-
-	if r.Method != http.MethodPost {
-		RenderError(w, errMethodNotAllowed, 405)
-		return
-	}
-
-	storedToken, userID, err := authenticateUser(r, database.Db)
-	if userID == -1 {
-		RenderError(w, "please try later", 500)
-		return
-	}
-
-	if err != nil {
-		http.Redirect(w, r, "/login", http.StatusSeeOther)
-		return
-	}
-
-	if !ValidCSRF(r, storedToken) {
-		RenderError(w, "Forbidden: CSRF Token Invalid", http.StatusForbidden)
-		return
-	}
+	user := CurrentUser(r)
 
 	target := strings.TrimSpace(r.FormValue("target"))
 	id := strings.TrimSpace(r.FormValue("id"))
 	reactionType := strings.TrimSpace(r.FormValue("type"))
 
 	if reactionType != "like" && reactionType != "dislike" {
-		fmt.Println("unknown reaction", err)
+		slog.DebugContext(r.Context(), "unknown reaction", "type", reactionType)
 		RenderError(w, "bad request", 400)
 		return
 	}
@@ -49,9 +25,10 @@ func (database Database) Reaction(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = HandleReaction(database.Db, userID, targetId, target, reactionType)
+	err := HandleReaction(database.Db, database.Hub, user.Id, targetId, target, reactionType)
 	if err != nil {
 		RenderError(w, "please try later", 500)
+		return
 	}
 
 	Redirect(target, targetId, w, r, database.Db)
@@ -8,6 +8,7 @@ import (
 	"errors"
 	"fmt"
 	"html/template"
+	"log/slog"
 	"net/http"
 	"regexp"
 	"strconv"
@@ -23,57 +24,41 @@ const (
 	errPleaseTryLater   = "Please try later"
 )
 
-// authenticateUser verifies the session cookie and returns the stored CSRF token + user ID.
-func authenticateUser(r *http.Request, db *sql.DB) (string, int, error) {
-	cookie, err := r.Cookie("session")
-	if err != nil {
-		return "", 0, fmt.Errorf("session cookie not found: %w", err)
-	}
-
-	var userID int
-	var storedToken string
-	err = db.QueryRow(Select_UserID_and_Session, cookie.Value).Scan(&userID, &storedToken)
-	if err == sql.ErrNoRows {
-		return "", 0, fmt.Errorf("invalid or expired session: %w", err)
-	}
-
-	if err != nil {
-		fmt.Println("cannot get the user ID", err)
-		return "", -1, err
-	}
+// isValidComment validates comment content (size, emptiness, printable chars).
+func isValidComment(content string, limits ContentLimits) error {
+	return isValidText(content, "comment", limits.MaxComment)
+}
 
-	return storedToken, userID, nil
+// isValidMessage applies the rules of comments to direct and chat messages.
+func isValidMessage(content string, limits ContentLimits) error {
+	return isValidText(content, "message", limits.MaxMessage)
 }
 
+// isValidText checks a comment or a message, kind is used in the error messages.
+func isValidText(content, kind string, max int) error {
+	if strings.TrimSpace(content) == "" {
+		return errors.New(kind + " must not be empty")
+	}
 
-// extractPostID parses a /posts/{id} path and returns the numeric post ID.
-func extractPostID(path string) (int, error) {
-	id := strings.TrimPrefix(path, "/posts/")
-	if id == "" {
-		return 0, fmt.Errorf("missing post ID")
+	if len(content) > max {
+		return fmt.Errorf("maximum characters for a %s is %d", kind, max)
 	}
 
-	postID, err := strconv.Atoi(id)
-	if err != nil {
-		return 0, fmt.Errorf("invalid post ID: %w", err)
+	if !IsPrintableText(content) {
+		return errors.New("only printable characters are allowed")
 	}
 
-	return postID, nil
+	return nil
 }
-// isValidComment validates comment content (size, emptiness, printable chars).
-func isValidComment(content string) error {
-	if strings.TrimSpace(content) == "" {
-		return errors.New("comment must not be empty")
-	}
 
-	if len(content) > 1000 {
-		return errors.New("maximum characters for a comment is 1000")
+// isValidBio validates the profile biography (size and printable chars), an empty bio is allowed.
+func isValidBio(bio string, limits ContentLimits) error {
+	if len(bio) > limits.MaxBio {
+		return fmt.Errorf("maximum characters for a bio is %d", limits.MaxBio)
 	}
 
-	for _, ch := range content {
-		if !unicode.IsPrint(ch) {
-			return errors.New("only printable characters are allowed")
-		}
+	if !IsPrintable(bio) {
+		return errors.New("only printable characters are allowed")
 	}
 
 	return nil
@@ -81,9 +66,9 @@ func isValidComment(content string) error {
 
 // ExecuteTemplate parses and executes an HTML template with a buffer-safe write.
 func ExecuteTemplate(w http.ResponseWriter, filename string, data any, statutsCode int) {
-	tmpl, err := template.ParseFiles("templates/" + filename)
+	tmpl, err := template.New(filename).Funcs(templateFuncs(w)).ParseFiles("templates/" + filename)
 	if err != nil {
-		fmt.Printf("error while parsing %v: %v\n", filename, err)
+		slog.Error("failed to parse template", "template", filename, "err", err, "request_id", RequestID(w))
 		RenderError(w, "please try later", 500)
 		return
 	}
@@ -92,7 +77,7 @@ func ExecuteTemplate(w http.ResponseWriter, filename string, data any, statutsCo
 
 	err1 := tmpl.Execute(&buff, data)
 	if err1 != nil {
-		fmt.Printf("error while executing %v: %v\n", filename, err1)
+		slog.Error("failed to execute template", "template", filename, "err", err1, "request_id", RequestID(w))
 		RenderError(w, "please try later", 500)
 		return
 	}
@@ -101,7 +86,7 @@ func ExecuteTemplate(w http.ResponseWriter, filename string, data any, statutsCo
 
 	_, err2 := buff.WriteTo(w)
 	if err2 != nil {
-		fmt.Printf("buffer error with %v: %v\n", filename, err2)
+		slog.Error("failed to write template", "template", filename, "err", err2, "request_id", RequestID(w))
 		RenderError(w, "please try later", 500)
 		return
 	}
@@ -118,6 +103,17 @@ func IsPrintable(data string) bool {
 	return true
 }
 
+// IsPrintableText is IsPrintable for multi-line content: line breaks and tabs are allowed too.
+func IsPrintableText(data string) bool {
+	for _, ch := range data {
+		if !unicode.IsPrint(ch) && ch != '\n' && ch != '\r' && ch != '\t' {
+			return false
+		}
+	}
+
+	return true
+}
+
 // GenerateToken returns a cryptographically secure random hex token.
 func GenerateToken() (string, error) {
 	bytes := make([]byte, 32)
@@ -129,14 +125,14 @@ func GenerateToken() (string, error) {
 }
 
 // SetNewSession creates a new session + CSRF token and stores them in DB and cookie.
-func SetNewSession(w http.ResponseWriter, db *sql.DB, userID int) error {
+func SetNewSession(w http.ResponseWriter, r *http.Request, db *sql.DB, session SessionConfig, userID int) error {
 	sessionID, err1 := GenerateToken()
 	csrf_token, err2 := GenerateToken()
 	if err1 != nil || err2 != nil {
 		return fmt.Errorf("failed to generate session")
 	}
 
-	expDate := time.Now().Add(24 * time.Hour)
+	expDate := time.Now().Add(session.Lifetime)
 
 	_, err := db.Exec(addCookie, sessionID, csrf_token, userID, expDate)
 	if err != nil {
@@ -149,7 +145,7 @@ func SetNewSession(w http.ResponseWriter, db *sql.DB, userID int) error {
 		Path:     "/",
 		Expires:  expDate,
 		HttpOnly: true,
-		Secure:   false,
+		Secure:   session.SecureCookies || IsHTTPS(r),
 		SameSite: http.SameSiteStrictMode,
 	}
 
@@ -230,6 +226,9 @@ func Redirect(target string, targetId int, w http.ResponseWriter, r *http.Reques
 		case "home":
 			http.Redirect(w, r, "/", http.StatusSeeOther)
 
+		case "saved":
+			http.Redirect(w, r, "/?filter=saved", http.StatusSeeOther)
+
 		case "comment":
 			id := strconv.Itoa(targetId)
 			http.Redirect(w, r, "/posts/"+id, http.StatusSeeOther)
@@ -261,7 +260,7 @@ func getTargetId(target, id string, w http.ResponseWriter, db *sql.DB) int {
 				return targetId
 			}
 
-			fmt.Println("error while confirming comment existance", err)
+			slog.Error("error while confirming comment existance", "err", err, "request_id", RequestID(w))
 			RenderError(w, "you reacted on a non-existing comment", 400)
 			return targetId
 		}
@@ -283,7 +282,7 @@ func getTargetId(target, id string, w http.ResponseWriter, db *sql.DB) int {
 				return targetId
 			}
 
-			fmt.Println("error while confirming post existance", err)
+			slog.Error("error while confirming post existance", "err", err, "request_id", RequestID(w))
 			RenderError(w, errPageNotFound, 404)
 			return targetId
 		}
@@ -291,7 +290,7 @@ func getTargetId(target, id string, w http.ResponseWriter, db *sql.DB) int {
 		targetId = postId
 
 	default:
-		fmt.Println("react to unknown")
+		slog.Debug("react to unknown", "target", target)
 		RenderError(w, "You can only react to post or comment", 400)
 		return targetId
 	}
@@ -299,59 +298,28 @@ func getTargetId(target, id string, w http.ResponseWriter, db *sql.DB) int {
 	return targetId
 }
 
-// InitializeData loads session info (username, CSRF) and returns homepage data.
-func InitializeData(w http.ResponseWriter, r *http.Request, db *sql.DB) (string, HomePageData, int, error) {
+// InitializeData returns the homepage data of user: their name and unread counts, nothing for a visitor.
+func InitializeData(db *sql.DB, user *User) HomePageData {
 	var data HomePageData
-	var user_id int
-	var token, user_name string
-
-	cookie, err := r.Cookie("session")
-
-	switch err {
-
-	case nil: 
-		Session_ID := cookie.Value
-
-		err1 := db.QueryRow(Select_UserId_Csrf_UserName, Session_ID).Scan(&user_id, &token, &user_name)
-
-		if err1 == sql.ErrNoRows { 
-			_, err2 := db.Exec(Delete_Session_by_ID, Session_ID)
-			if err2 != nil {
-				fmt.Println(err2)
-				RenderError(w, "please try later", 500)
-				return "", HomePageData{}, -1, err2
-			}
-
-			RemoveCookie(w)
-
-			return "", HomePageData{}, -1, nil
-		}
-
-		if err1 != nil { 
-			fmt.Println("a")
-			fmt.Println(err1)
-			RenderError(w, "please try later", 500)
-			return "", HomePageData{}, -1, err1
-		}
-
-		data.UserName = user_name
-
-	case http.ErrNoCookie: 
-
+	if user == nil {
+		return data
 	}
 
-	return token, data, user_id, nil
+	data.UserName = user.Name
+	data.Unread = unreadCount(db, user.Id)
+	data.UnreadMessages = unreadMessagesCount(db, user.Id)
+	return data
 }
 
 // RemoveCookie deletes the session cookie from the user's browser.
-func RemoveCookie(w http.ResponseWriter) {
+func RemoveCookie(w http.ResponseWriter, r *http.Request, session SessionConfig) {
 	deleteCookie := &http.Cookie{
 		Name:     "session",
 		Value:    "",
 		Path:     "/",
 		Expires:  time.Now().Add(-1 * time.Hour),
 		HttpOnly: true,
-		Secure:   false,
+		Secure:   session.SecureCookies || IsHTTPS(r),
 	}
 
 	http.SetCookie(w, deleteCookie)
@@ -362,7 +330,7 @@ func AreValidCategories(categories []string) bool {
 	if len(categories) == 0 {
 		return true
 	}
-	fmt.Println("categories: ", categories)
+	slog.Debug("categories", "categories", categories)
 	allowed := map[string]bool{
 		"Technology": true,
 		"Science":    true,
@@ -394,8 +362,10 @@ func getPostCountData(post *Post, db *sql.DB) error {
 func getPostBasicInfo(postID int, db *sql.DB) (*Post, error) {
 	post := &Post{Id: postID}
 	var createdAt time.Time
+	var html string
+	var htmlRev int
 
-	err := db.QueryRow(Select_Post_Basics, postID).Scan(&post.AuthorId, &post.Title, &post.Content, &createdAt, &post.AuthorName)
+	err := db.QueryRow(Select_Post_Basics, postID).Scan(&post.AuthorId, &post.Title, &post.Content, &html, &htmlRev, &createdAt, &post.AuthorName)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, fmt.Errorf("post not found")
@@ -404,6 +374,26 @@ func getPostBasicInfo(postID int, db *sql.DB) (*Post, error) {
 	}
 
 	post.CreationDate = createdAt.Format("2006 Jan 2 15:04")
+	post.CreatedAt = createdAt
+	post.HTML = template.HTML(html)
+
+	// the cached HTML was rendered by an older renderer (or never), render it once again
+	if htmlRev != markdownRevision {
+		users, err := getMentions(db, post.Id, 0)
+		if err != nil {
+			// without the mentions the HTML would miss links, it is not cached
+			slog.Error("failed to load post mentions", "err", err)
+			post.HTML = RenderMarkdown(post.Content)
+			return post, nil
+		}
+
+		post.HTML, _ = RenderContent(post.Content, users)
+
+		_, err = db.Exec(Update_Post_HTML, string(post.HTML), markdownRevision, post.Id)
+		if err != nil {
+			slog.Error("failed to cache post html", "err", err)
+		}
+	}
 
 	return post, nil
 }
@@ -440,14 +430,20 @@ func getPostComments(post *Post, db *sql.DB, storedToken string, userID int) err
 
 	defer rows.Close()
 
+	stale := map[int]bool{}
+
 	for rows.Next() {
 		newcomment := Comment{}
 		createdAt := time.Time{}
+		var html string
+		var htmlRev int
 
 		err := rows.Scan(
 			&newcomment.Id,
 			&newcomment.AuthorId,
 			&newcomment.Content,
+			&html,
+			&htmlRev,
 			&createdAt,
 			&newcomment.AuthorName,
 			&newcomment.Likes,
@@ -467,9 +463,43 @@ func getPostComments(post *Post, db *sql.DB, storedToken string, userID int) err
 			newcomment.Liked = 0
 		}
 		newcomment.CreationDate = createdAt.Format("2006 Jan 2 15:04")
+		newcomment.CreatedAt = createdAt
+		newcomment.HTML = template.HTML(html)
 
 		post.Comments = append(post.Comments, newcomment)
 
+		// the cached HTML was rendered by an older renderer (or never)
+		if htmlRev != markdownRevision {
+			stale[newcomment.Id] = true
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	// the rows must be closed before writing, sqlite would see the update as a conflicting write
+	rows.Close()
+
+	for i := range post.Comments {
+		comment := &post.Comments[i]
+		if !stale[comment.Id] {
+			continue
+		}
+
+		users, err := getMentions(db, post.Id, comment.Id)
+		if err != nil {
+			slog.Error("failed to load comment mentions", "err", err)
+			comment.HTML = RenderMarkdown(comment.Content)
+			continue
+		}
+
+		comment.HTML, _ = RenderContent(comment.Content, users)
+
+		_, err = db.Exec(Update_Comment_HTML, string(comment.HTML), markdownRevision, comment.Id)
+		if err != nil {
+			slog.Error("failed to cache comment html", "err", err)
+		}
 	}
 
 	return nil
@@ -516,6 +546,10 @@ func getPost(postId int, db *sql.DB, UserID int) (*Post, error) {
 		if err := getUserReactOnPost(post, db, UserID); err != nil {
 			return nil, err
 		}
+
+		if err := getUserBookmark(post, db, UserID); err != nil {
+			return nil, err
+		}
 	} else {
 		post.Liked = 0
 	}
@@ -544,6 +578,18 @@ func getUserReactOnPost(post *Post, db *sql.DB, UserID int) error {
 
 	return nil
 }
+
+// getUserBookmark checks if the user saved the post.
+func getUserBookmark(post *Post, db *sql.DB, UserID int) error {
+	var saved int
+	if err := db.QueryRow(Select_Bookmarked, post.Id, UserID).Scan(&saved); err != nil {
+		return err
+	}
+
+	post.Bookmarked = saved > 0
+	return nil
+}
+
 // Wanted returns true if the post contains any category the user requested.
 func Wanted(allowed map[string]bool, post *Post) bool {
 	for _, postCategory := range post.Categories {
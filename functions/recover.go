@@ -0,0 +1,35 @@
+package functions
+
+import (
+	"log/slog"
+	"net/http"
+	"runtime/debug"
+)
+
+// Recover turns a panic of a handler into the error page with a 500, and logs it with its stack.
+// It must run inside RequestLog, which tells if the response was already started: the connection
+// is then cut, so the browser doesn't take a half page for a whole one.
+func Recover(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			value := recover()
+			if value == nil {
+				return
+			}
+
+			if value == http.ErrAbortHandler {
+				panic(value)
+			}
+
+			slog.ErrorContext(r.Context(), "panic", "panic", value, "stack", string(debug.Stack()))
+
+			if writer, ok := unwrapTo[*logWriter](w); ok && writer.status != 0 {
+				panic(http.ErrAbortHandler)
+			}
+
+			RenderError(w, errPleaseTryLater, http.StatusInternalServerError)
+		}()
+
+		next.ServeHTTP(w, r)
+	})
+}
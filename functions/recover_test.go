@@ -0,0 +1,77 @@
+package functions
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// newPanicRouter serves /panic with a handler that panics, after writing the start of a page when started is set.
+func newPanicRouter(started bool) *Router {
+	router := NewRouter()
+	router.Use(RequestLog, Recover)
+	router.HandleFunc("GET /panic", func(w http.ResponseWriter, r *http.Request) {
+		if started {
+			w.WriteHeader(http.StatusOK)
+			io.WriteString(w, "<html><body>the start of the page")
+			http.NewResponseController(w).Flush()
+		}
+
+		var page map[string]string
+		page["title"] = "nil map"
+	})
+	return router
+}
+
+func TestRecoverRendersErrorPage(t *testing.T) {
+	w := serve(newPanicRouter(false), nil, http.MethodGet, "/panic", nil)
+
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("a panic answered %d", w.Code)
+	}
+
+	id := w.Header().Get("X-Request-Id")
+	if id == "" {
+		t.Fatal("the response has no X-Request-Id")
+	}
+
+	body := w.Body.String()
+	if !strings.Contains(body, errPleaseTryLater) || !strings.Contains(body, "<code>"+id+"</code>") {
+		t.Errorf("the error page doesn't show the request id %s:\n%s", id, body)
+	}
+}
+
+func TestRecoverAbortsStartedResponse(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/panic", nil)
+	w := httptest.NewRecorder()
+
+	func() {
+		defer func() {
+			if value := recover(); value != http.ErrAbortHandler {
+				t.Errorf("Recover panicked with %v instead of http.ErrAbortHandler", value)
+			}
+		}()
+
+		newPanicRouter(true).ServeHTTP(w, r)
+	}()
+
+	if strings.Contains(w.Body.String(), errPleaseTryLater) {
+		t.Error("the error page was written after the start of the page")
+	}
+
+	// through a real server, the connection is cut and the client can't take the half page for a whole one
+	server := httptest.NewServer(newPanicRouter(true))
+	defer server.Close()
+
+	response, err := http.Get(server.URL + "/panic")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+
+	if _, err := io.ReadAll(response.Body); err == nil {
+		t.Error("the client read a whole response")
+	}
+}
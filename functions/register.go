@@ -3,40 +3,25 @@ package functions
 import (
 	"database/sql"
 	"errors"
-	"fmt"
+	"log/slog"
 	"net/http"
 	"regexp"
 
 	"golang.org/x/crypto/bcrypt"
 )
 
-// Register handles GET and POST logic for the /register route.
+// Register renders the registration page on GET and creates the account on POST.
 func (database Database) Register(w http.ResponseWriter, r *http.Request) {
-	if r.URL.Path != "/register" {
-		RenderError(w, "Page not found", 404)
+	if r.Method == http.MethodGet {
+		ExecuteTemplate(w, "register.html", nil, 200)
 		return
 	}
 
-	switch r.Method {
-
-	case http.MethodGet:
-		if len(r.URL.RawQuery) > 0 {
-			RenderError(w, "Method not allowed", 405)
-			return
-		}
-
-		ExecuteTemplate(w, "register.html", nil, 200)
-
-	case http.MethodPost:
-		HandleRegister(w, r, database.Db)
-
-	default:
-		RenderError(w, "Method not allowed", 405)
-	}
+	HandleRegister(w, r, database.Db, database.Session)
 }
 
 // HandleRegister processes user registration, validates data, inserts the user, and creates a session.
-func HandleRegister(w http.ResponseWriter, r *http.Request, DB *sql.DB) {
+func HandleRegister(w http.ResponseWriter, r *http.Request, DB *sql.DB, session SessionConfig) {
 	var data RegisterData
 
 	data.Username = r.FormValue("username")
@@ -69,7 +54,7 @@ func HandleRegister(w http.ResponseWriter, r *http.Request, DB *sql.DB) {
 	// password encryption
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
 	if err != nil {
-		fmt.Println("Password encryption error:", err)
+		slog.ErrorContext(r.Context(), "password encryption error", "err", err)
 		RenderError(w, "Please try later", 500)
 		return
 	}
@@ -77,7 +62,7 @@ func HandleRegister(w http.ResponseWriter, r *http.Request, DB *sql.DB) {
 	// Enter in database
 	res, err := DB.Exec(Insert_User, data.Username, data.Email, string(hashedPassword))
 	if err != nil {
-		fmt.Println("DB exec error:", err)
+		slog.ErrorContext(r.Context(), "dB exec error", "err", err)
 		RenderError(w, "Please try later", 500)
 		return
 	}
@@ -86,15 +71,15 @@ func HandleRegister(w http.ResponseWriter, r *http.Request, DB *sql.DB) {
 	// select the newUser'ID
 	userID, err := res.LastInsertId()
 	if err != nil {
-		fmt.Println("failed to get new user ID:", err)
+		slog.ErrorContext(r.Context(), "failed to get new user ID", "err", err)
 		RenderError(w, "Please try later", 500)
 		return
 	}
 
 	// creating a new session
-	err = SetNewSession(w, DB, int(userID))
+	err = SetNewSession(w, r, DB, session, int(userID))
 	if err != nil {
-		fmt.Println(err)
+		slog.ErrorContext(r.Context(), "failed to create session", "err", err)
 		RenderError(w, "Please try later", 500)
 		return
 	}
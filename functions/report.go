@@ -0,0 +1,73 @@
+package functions
+
+import (
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+const maxReportReason = 500
+
+// Report files a report of the logged in user against a post, with the reason of the form, and
+// sends it to the webhooks subscribed to report.filed. A user reports a post once, reporting it
+// again changes nothing.
+func (database Database) Report(w http.ResponseWriter, r *http.Request) {
+	user := CurrentUser(r)
+
+	postId := getTargetId("post", strings.TrimSpace(r.FormValue("id")), w, database.Db)
+	if postId < 1 {
+		return
+	}
+
+	reason := strings.TrimSpace(r.FormValue("reason"))
+	if reason == "" {
+		RenderError(w, "tell the moderators why you report this post", 400)
+		return
+	}
+
+	if len(reason) > maxReportReason {
+		RenderError(w, "the reason of a report must be at most 500 bytes", 400)
+		return
+	}
+
+	if err := fileReport(database, postId, user, reason); err != nil {
+		slog.ErrorContext(r.Context(), "failed to file report", "err", err)
+		RenderError(w, errPleaseTryLater, 500)
+		return
+	}
+
+	Redirect("post", postId, w, r, database.Db)
+}
+
+// fileReport stores the report and queues its webhook deliveries together.
+func fileReport(database Database, postId int, user *User, reason string) error {
+	tx, err := database.Db.Begin()
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	result, err := tx.Exec(Insert_Report, postId, user.Id, reason)
+	if err != nil {
+		return err
+	}
+
+	if added, _ := result.RowsAffected(); added == 0 {
+		return nil
+	}
+
+	report := WebhookReport{PostId: postId, Reporter: user.Name, Reason: reason}
+	id, _ := result.LastInsertId()
+	report.Id = int(id)
+
+	if err := tx.QueryRow(Verify_PostID, postId).Scan(&report.PostTitle); err != nil {
+		return err
+	}
+
+	if err := emitWebhook(tx, WebhookReportFiled, report); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
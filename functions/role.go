@@ -0,0 +1,25 @@
+package functions
+
+// the roles of the users, set in the role column of the user table
+const (
+	RoleUser      = "user"
+	RoleModerator = "moderator"
+	RoleAdmin     = "admin"
+)
+
+// isModerator tells if the role can moderate the community, admins are moderators too.
+func isModerator(role string) bool {
+	return role == RoleModerator || role == RoleAdmin
+}
+
+// hasRole tells if a user with the role userRole has role, admins have every role.
+func hasRole(userRole, role string) bool {
+	switch role {
+	case RoleAdmin:
+		return userRole == RoleAdmin
+	case RoleModerator:
+		return isModerator(userRole)
+	}
+
+	return true
+}
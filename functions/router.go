@@ -0,0 +1,100 @@
+package functions
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+)
+
+// Middleware wraps a handler to run code before or after it, like the authentication or the logging.
+type Middleware func(http.Handler) http.Handler
+
+// Chain wraps handler in middleware, the first one runs first.
+func Chain(handler http.Handler, middleware ...Middleware) http.Handler {
+	for i := len(middleware) - 1; i >= 0; i-- {
+		handler = middleware[i](handler)
+	}
+
+	return handler
+}
+
+// Router sends the requests to the handlers registered with ServeMux patterns, like "GET /posts/{id}",
+// and answers with RenderError when no pattern matches the path (404) or the method (405).
+type Router struct {
+	mux     *http.ServeMux
+	handler http.Handler
+}
+
+// NewRouter returns a router without routes nor middleware.
+func NewRouter() *Router {
+	router := &Router{mux: http.NewServeMux()}
+	router.handler = http.HandlerFunc(router.dispatch)
+	return router
+}
+
+// Use wraps every request in middleware, even the ones without a route.
+// The middleware added first runs first.
+func (router *Router) Use(middleware ...Middleware) {
+	router.handler = Chain(router.handler, middleware...)
+}
+
+// HandleFunc registers handler on pattern, inside middleware that only this route uses.
+func (router *Router) HandleFunc(pattern string, handler http.HandlerFunc, middleware ...Middleware) {
+	router.mux.Handle(pattern, Chain(handler, middleware...))
+}
+
+func (router *Router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	router.handler.ServeHTTP(w, r)
+}
+
+// dispatch serves the route of the request, or the error page when there is none.
+func (router *Router) dispatch(w http.ResponseWriter, r *http.Request) {
+	handler, pattern := router.mux.Handler(r)
+	if pattern != "" {
+		// ServeHTTP matches again to set the path values
+		router.mux.ServeHTTP(w, r)
+		return
+	}
+
+	// the mux answers 404, or 405 with the methods of the path in Allow
+	probe := &statusProbe{header: http.Header{}}
+	handler.ServeHTTP(probe, r)
+
+	if probe.code == http.StatusMethodNotAllowed {
+		w.Header().Set("Allow", probe.header.Get("Allow"))
+		RenderError(w, errMethodNotAllowed, http.StatusMethodNotAllowed)
+		return
+	}
+
+	RenderError(w, errPageNotFound, http.StatusNotFound)
+}
+
+// statusProbe records the status of a response and throws its body away.
+type statusProbe struct {
+	header http.Header
+	code   int
+}
+
+func (probe *statusProbe) Header() http.Header {
+	return probe.header
+}
+
+func (probe *statusProbe) Write(body []byte) (int, error) {
+	return len(body), nil
+}
+
+func (probe *statusProbe) WriteHeader(code int) {
+	probe.code = code
+}
+
+var errInvalidID = errors.New("invalid id")
+
+// pathID returns the {name} wildcard of the route as an id, a positive number.
+func pathID(r *http.Request, name string) (int, error) {
+	id, err := strconv.Atoi(r.PathValue(name))
+	if err != nil || id < 1 {
+		return 0, errInvalidID
+	}
+
+	return id, nil
+}
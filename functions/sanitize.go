@@ -0,0 +1,132 @@
+package functions
+
+import (
+	"html"
+	"regexp"
+	"strings"
+)
+
+// allowedTags lists the HTML elements SanitizeHTML keeps, with the attributes allowed on each.
+var allowedTags = map[string]map[string]bool{
+	"p":          {},
+	"br":         {},
+	"hr":         {},
+	"h3":         {},
+	"h4":         {},
+	"h5":         {},
+	"h6":         {},
+	"strong":     {},
+	"em":         {},
+	"del":        {},
+	"blockquote": {},
+	"ul":         {},
+	"ol":         {"start": true},
+	"li":         {},
+	"pre":        {},
+	"code":       {"class": true},
+	"a":          {"href": true},
+	"span":       {"class": true},
+}
+
+var (
+	htmlTag       = regexp.MustCompile(`^<(/?)([a-zA-Z][a-zA-Z0-9]*)((?:\s+[a-zA-Z-]+(?:="[^"<>]*")?)*)\s*/?>`)
+	htmlAttribute = regexp.MustCompile(`([a-zA-Z-]+)(?:="([^"]*)")?`)
+	htmlEntity    = regexp.MustCompile(`^&(?:#[0-9]{1,7}|#x[0-9a-fA-F]{1,6}|[a-zA-Z][a-zA-Z0-9]{1,31});`)
+	classValue    = regexp.MustCompile(`^(language-[a-z0-9+#-]{1,20}|hl-[a-z]{1,10})$`)
+	digits        = regexp.MustCompile(`^[0-9]{1,9}$`)
+)
+
+// SanitizeHTML keeps only the allowed tags and attributes of s. Everything else is dropped
+// (for tags) or escaped (for stray characters), so the result is safe to use as template.HTML.
+func SanitizeHTML(s string) string {
+	var out strings.Builder
+
+	for i := 0; i < len(s); {
+		switch s[i] {
+		case '<':
+			tag := htmlTag.FindStringSubmatch(s[i:])
+			if tag == nil {
+				out.WriteString("&lt;")
+				i++
+				continue
+			}
+
+			out.WriteString(cleanTag(tag[1] == "/", strings.ToLower(tag[2]), tag[3]))
+			i += len(tag[0])
+
+		case '>':
+			out.WriteString("&gt;")
+			i++
+
+		case '&':
+			if entity := htmlEntity.FindString(s[i:]); entity != "" {
+				out.WriteString(entity)
+				i += len(entity)
+				continue
+			}
+
+			out.WriteString("&amp;")
+			i++
+
+		case '"':
+			out.WriteString("&#34;")
+			i++
+
+		default:
+			out.WriteByte(s[i])
+			i++
+		}
+	}
+
+	return out.String()
+}
+
+// cleanTag rebuilds an allowed tag with its allowed attributes, or returns "" to drop it.
+func cleanTag(closing bool, name, attributes string) string {
+	allowed, ok := allowedTags[name]
+	if !ok {
+		return ""
+	}
+
+	if closing {
+		if name == "br" || name == "hr" {
+			return ""
+		}
+		return "</" + name + ">"
+	}
+
+	var tag strings.Builder
+	tag.WriteString("<" + name)
+
+	for _, attribute := range htmlAttribute.FindAllStringSubmatch(attributes, -1) {
+		key := strings.ToLower(attribute[1])
+		value := html.UnescapeString(attribute[2])
+
+		if !allowed[key] || !validAttribute(key, value) {
+			continue
+		}
+
+		tag.WriteString(" " + key + `="` + html.EscapeString(value) + `"`)
+	}
+
+	if name == "a" {
+		// links to other sites must not get our pages as referrer or ranking
+		tag.WriteString(` rel="nofollow noopener noreferrer"`)
+	}
+
+	tag.WriteString(">")
+	return tag.String()
+}
+
+func validAttribute(key, value string) bool {
+	switch key {
+	case "href":
+		return isSafeURL(value)
+	case "class":
+		return classValue.MatchString(value)
+	case "start":
+		return digits.MatchString(value)
+	}
+
+	return false
+}
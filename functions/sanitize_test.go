@@ -0,0 +1,46 @@
+package functions
+
+import "testing"
+
+func TestSanitizeHTML(t *testing.T) {
+	const rel = ` rel="nofollow noopener noreferrer"`
+
+	tests := []struct {
+		name, source, want string
+	}{
+		{"allowed tags", "<p><strong>a</strong><em>b</em></p>", "<p><strong>a</strong><em>b</em></p>"},
+		{"upper case tags", `<SPAN class="hl-kw">x</SPAN>`, `<span class="hl-kw">x</span>`},
+		{"void tags", "<br/><hr></br>", "<br><hr>"},
+		{"stray characters", `a "b" & c &amp; d > e`, "a &#34;b&#34; &amp; c &amp; d &gt; e"},
+
+		{"script", "<script>alert(1)</script>", "alert(1)"},
+		{"img onerror", `<img src="x" onerror="alert(1)">`, ""},
+		{"unquoted img onerror", "<img src=x onerror=alert(1)>", "&lt;img src=x onerror=alert(1)&gt;"},
+		{"event attribute", `<a href="/ok" onclick="x">x</a>`, `<a href="/ok"` + rel + ">x</a>"},
+		{"style attribute", `<code class="language-go" style="x">`, `<code class="language-go">`},
+
+		{"javascript link", `<a href="javascript:alert(1)">x</a>`, "<a" + rel + ">x</a>"},
+		{"javascript link in mixed case", `<a href="JaVaScRiPt:alert(1)">x</a>`, "<a" + rel + ">x</a>"},
+		{"decimal entity in the scheme", `<a href="&#106;avascript:alert(1)">x</a>`, "<a" + rel + ">x</a>"},
+		{"hex entities in the scheme", `<a href="&#x6A;avascript&colon;alert(1)">x</a>`, "<a" + rel + ">x</a>"},
+		{"tab entity in the scheme", `<a href="java&#9;script:alert(1)">x</a>`, "<a" + rel + ">x</a>"},
+		{"protocol relative link", `<a href="//evil.com">x</a>`, "<a" + rel + ">x</a>"},
+		{"backslash link", `<a href="/\evil.com">x</a>`, "<a" + rel + ">x</a>"},
+		{"tab after the slash", `<a href="/&#9;/evil.com">x</a>`, "<a" + rel + ">x</a>"},
+		{"web link", `<a href="https://a.test/?a=1&amp;b=2">x</a>`, `<a href="https://a.test/?a=1&amp;b=2"` + rel + ">x</a>"},
+
+		{"quote entities breaking out of href", `<a href="/a&quot; onclick=&quot;x">x</a>`, "<a" + rel + ">x</a>"},
+		{"quote entities breaking out of class", `<span class="hl-kw&quot; onclick=&quot;x">x</span>`, "<span>x</span>"},
+		{"bracket inside an attribute", `<p title="a>b">`, "&lt;p title=&#34;a&gt;b&#34;&gt;"},
+		{"unknown class", `<code class="x">`, "<code>"},
+		{"list start", `<ol start="3"></ol><ol start="x">`, `<ol start="3"></ol><ol>`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := SanitizeHTML(test.source); got != test.want {
+				t.Errorf("SanitizeHTML(%q)\n got %q\nwant %q", test.source, got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,160 @@
+package functions
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LimitBody caps the body of the requests to max bytes, or to the limit of their path in uploads.
+func LimitBody(max int64, uploads map[string]int64) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			limit, upload := uploads[r.URL.Path]
+			if !upload {
+				limit = max
+			}
+
+			// most handlers read the form without checking its errors, so refuse what is announced too big
+			if r.ContentLength > limit {
+				RenderError(w, "this request is too big", http.StatusRequestEntityTooLarge)
+				return
+			}
+
+			r.Body = http.MaxBytesReader(w, r.Body, limit)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+type httpsKey struct{}
+
+// Secure marks the requests that came over HTTPS, directly or through one of the trusted proxies
+// with X-Forwarded-Proto, and tells the browsers to stay on HTTPS for hsts.
+func Secure(trusted []netip.Prefix, hsts time.Duration) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.TLS == nil && r.Header.Get("X-Forwarded-Proto") == "https" && fromProxy(r, trusted) {
+				r = r.WithContext(context.WithValue(r.Context(), httpsKey{}, true))
+			}
+
+			if IsHTTPS(r) {
+				w.Header().Set("Strict-Transport-Security", "max-age="+strconv.Itoa(int(hsts.Seconds())))
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// IsHTTPS tells if the browser reached the forum over HTTPS.
+func IsHTTPS(r *http.Request) bool {
+	return r.TLS != nil || r.Context().Value(httpsKey{}) == true
+}
+
+// fromProxy tells if the request was sent by one of the trusted proxies.
+func fromProxy(r *http.Request, trusted []netip.Prefix) bool {
+	remote, err := netip.ParseAddrPort(r.RemoteAddr)
+	if err != nil {
+		return false
+	}
+
+	for _, prefix := range trusted {
+		if prefix.Contains(remote.Addr().Unmap()) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ParseProxies reads a comma separated list of addresses and networks, like "127.0.0.1, 10.0.0.0/8".
+func ParseProxies(list string) ([]netip.Prefix, error) {
+	prefixes := []netip.Prefix{}
+
+	for _, field := range strings.Split(list, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+
+		if !strings.Contains(field, "/") {
+			addr, err := netip.ParseAddr(field)
+			if err != nil {
+				return nil, fmt.Errorf("invalid proxy address %q", field)
+			}
+			prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+
+		prefix, err := netip.ParsePrefix(field)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy network %q", field)
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+
+	return prefixes, nil
+}
+
+// RedirectToHTTPS sends the browsers to the same page on the HTTPS listener at httpsAddr.
+func RedirectToHTTPS(httpsAddr string) http.Handler {
+	_, port, _ := net.SplitHostPort(httpsAddr)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if name, _, err := net.SplitHostPort(host); err == nil {
+			host = name
+		}
+
+		if port != "443" {
+			host = net.JoinHostPort(host, port)
+		}
+
+		// 308 keeps the method and the body of forms
+		code := http.StatusPermanentRedirect
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			code = http.StatusMovedPermanently
+		}
+
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), code)
+	})
+}
+
+// CertReloader serves a certificate that can be read again from its files without restarting the server.
+type CertReloader struct {
+	CertFile string
+	KeyFile  string
+
+	mu   sync.RWMutex
+	cert *tls.Certificate
+}
+
+// Reload reads the certificate and its key, the previous certificate is kept when they are invalid.
+func (reloader *CertReloader) Reload() error {
+	cert, err := tls.LoadX509KeyPair(reloader.CertFile, reloader.KeyFile)
+	if err != nil {
+		return err
+	}
+
+	reloader.mu.Lock()
+	reloader.cert = &cert
+	reloader.mu.Unlock()
+
+	return nil
+}
+
+// GetCertificate is the tls.Config callback returning the last certificate loaded.
+func (reloader *CertReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	reloader.mu.RLock()
+	defer reloader.mu.RUnlock()
+
+	return reloader.cert, nil
+}
@@ -0,0 +1,67 @@
+package functions
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"strings"
+)
+
+// LoadSecret returns the key signing the links sent by email, it is generated on the first start
+// and kept in the setting table so the links stay valid across restarts.
+func LoadSecret(db *sql.DB) ([]byte, error) {
+	random := make([]byte, 32)
+	if _, err := rand.Read(random); err != nil {
+		return nil, err
+	}
+
+	if _, err := db.Exec(Insert_Setting, "signing_secret", hex.EncodeToString(random)); err != nil {
+		return nil, err
+	}
+
+	var secret string
+	if err := db.QueryRow(Select_Setting, "signing_secret").Scan(&secret); err != nil {
+		return nil, err
+	}
+
+	return hex.DecodeString(secret)
+}
+
+// signToken joins fields with ":" and appends their HMAC-SHA256, for links that act without a session.
+func signToken(secret []byte, fields ...string) string {
+	payload := strings.Join(fields, ":")
+
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(payload))
+
+	return base64.RawURLEncoding.EncodeToString([]byte(payload)) + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// verifyToken returns the fields of a token made by signToken, ok is false when the token was altered.
+func verifyToken(secret []byte, token string) ([]string, bool) {
+	encoded, signature, found := strings.Cut(token, ".")
+	if !found {
+		return nil, false
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, false
+	}
+
+	sum, err := base64.RawURLEncoding.DecodeString(signature)
+	if err != nil {
+		return nil, false
+	}
+
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(payload)
+	if !hmac.Equal(sum, mac.Sum(nil)) {
+		return nil, false
+	}
+
+	return strings.Split(string(payload), ":"), true
+}
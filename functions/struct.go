@@ -1,9 +1,30 @@
 package functions
 
-import "database/sql"
+import (
+	"database/sql"
+	"encoding/json"
+	"html/template"
+	"time"
+)
 
 type Database struct {
-	Db *sql.DB
+	Db          *sql.DB
+	Blobs       BlobStore
+	Attachments AttachmentLimits
+	Content     ContentLimits
+	Session     SessionConfig
+	Hub         *Hub
+	Secret      []byte // signs the links sent by email
+	AvatarDir   string // where the resized avatars are stored
+	BaseURL     string // of the absolute links, like https://agora.example
+}
+
+// User is the logged in user of a request, see CurrentUser.
+type User struct {
+	Id    int
+	Name  string
+	Role  string
+	Token string // the CSRF token of the session
 }
 
 type Reaction struct {
@@ -14,34 +35,55 @@ type Reaction struct {
 }
 
 type HomePageData struct {
-	UserName string
-	Filter   string
-	Posts    []Post
-	Token    string
+	UserName       string
+	Filter         string
+	Posts          []Post
+	Token          string
+	Unread         int
+	UnreadMessages int
 }
 
 type CommentPageData struct {
-	UserName    string
-	Error       string
-	Post        Post
-	Token       string
-	PrevContent string
+	UserName       string
+	Error          string
+	Post           Post
+	Token          string
+	PrevContent    string
+	Unread         int
+	UnreadMessages int
+	Content        ContentLimits
 }
 
 type Post struct {
-	Id            int
-	Title         string
-	Content       string
-	AuthorName    string
-	AuthorId      int
-	CreationDate  string
-	Categories    []string
-	CommentNumber int
-	Comments      []Comment
-	Likes         int
-	Dislikes      int
-	Liked         int // -1 : dislike;  0 : nothing; 1 : like
-	Token         string
+	Id              int
+	Title           string
+	Content         string
+	HTML            template.HTML
+	AuthorName      string
+	AuthorId        int
+	CreationDate    string
+	CreatedAt       time.Time
+	Categories      []string
+	CommentNumber   int
+	Comments        []Comment
+	Likes           int
+	Dislikes        int
+	Liked           int // -1 : dislike;  0 : nothing; 1 : like
+	Bookmarked      bool
+	Subscribed      bool // to the discussion, only loaded on the post page
+	SubscribedEmail bool
+	Token           string
+	Attachments     []Attachment
+}
+
+type Attachment struct {
+	Id       int
+	Name     string
+	Mime     string
+	Size     int64
+	BlobKey  string
+	IsImage  bool
+	SizeText string
 }
 
 type RegisterData struct {
@@ -55,7 +97,9 @@ type Comment struct {
 	AuthorId     int
 	AuthorName   string
 	Content      string
+	HTML         template.HTML
 	CreationDate string
+	CreatedAt    time.Time
 	Likes        int
 	Dislikes     int
 	Token        string
@@ -63,15 +107,18 @@ type Comment struct {
 }
 
 type MY_Post struct {
-	Title    string
-	Content  string
-	Category []string
+	Title       string
+	Content     string
+	Category    []string
+	Attachments []Attachment
 }
 
 type PostPageData struct {
 	ErrorMessege error
 	Post         MY_Post
 	CSRFToken    string
+	Limits       AttachmentLimits
+	Content      ContentLimits
 }
 
 type ReactionData struct {
@@ -81,11 +128,410 @@ type ReactionData struct {
 }
 
 type ErrorPage struct {
-	Code    int
-	Message string
+	Code      int
+	Message   string
+	RequestID string // to quote when reporting the error
 }
 
 type LoginData struct {
 	Message  string
 	Username string
 }
+
+type AccountPageData struct {
+	UserName       string
+	Email          string
+	Bio            string
+	Token          string
+	Message        string
+	Unread         int
+	UnreadMessages int
+	Content        ContentLimits
+
+	Digest           string // off, daily or weekly
+	DigestCategories []DigestChoice
+}
+
+type DigestChoice struct {
+	Id      int
+	Name    string
+	Checked bool
+}
+
+type DigestData struct {
+	UserName       string
+	Frequency      string
+	BaseURL        string
+	UnsubscribeURL string
+	Categories     []DigestCategory
+	Replies        []DigestReply
+	Discussions    []DigestDiscussion
+}
+
+type DigestCategory struct {
+	Id    int
+	Name  string
+	Posts []DigestPost
+}
+
+type DigestPost struct {
+	Id       int
+	Title    string
+	Author   string
+	Likes    int
+	Comments int
+}
+
+type DigestReply struct {
+	PostId    int
+	PostTitle string
+	Author    string
+	Excerpt   string
+}
+
+type DigestDiscussion struct {
+	PostId         int
+	Title          string
+	Comments       int
+	UnsubscribeURL string
+}
+
+type UserProfile struct {
+	Id           int
+	Name         string
+	JoinDate     string
+	Bio          string
+	PostCount    int
+	CommentCount int
+	Likes        int
+	Dislikes     int
+	NetReactions int
+	Followers    int
+	Following    int
+}
+
+type ProfilePageData struct {
+	UserName       string
+	Token          string
+	Profile        UserProfile
+	Followed       bool // the logged in user follows the profile
+	Posts          []Post
+	Page           int
+	PrevPage       int
+	NextPage       int
+	Unread         int
+	UnreadMessages int
+}
+
+type DataExport struct {
+	ExportedAt    string               `json:"exported_at"`
+	Profile       ExportProfile        `json:"profile"`
+	Posts         []ExportPost         `json:"posts"`
+	Comments      []ExportComment      `json:"comments"`
+	Reactions     []ExportReaction     `json:"reactions"`
+	Bookmarks     []ExportBookmark     `json:"bookmarks"`
+	Following     []ExportFollow       `json:"following"`
+	Subscriptions []ExportSubscription `json:"subscriptions"`
+	Messages      []ExportMessage      `json:"messages"`
+	Chat          []ExportChat         `json:"chat_messages"`
+}
+
+type ExportProfile struct {
+	Id    int    `json:"id"`
+	Name  string `json:"name"`
+	Email string `json:"email"`
+	Bio   string `json:"bio"`
+
+	Digest           string   `json:"digest"`
+	DigestCategories []string `json:"digest_categories"`
+}
+
+type ExportPost struct {
+	Id          int                `json:"id"`
+	Title       string             `json:"title"`
+	Content     string             `json:"content"`
+	Categories  []string           `json:"categories"`
+	Attachments []ExportAttachment `json:"attachments"`
+	CreatedAt   string             `json:"created_at"`
+}
+
+type ExportAttachment struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+	Size int64  `json:"size"`
+	URL  string `json:"url"`
+}
+
+type ExportComment struct {
+	Id        int    `json:"id"`
+	PostId    int    `json:"post_id"`
+	Content   string `json:"content"`
+	CreatedAt string `json:"created_at"`
+}
+
+type ExportReaction struct {
+	PostId    int    `json:"post_id,omitempty"`
+	CommentId int    `json:"comment_id,omitempty"`
+	Like      bool   `json:"like"`
+	CreatedAt string `json:"created_at"`
+}
+
+type ExportBookmark struct {
+	PostId    int    `json:"post_id"`
+	CreatedAt string `json:"created_at"`
+}
+
+type ExportSubscription struct {
+	PostId    int    `json:"post_id"`
+	Email     bool   `json:"email"`
+	CreatedAt string `json:"created_at"`
+}
+
+type ExportFollow struct {
+	Name      string `json:"name"`
+	CreatedAt string `json:"created_at"`
+}
+
+type UnsubscribePageData struct {
+	Token  string
+	Title  string
+	Digest bool // the link stops the email digest, not a post subscription
+	Done   bool
+}
+
+type Notification struct {
+	Id           int
+	Kind         string
+	ActorName    string
+	PostId       int
+	PostTitle    string
+	CommentId    int
+	IsRead       bool
+	CreationDate string
+}
+
+type NotificationPreference struct {
+	Kind    string
+	Label   string
+	Enabled bool
+}
+
+type NotificationsPageData struct {
+	UserName       string
+	Token          string
+	Unread         int
+	UnreadMessages int
+	Notifications  []Notification
+	Preferences    []NotificationPreference
+}
+
+type LiveComment struct {
+	Id     int    `json:"id"`
+	PostId int    `json:"post_id"`
+	Author string `json:"author"`
+	HTML   string `json:"html"`
+	Date   string `json:"date"`
+}
+
+type LiveCounts struct {
+	Target   string `json:"target"`
+	Id       int    `json:"id"`
+	Likes    int    `json:"likes"`
+	Dislikes int    `json:"dislikes"`
+	Comments int    `json:"comments,omitempty"`
+}
+
+type LivePost struct {
+	Id     int    `json:"id"`
+	Title  string `json:"title"`
+	Author string `json:"author"`
+}
+
+type ExportMessage struct {
+	To        string `json:"to"`
+	Content   string `json:"content"`
+	CreatedAt string `json:"created_at"`
+}
+
+type ExportChat struct {
+	Room      string `json:"room"`
+	Content   string `json:"content"`
+	CreatedAt string `json:"created_at"`
+}
+
+type Conversation struct {
+	Id          int
+	With        string
+	LastMessage string
+	Unread      int
+	UpdatedAt   string
+}
+
+type Message struct {
+	Id           int
+	AuthorName   string
+	Mine         bool
+	HTML         template.HTML
+	CreationDate string
+}
+
+type InboxPageData struct {
+	UserName       string
+	Token          string
+	Unread         int
+	UnreadMessages int
+	Conversations  []Conversation
+	Error          string
+}
+
+type ThreadPageData struct {
+	UserName       string
+	Token          string
+	Unread         int
+	UnreadMessages int
+	With           string
+	Messages       []Message
+	Blocked        bool // the user blocked the other participant
+	BlockedBy      bool // the other participant blocked the user
+	Error          string
+	PrevContent    string
+	Content        ContentLimits
+}
+
+type ChatRoom struct {
+	Id   int
+	Name string
+}
+
+type ChatPageData struct {
+	UserName       string
+	Token          string
+	Unread         int
+	UnreadMessages int
+	Rooms          []ChatRoom
+	Room           ChatRoom
+	Moderator      bool
+	Content        ContentLimits
+}
+
+// ChatEvent is what the chat sends to the browsers, as JSON.
+type ChatEvent struct {
+	Type    string `json:"type"` // message, system, error or kicked
+	Id      int    `json:"id,omitempty"`
+	Author  string `json:"author,omitempty"`
+	Content string `json:"content,omitempty"`
+	Date    string `json:"date,omitempty"`
+	UserId  int    `json:"-"` // the user targeted by a kick
+}
+
+// ChatCommand is what the browsers send to the chat, as JSON.
+type ChatCommand struct {
+	Type    string `json:"type"` // message, kick, mute or unmute
+	Content string `json:"content"`
+	User    string `json:"user"`
+	Minutes int    `json:"minutes"`
+}
+
+type Webhook struct {
+	Id        int
+	URL       string
+	Secret    string
+	Events    []string
+	CreatedAt string
+}
+
+type WebhookDelivery struct {
+	Id           int
+	URL          string
+	Event        string
+	Status       string // pending, delivered or failed
+	Attempts     int
+	ResponseCode int
+	Error        string
+	CreatedAt    string
+}
+
+type WebhooksPageData struct {
+	UserName       string
+	Token          string
+	Unread         int
+	UnreadMessages int
+	Events         []string
+	Webhooks       []Webhook
+	Deliveries     []WebhookDelivery
+	Error          string
+	PrevURL        string
+}
+
+// WebhookPayload is the JSON body posted to the webhooks.
+type WebhookPayload struct {
+	Id        int             `json:"id"` // of the delivery, the same on every retry
+	Event     string          `json:"event"`
+	CreatedAt string          `json:"created_at"`
+	Data      json.RawMessage `json:"data"`
+}
+
+type WebhookPost struct {
+	Id         int      `json:"id"`
+	Title      string   `json:"title"`
+	Content    string   `json:"content"`
+	Author     string   `json:"author"`
+	Categories []string `json:"categories"`
+}
+
+type WebhookComment struct {
+	Id        int    `json:"id"`
+	PostId    int    `json:"post_id"`
+	PostTitle string `json:"post_title"`
+	Content   string `json:"content"`
+	Author    string `json:"author"`
+}
+
+type WebhookReaction struct {
+	Target   string `json:"target"` // post or comment
+	Id       int    `json:"id"`
+	PostId   int    `json:"post_id"`
+	User     string `json:"user"`
+	Reaction string `json:"reaction"` // like, dislike or none when it was removed
+	Likes    int    `json:"likes"`
+	Dislikes int    `json:"dislikes"`
+}
+
+type WebhookReport struct {
+	Id        int    `json:"id"`
+	PostId    int    `json:"post_id"`
+	PostTitle string `json:"post_title"`
+	Reporter  string `json:"reporter"`
+	Reason    string `json:"reason"`
+}
+
+// CSPViolation is what a browser reports when the Content-Security-Policy blocked something.
+type CSPViolation struct {
+	DocumentURI        string `json:"document-uri"`
+	ViolatedDirective  string `json:"violated-directive"`
+	EffectiveDirective string `json:"effective-directive"`
+	BlockedURI         string `json:"blocked-uri"`
+	SourceFile         string `json:"source-file"`
+	LineNumber         int    `json:"line-number"`
+	Disposition        string `json:"disposition"` // enforce or report
+	ScriptSample       string `json:"script-sample"`
+}
+
+// CSPReportBody is the application/csp-report format, sent for report-uri.
+type CSPReportBody struct {
+	Report *CSPViolation `json:"csp-report"`
+}
+
+// CSPReportEntry is one report of the application/reports+json format, sent for report-to.
+type CSPReportEntry struct {
+	Type string `json:"type"`
+	Body struct {
+		DocumentURL        string `json:"documentURL"`
+		EffectiveDirective string `json:"effectiveDirective"`
+		BlockedURL         string `json:"blockedURL"`
+		SourceFile         string `json:"sourceFile"`
+		LineNumber         int    `json:"lineNumber"`
+		Disposition        string `json:"disposition"`
+		Sample             string `json:"sample"`
+	} `json:"body"`
+}
@@ -0,0 +1,160 @@
+package functions
+
+import (
+	"database/sql"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Subscription subscribes the user to the discussion of a post or unsubscribes them, and turns the email digest
+// of the discussion on or off. It redirects back to the post.
+func (database Database) Subscription(w http.ResponseWriter, r *http.Request) {
+	user := CurrentUser(r)
+
+	postId := getTargetId("post", strings.TrimSpace(r.FormValue("id")), w, database.Db)
+	if postId < 1 {
+		return
+	}
+
+	var queries []string
+	switch r.FormValue("action") {
+	case "subscribe":
+		queries = []string{Insert_Subscription}
+	case "unsubscribe":
+		queries = []string{Delete_Subscription}
+	case "email":
+		queries = []string{Insert_Subscription, Enable_Subscription_Email}
+	case "no-email":
+		queries = []string{Disable_Subscription_Email}
+	default:
+		RenderError(w, "bad request", 400)
+		return
+	}
+
+	for _, query := range queries {
+		if _, err := database.Db.Exec(query, user.Id, postId); err != nil {
+			slog.ErrorContext(r.Context(), "failed to update subscription", "err", err)
+			RenderError(w, errPleaseTryLater, 500)
+			return
+		}
+	}
+
+	http.Redirect(w, r, "/posts/"+strconv.Itoa(postId), http.StatusSeeOther)
+}
+
+// Unsubscribe removes a subscription, or stops the email digest, from the signed link of an email, without logging in.
+// GET asks for a confirmation, so that mail scanners opening the link don't unsubscribe anyone, and POST unsubscribes:
+// it is also the one-click unsubscribe of the List-Unsubscribe header.
+func (database Database) Unsubscribe(w http.ResponseWriter, r *http.Request) {
+	token := r.FormValue("token")
+	data := UnsubscribePageData{Token: token}
+
+	fields, ok := verifyToken(database.Secret, token)
+	valid := ok && ((len(fields) == 3 && fields[0] == "post") || (len(fields) == 2 && fields[0] == "digest"))
+	if !valid {
+		RenderError(w, "this unsubscribe link is invalid", 400)
+		return
+	}
+
+	userID, err := strconv.Atoi(fields[1])
+	if err != nil {
+		RenderError(w, "this unsubscribe link is invalid", 400)
+		return
+	}
+
+	query, args := Disable_Digest, []any{userID}
+	data.Digest = fields[0] == "digest"
+
+	if !data.Digest {
+		postID, err := strconv.Atoi(fields[2])
+		if err != nil {
+			RenderError(w, "this unsubscribe link is invalid", 400)
+			return
+		}
+
+		err = database.Db.QueryRow(Verify_PostID, postID).Scan(&data.Title)
+		if err != nil && err != sql.ErrNoRows {
+			slog.ErrorContext(r.Context(), "failed to load unsubscribed post", "err", err)
+			RenderError(w, errPleaseTryLater, 500)
+			return
+		}
+
+		query, args = Delete_Subscription, []any{userID, postID}
+	}
+
+	if r.Method == http.MethodPost {
+		if _, err := database.Db.Exec(query, args...); err != nil {
+			slog.ErrorContext(r.Context(), "failed to unsubscribe", "err", err)
+			RenderError(w, errPleaseTryLater, 500)
+			return
+		}
+
+		data.Done = true
+	}
+
+	ExecuteTemplate(w, "unsubscribe.html", data, 200)
+}
+
+// unsubscribeToken signs the link unsubscribing userID from the discussion of postID.
+func unsubscribeToken(secret []byte, userID, postID int) string {
+	return signToken(secret, "post", strconv.Itoa(userID), strconv.Itoa(postID))
+}
+
+// notifySubscribers tells the users subscribed to a post about a new comment on it,
+// the author as a comment on their post and the others as a comment on a discussion they follow.
+func notifySubscribers(db dbExecutor, actorID, postID, commentID int) error {
+	var authorID int
+	if err := db.QueryRow(Select_Post_Author, postID).Scan(&authorID); err != nil {
+		return err
+	}
+
+	rows, err := db.Query(Select_Subscribers, postID)
+	if err != nil {
+		return err
+	}
+
+	subscribers := []int{}
+	for rows.Next() {
+		var userID int
+		if err := rows.Scan(&userID); err != nil {
+			rows.Close()
+			return err
+		}
+		subscribers = append(subscribers, userID)
+	}
+	rows.Close()
+
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, userID := range subscribers {
+		kind := NotifySubscribed
+		if userID == authorID {
+			kind = NotifyComment
+		}
+
+		if err := notify(db, userID, actorID, kind, postID, commentID); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// getUserSubscription checks if the user is subscribed to the post and wants its email digest.
+func getUserSubscription(post *Post, db *sql.DB, UserID int) error {
+	err := db.QueryRow(Select_Subscription, UserID, post.Id).Scan(&post.SubscribedEmail)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	post.Subscribed = true
+	return nil
+}
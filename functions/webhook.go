@@ -0,0 +1,374 @@
+package functions
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// the events sent to the webhooks
+const (
+	WebhookPostCreated     = "post.created"
+	WebhookCommentCreated  = "comment.created"
+	WebhookReactionChanged = "reaction.changed"
+	WebhookReportFiled     = "report.filed"
+)
+
+// webhookEvents are the events a webhook can subscribe to, in the order of the admin page.
+var webhookEvents = []string{WebhookPostCreated, WebhookCommentCreated, WebhookReactionChanged, WebhookReportFiled}
+
+// the status of a delivery
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryFailed    = "failed"
+)
+
+// WebhookWorker delivers the queued webhook events from inside the server process.
+// A delivery that fails is tried again after Backoff, then twice as long after each failure.
+type WebhookWorker struct {
+	Db          *sql.DB
+	Client      *http.Client
+	Interval    time.Duration // between two checks of the deliveries due
+	Backoff     time.Duration // before the first retry
+	MaxAttempts int           // then the delivery is failed
+	KeepLog     time.Duration // the finished deliveries are removed after it
+}
+
+// Run delivers the events due now and then every Interval, until ctx is done.
+// It returns after the delivery in progress when ctx is done, the others wait for the next start.
+func (worker WebhookWorker) Run(ctx context.Context) {
+	ticker := time.NewTicker(worker.Interval)
+	defer ticker.Stop()
+
+	for {
+		worker.DeliverDue(ctx, time.Now())
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// webhookDelivery is a queued event for one webhook.
+type webhookDelivery struct {
+	id        int
+	event     string
+	payload   string
+	attempts  int
+	createdAt time.Time
+	url       string
+	secret    string
+}
+
+// DeliverDue posts every pending delivery whose next attempt is due and saves the outcome, until ctx is done.
+func (worker WebhookWorker) DeliverDue(ctx context.Context, now time.Time) {
+	rows, err := worker.Db.Query(Select_Due_Deliveries, now.UTC().Format(sqliteTime))
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to load webhook deliveries", "err", err)
+		return
+	}
+
+	deliveries := []webhookDelivery{}
+	for rows.Next() {
+		var delivery webhookDelivery
+		if err := rows.Scan(&delivery.id, &delivery.event, &delivery.payload, &delivery.attempts, &delivery.createdAt, &delivery.url, &delivery.secret); err != nil {
+			slog.ErrorContext(ctx, "failed to load webhook deliveries", "err", err)
+			rows.Close()
+			return
+		}
+		deliveries = append(deliveries, delivery)
+	}
+	rows.Close()
+
+	for _, delivery := range deliveries {
+		if ctx.Err() != nil {
+			return
+		}
+
+		code, err := worker.deliver(delivery)
+		if err == nil {
+			_, err = worker.Db.Exec(Update_Delivery_Done, DeliveryDelivered, code, delivery.id)
+			if err != nil {
+				slog.ErrorContext(ctx, "failed to save webhook delivery", "err", err)
+			}
+			continue
+		}
+
+		status := DeliveryPending
+		attempts := delivery.attempts + 1
+		if attempts >= worker.MaxAttempts {
+			status = DeliveryFailed
+		}
+
+		retryAt := now.Add(worker.Backoff << (attempts - 1))
+		message := err.Error()
+		if len(message) > 200 {
+			message = message[:200]
+		}
+
+		_, err = worker.Db.Exec(Update_Delivery_Failed, status, code, message, retryAt.UTC().Format(sqliteTime), delivery.id)
+		if err != nil {
+			slog.ErrorContext(ctx, "failed to save webhook delivery", "err", err)
+		}
+	}
+
+	if _, err := worker.Db.Exec(Delete_Old_Deliveries, now.Add(-worker.KeepLog).UTC().Format(sqliteTime)); err != nil {
+		slog.ErrorContext(ctx, "failed to remove old webhook deliveries", "err", err)
+	}
+}
+
+// deliver posts one event, signed with the secret of its webhook, and returns the response code.
+// Anything but a 2xx response is an error.
+func (worker WebhookWorker) deliver(delivery webhookDelivery) (int, error) {
+	body, err := json.Marshal(WebhookPayload{
+		Id:        delivery.id,
+		Event:     delivery.event,
+		CreatedAt: delivery.createdAt.UTC().Format(time.RFC3339),
+		Data:      json.RawMessage(delivery.payload),
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	request, err := http.NewRequest(http.MethodPost, delivery.url, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("User-Agent", "AGORA-Webhooks")
+	request.Header.Set("X-Agora-Event", delivery.event)
+	request.Header.Set("X-Agora-Delivery", strconv.Itoa(delivery.id))
+	request.Header.Set("X-Agora-Signature", "sha256="+signWebhook(delivery.secret, body))
+
+	response, err := worker.Client.Do(request)
+	if err != nil {
+		return 0, err
+	}
+
+	defer response.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(response.Body, 64<<10))
+
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return response.StatusCode, fmt.Errorf("the webhook answered %s", response.Status)
+	}
+
+	return response.StatusCode, nil
+}
+
+// signWebhook returns the hex HMAC-SHA256 of a body, receivers compute it with their copy of the secret.
+func signWebhook(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// emitWebhook queues an event for every webhook subscribed to it, the worker delivers it later.
+func emitWebhook(db dbExecutor, event string, data any) error {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(Insert_Webhook_Deliveries, event, string(payload))
+	return err
+}
+
+// emitReactionWebhook queues the new reaction of a user on a post or a comment, with the new counts.
+func emitReactionWebhook(db *sql.DB, userID, targetID int, target, reaction string) error {
+	data := WebhookReaction{Target: target, Id: targetID, PostId: targetID, Reaction: reaction}
+
+	if err := db.QueryRow(Select_UserName, userID).Scan(&data.User); err != nil {
+		return err
+	}
+
+	var err error
+	if target == "comment" {
+		err = db.QueryRow(Select_Comment_Counts, targetID).Scan(&data.PostId, &data.Likes, &data.Dislikes)
+	} else {
+		var comments int
+		err = db.QueryRow(Select_Number, targetID, targetID, targetID).Scan(&data.Likes, &data.Dislikes, &comments)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return emitWebhook(db, WebhookReactionChanged, data)
+}
+
+// Webhooks shows the webhooks and their last deliveries on /admin/webhooks, and creates or deletes them.
+// Only admins can see it.
+func (database Database) Webhooks(w http.ResponseWriter, r *http.Request) {
+	user := CurrentUser(r)
+
+	data := WebhooksPageData{
+		UserName:       user.Name,
+		Token:          user.Token,
+		Unread:         unreadCount(database.Db, user.Id),
+		UnreadMessages: unreadMessagesCount(database.Db, user.Id),
+		Events:         webhookEvents,
+	}
+
+	var err error
+
+	code := 200
+
+	if r.Method == http.MethodPost {
+		switch r.FormValue("action") {
+		case "create":
+			data.Error, err = createWebhook(database.Db, strings.TrimSpace(r.FormValue("url")), r.Form["event"])
+		case "delete":
+			id, convErr := strconv.Atoi(r.FormValue("id"))
+			if convErr != nil {
+				RenderError(w, "bad request", 400)
+				return
+			}
+			err = deleteWebhook(database.Db, id)
+		default:
+			RenderError(w, "bad request", 400)
+			return
+		}
+
+		if err != nil {
+			slog.ErrorContext(r.Context(), "failed to update webhooks", "err", err)
+			RenderError(w, errPleaseTryLater, 500)
+			return
+		}
+
+		if data.Error == "" {
+			http.Redirect(w, r, "/admin/webhooks", http.StatusSeeOther)
+			return
+		}
+
+		data.PrevURL = r.FormValue("url")
+		code = 400
+	}
+
+	if err := getWebhooksData(database.Db, &data); err != nil {
+		slog.ErrorContext(r.Context(), "failed to load webhooks", "err", err)
+		RenderError(w, errPleaseTryLater, 500)
+		return
+	}
+
+	ExecuteTemplate(w, "webhooks.html", data, code)
+}
+
+// createWebhook saves a webhook with a new secret, it returns why the form was refused.
+func createWebhook(db *sql.DB, target string, events []string) (string, error) {
+	parsed, err := url.Parse(target)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" || len(target) > 2048 {
+		return "the URL must be an http or https address", nil
+	}
+
+	if len(events) == 0 {
+		return "choose at least one event", nil
+	}
+
+	seen := map[string]bool{}
+	for _, event := range events {
+		known := false
+		for _, name := range webhookEvents {
+			known = known || event == name
+		}
+
+		if !known || seen[event] {
+			return "unknown event", nil
+		}
+		seen[event] = true
+	}
+
+	secret, err := GenerateToken()
+	if err != nil {
+		return "", err
+	}
+
+	_, err = db.Exec(Insert_Webhook, target, secret, strings.Join(events, ","))
+	return "", err
+}
+
+// deleteWebhook removes a webhook and its deliveries, delivered or not.
+func deleteWebhook(db *sql.DB, id int) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(Delete_Webhook_Deliveries, id); err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(Delete_Webhook, id); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// getWebhooksData loads the webhooks and the last deliveries for the admin page.
+func getWebhooksData(db *sql.DB, data *WebhooksPageData) error {
+	rows, err := db.Query(Select_Webhooks)
+	if err != nil {
+		return err
+	}
+
+	data.Webhooks = []Webhook{}
+	for rows.Next() {
+		var webhook Webhook
+		var events string
+		var createdAt time.Time
+
+		if err := rows.Scan(&webhook.Id, &webhook.URL, &webhook.Secret, &events, &createdAt); err != nil {
+			rows.Close()
+			return err
+		}
+
+		webhook.Events = strings.Split(events, ",")
+		webhook.CreatedAt = createdAt.Format("2006 Jan 2 15:04")
+		data.Webhooks = append(data.Webhooks, webhook)
+	}
+	rows.Close()
+
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	rows, err = db.Query(Select_Webhook_Deliveries)
+	if err != nil {
+		return err
+	}
+
+	defer rows.Close()
+
+	data.Deliveries = []WebhookDelivery{}
+	for rows.Next() {
+		var delivery WebhookDelivery
+		var createdAt time.Time
+
+		if err := rows.Scan(&delivery.Id, &delivery.URL, &delivery.Event, &delivery.Status, &delivery.Attempts, &delivery.ResponseCode, &delivery.Error, &createdAt); err != nil {
+			return err
+		}
+
+		delivery.CreatedAt = createdAt.Format("2006 Jan 2 15:04")
+		data.Deliveries = append(data.Deliveries, delivery)
+	}
+
+	return rows.Err()
+}
@@ -0,0 +1,256 @@
+package functions
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// webhookReceiver is the endpoint of a webhook: it records the requests and answers with the next code of codes,
+// then with the last one.
+type webhookReceiver struct {
+	mu       sync.Mutex
+	codes    []int
+	requests []*http.Request
+	bodies   [][]byte
+}
+
+func (receiver *webhookReceiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+
+	receiver.mu.Lock()
+	defer receiver.mu.Unlock()
+
+	code := receiver.codes[min(len(receiver.requests), len(receiver.codes)-1)]
+	receiver.requests = append(receiver.requests, r)
+	receiver.bodies = append(receiver.bodies, body)
+
+	w.WriteHeader(code)
+}
+
+func (receiver *webhookReceiver) count() int {
+	receiver.mu.Lock()
+	defer receiver.mu.Unlock()
+
+	return len(receiver.requests)
+}
+
+// newWebhookTest creates a webhook subscribed to event that posts to a receiver answering codes,
+// and returns the worker delivering to it with the secret of the webhook.
+func newWebhookTest(t *testing.T, database Database, event string, codes ...int) (WebhookWorker, *webhookReceiver, string) {
+	t.Helper()
+
+	receiver := &webhookReceiver{codes: codes}
+	server := httptest.NewServer(receiver)
+	t.Cleanup(server.Close)
+
+	if problem, err := createWebhook(database.Db, server.URL+"/hook", []string{event}); problem != "" || err != nil {
+		t.Fatalf("creating the webhook: %q, %v", problem, err)
+	}
+
+	var secret string
+	if err := database.Db.QueryRow(`SELECT secret FROM webhook`).Scan(&secret); err != nil {
+		t.Fatal(err)
+	}
+
+	worker := WebhookWorker{
+		Db:          database.Db,
+		Client:      server.Client(),
+		Interval:    time.Second,
+		Backoff:     time.Minute,
+		MaxAttempts: 3,
+		KeepLog:     24 * time.Hour,
+	}
+
+	return worker, receiver, secret
+}
+
+// webhookDeliveryLog returns the deliveries of the admin page.
+func webhookDeliveryLog(t *testing.T, database Database) []WebhookDelivery {
+	t.Helper()
+
+	var data WebhooksPageData
+	if err := getWebhooksData(database.Db, &data); err != nil {
+		t.Fatal(err)
+	}
+
+	return data.Deliveries
+}
+
+func TestWebhookDeliverySigned(t *testing.T) {
+	database := newTestDatabase(t)
+	worker, receiver, secret := newWebhookTest(t, database, WebhookPostCreated, http.StatusNoContent)
+
+	if err := emitWebhook(database.Db, WebhookPostCreated, map[string]any{"id": 7, "title": "hello"}); err != nil {
+		t.Fatal(err)
+	}
+	// not subscribed, never sent
+	if err := emitWebhook(database.Db, WebhookCommentCreated, map[string]any{"id": 8}); err != nil {
+		t.Fatal(err)
+	}
+
+	worker.DeliverDue(context.Background(), time.Now())
+
+	if receiver.count() != 1 {
+		t.Fatalf("the receiver got %d requests, want 1", receiver.count())
+	}
+
+	request, body := receiver.requests[0], receiver.bodies[0]
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	if signature := request.Header.Get("X-Agora-Signature"); signature != "sha256="+hex.EncodeToString(mac.Sum(nil)) {
+		t.Errorf("X-Agora-Signature is %q, it doesn't sign the body with the secret", signature)
+	}
+
+	if event := request.Header.Get("X-Agora-Event"); event != WebhookPostCreated {
+		t.Errorf("X-Agora-Event is %q", event)
+	}
+
+	var payload WebhookPayload
+	if err := json.Unmarshal(body, &payload); err != nil {
+		t.Fatal(err)
+	}
+	if payload.Event != WebhookPostCreated || string(payload.Data) != `{"id":7,"title":"hello"}` {
+		t.Errorf("the payload is %s", body)
+	}
+
+	deliveries := webhookDeliveryLog(t, database)
+	if len(deliveries) != 1 {
+		t.Fatalf("the log has %d deliveries, want 1", len(deliveries))
+	}
+
+	if delivery := deliveries[0]; delivery.Status != DeliveryDelivered || delivery.ResponseCode != http.StatusNoContent || delivery.Attempts != 1 {
+		t.Errorf("the delivery is logged as %+v", delivery)
+	}
+
+	worker.DeliverDue(context.Background(), time.Now().Add(time.Hour))
+	if receiver.count() != 1 {
+		t.Errorf("a delivered event was sent again")
+	}
+}
+
+func TestWebhookRetriesThenFails(t *testing.T) {
+	database := newTestDatabase(t)
+	worker, receiver, _ := newWebhookTest(t, database, WebhookPostCreated, http.StatusInternalServerError, http.StatusBadGateway, http.StatusServiceUnavailable)
+
+	if err := emitWebhook(database.Db, WebhookPostCreated, map[string]any{"id": 7}); err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	codes := []int{http.StatusInternalServerError, http.StatusBadGateway, http.StatusServiceUnavailable}
+
+	for attempts := 1; attempts <= worker.MaxAttempts; attempts++ {
+		worker.DeliverDue(context.Background(), now)
+
+		if receiver.count() != attempts {
+			t.Fatalf("the receiver got %d requests at the attempt %d", receiver.count(), attempts)
+		}
+
+		delivery := webhookDeliveryLog(t, database)[0]
+		if delivery.ResponseCode != codes[attempts-1] || delivery.Attempts != attempts || !strings.Contains(delivery.Error, "answered") {
+			t.Errorf("the attempt %d is logged as %+v", attempts, delivery)
+		}
+
+		if attempts == worker.MaxAttempts {
+			if delivery.Status != DeliveryFailed {
+				t.Errorf("the delivery is %q after %d attempts", delivery.Status, attempts)
+			}
+			break
+		}
+
+		if delivery.Status != DeliveryPending {
+			t.Errorf("the delivery is %q after %d attempts", delivery.Status, attempts)
+		}
+
+		var next time.Time
+		if err := database.Db.QueryRow(`SELECT next_attempt_at FROM webhook_delivery`).Scan(&next); err != nil {
+			t.Fatal(err)
+		}
+
+		retryAt := now.Add(worker.Backoff << (attempts - 1)).Truncate(time.Second)
+		if !next.Equal(retryAt) {
+			t.Errorf("the attempt %d is retried at %s, want %s", attempts, next, retryAt.UTC())
+		}
+
+		// not due a second before
+		worker.DeliverDue(context.Background(), retryAt.Add(-time.Second))
+		if receiver.count() != attempts {
+			t.Errorf("the attempt %d was retried before its time", attempts)
+		}
+
+		now = retryAt
+	}
+
+	worker.DeliverDue(context.Background(), now.Add(24*time.Hour))
+	if receiver.count() != worker.MaxAttempts {
+		t.Errorf("a failed delivery was sent again")
+	}
+}
+
+func TestWebhookReportFiled(t *testing.T) {
+	database := newTestDatabase(t)
+	worker, receiver, _ := newWebhookTest(t, database, WebhookReportFiled, http.StatusOK)
+	alice := addTestUser(t, database, "alice")
+	bob := addTestUser(t, database, "bob")
+
+	result, err := database.Db.Exec(Insert_Post, alice.Id, "a spam post", "content", "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	postID, _ := result.LastInsertId()
+	id := strconv.Itoa(int(postID))
+
+	router := newTestRouter(database)
+	router.HandleFunc("POST /report/{$}", database.Report, RequireLogin)
+
+	form := func(reason string) url.Values {
+		return url.Values{"id": {id}, "reason": {reason}, "redirect": {"comment"}}
+	}
+
+	if w := serve(router, &bob, http.MethodPost, "/report/", form("  ")); w.Code != http.StatusBadRequest {
+		t.Errorf("a report without reason answered %d", w.Code)
+	}
+
+	for range 2 {
+		w := serve(router, &bob, http.MethodPost, "/report/", form("it sells pills"))
+		if w.Code != http.StatusSeeOther || w.Header().Get("Location") != "/posts/"+id {
+			t.Fatalf("the report answered %d to %q", w.Code, w.Header().Get("Location"))
+		}
+	}
+
+	worker.DeliverDue(context.Background(), time.Now())
+
+	// reporting twice files one report
+	if receiver.count() != 1 {
+		t.Fatalf("the receiver got %d requests, want 1", receiver.count())
+	}
+
+	if event := receiver.requests[0].Header.Get("X-Agora-Event"); event != WebhookReportFiled {
+		t.Errorf("X-Agora-Event is %q", event)
+	}
+
+	var payload struct {
+		Data WebhookReport `json:"data"`
+	}
+	if err := json.Unmarshal(receiver.bodies[0], &payload); err != nil {
+		t.Fatal(err)
+	}
+
+	want := WebhookReport{Id: payload.Data.Id, PostId: int(postID), PostTitle: "a spam post", Reporter: "bob", Reason: "it sells pills"}
+	if payload.Data != want || want.Id == 0 {
+		t.Errorf("the report is sent as %+v, want %+v", payload.Data, want)
+	}
+}
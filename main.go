@@ -1,10 +1,19 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
 	"database/sql"
+	"errors"
+	"flag"
 	"fmt"
+	"log/slog"
 	"net/http"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"syscall"
 
 	"forum/functions"
 
@@ -12,49 +21,261 @@ import (
 )
 
 func main() {
-	if len(os.Args) != 1 {
-		fmt.Println("usage: go run .")
+	config, err := functions.LoadConfig(os.Args[1:], os.Getenv)
+	if errors.Is(err, flag.ErrHelp) {
 		return
 	}
 
-	os.MkdirAll("db", 0o755)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "invalid configuration:", err)
+		os.Exit(2)
+	}
+
+	logger := functions.NewLogger(config.Log, os.Stderr)
+	slog.SetDefault(logger)
 
-	db, err := sql.Open("sqlite3", "db/forum.db")
+	os.MkdirAll(filepath.Dir(config.DBPath), 0o755)
+
+	db, err := sql.Open("sqlite3", config.DBPath)
 	if err != nil {
-		fmt.Println(err)
+		slog.Error("failed to open the database", "err", err)
 		return
 	}
 	defer db.Close()
 
 	err = db.Ping()
 	if err != nil {
-		fmt.Println(err)
+		slog.Error("failed to open the database", "err", err)
 		return
 	}
 
 	_, err = db.Exec(functions.Initialize)
 	if err != nil {
-		fmt.Println(err)
+		slog.Error("failed to create the tables", "err", err)
+		return
+	}
+
+	err = functions.Migrate(db)
+	if err != nil {
+		slog.Error("failed to migrate the database", "err", err)
+		return
+	}
+
+	secret, err := functions.LoadSecret(db)
+	if err != nil {
+		slog.Error("failed to load the secret", "err", err)
 		return
 	}
 
+	// SIGINT or SIGTERM stops the server, the requests in progress and the background jobs finish first
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	var jobs sync.WaitGroup
+
 	database := &functions.Database{
-		Db: db,
+		Db:          db,
+		Blobs:       functions.DiskBlobStore{Root: config.AttachmentDir},
+		Attachments: config.Attachments,
+		Content:     config.Content,
+		Session:     config.Session,
+		Hub:         functions.NewHub(),
+		Secret:      secret,
+		AvatarDir:   config.AvatarDir,
+		BaseURL:     config.BaseURL,
 	}
 
-	http.HandleFunc("/", database.Home)
-	http.HandleFunc("/login", database.Login)
-	http.HandleFunc("/register", database.Register)
-	http.HandleFunc("/logout", database.Logout)
-	http.HandleFunc("/create/post", database.CreatePost)
-	http.HandleFunc("/posts/", database.CreateComment)
-	http.HandleFunc("/reaction/", database.Reaction)
-	http.HandleFunc("/statics/", functions.ServeCss)
-	http.HandleFunc("/assets/", functions.ServeCss)
+	digests := functions.DigestJob{
+		Db:       db,
+		Mailer:   config.Mailer(),
+		Secret:   secret,
+		BaseURL:  config.BaseURL,
+		Interval: config.Digest.Interval,
+	}
+	jobs.Add(1)
+	go func() {
+		defer jobs.Done()
+		digests.Run(ctx)
+	}()
 
-	fmt.Println("server started on http://localhost:8080")
-	err = http.ListenAndServe(":8080", nil)
-	if err != nil {
-		fmt.Println(err)
+	webhooks := functions.WebhookWorker{
+		Db:          db,
+		Client:      &http.Client{Timeout: config.Webhooks.Timeout},
+		Interval:    config.Webhooks.Interval,
+		Backoff:     config.Webhooks.Backoff,
+		MaxAttempts: config.Webhooks.MaxAttempts,
+		KeepLog:     config.Webhooks.KeepLog,
+	}
+	jobs.Add(1)
+	go func() {
+		defer jobs.Done()
+		webhooks.Run(ctx)
+	}()
+
+	// already checked by LoadConfig
+	proxies, _ := functions.ParseProxies(config.Server.TrustedProxies)
+
+	router := functions.NewRouter()
+	router.Use(
+		functions.RequestLog,
+		functions.Recover,
+		functions.Secure(proxies, config.TLS.HSTSMaxAge),
+		functions.SecurityHeaders(config.Security),
+		functions.LimitBody(config.Server.MaxBodySize, map[string]int64{
+			"/create/post":    config.Attachments.MaxRequestSize(),
+			"/account/avatar": functions.AvatarRequestSize,
+		}),
+		database.Authenticate,
+		// the login forms and the signed unsubscribe links don't act for the logged in user
+		functions.CheckCSRF("/login", "/register", "/unsubscribe", "/csp-report"),
+	)
+
+	admin := functions.RequireRole(functions.RoleAdmin)
+
+	router.HandleFunc("GET /{$}", database.Home)
+	router.HandleFunc("GET /login", database.Login)
+	router.HandleFunc("POST /login", database.Login)
+	router.HandleFunc("GET /register", database.Register)
+	router.HandleFunc("POST /register", database.Register)
+	router.HandleFunc("POST /logout", database.Logout)
+	router.HandleFunc("GET /create/post", database.CreatePost, functions.RequireLogin)
+	router.HandleFunc("POST /create/post", database.CreatePost, functions.RequireLogin)
+	router.HandleFunc("GET /posts/{id}", database.CreateComment)
+	router.HandleFunc("POST /posts/{id}", database.CreateComment, functions.RequireLogin)
+	router.HandleFunc("POST /reaction/{$}", database.Reaction, functions.RequireLogin)
+	router.HandleFunc("POST /bookmark/{$}", database.Bookmark, functions.RequireLogin)
+	router.HandleFunc("POST /report/{$}", database.Report, functions.RequireLogin)
+	router.HandleFunc("POST /subscription/{$}", database.Subscription, functions.RequireLogin)
+	router.HandleFunc("GET /unsubscribe", database.Unsubscribe)
+	router.HandleFunc("POST /unsubscribe", database.Unsubscribe)
+	router.HandleFunc("GET /account", database.Account, functions.RequireLogin)
+	router.HandleFunc("GET /account/export", database.AccountExport, functions.RequireLogin)
+	router.HandleFunc("POST /account/delete", database.AccountDelete, functions.RequireLogin)
+	router.HandleFunc("POST /account/bio", database.AccountBio, functions.RequireLogin)
+	router.HandleFunc("POST /account/avatar", database.AccountAvatar, functions.RequireLogin)
+	router.HandleFunc("POST /account/digest", database.AccountDigest, functions.RequireLogin)
+	router.HandleFunc("GET /avatars/{name}/{size}", database.Avatar)
+	router.HandleFunc("GET /attachments/{id}", database.Attachment)
+	router.HandleFunc("GET /users/{name}", database.Profile)
+	router.HandleFunc("POST /users/{name}/follow", database.Follow, functions.RequireLogin)
+	router.HandleFunc("GET /notifications", database.Notifications, functions.RequireLogin)
+	router.HandleFunc("POST /notifications/read", database.NotificationsRead, functions.RequireLogin)
+	router.HandleFunc("POST /notifications/read-all", database.NotificationsReadAll, functions.RequireLogin)
+	router.HandleFunc("POST /notifications/preferences", database.NotificationPreferences, functions.RequireLogin)
+	router.HandleFunc("GET /messages", database.Inbox, functions.RequireLogin)
+	router.HandleFunc("GET /messages/{name}", database.Messages, functions.RequireLogin)
+	router.HandleFunc("POST /messages/{name}", database.Messages, functions.RequireLogin)
+	router.HandleFunc("POST /messages/{name}/block", database.Block, functions.RequireLogin)
+	router.HandleFunc("GET /chat", database.Chat, functions.RequireLogin)
+	router.HandleFunc("GET /chat/{id}", database.Chat, functions.RequireLogin)
+	router.HandleFunc("GET /chat/{id}/ws", database.ChatSocket, functions.RequireLogin)
+	router.HandleFunc("GET /live/home", database.Live)
+	router.HandleFunc("GET /live/posts/{id}", database.Live)
+	router.HandleFunc("GET /feeds/{file}", database.Feed)
+	router.HandleFunc("GET /feeds/{kind}/{file}", database.Feed)
+	router.HandleFunc("GET /admin/webhooks", database.Webhooks, admin)
+	router.HandleFunc("POST /admin/webhooks", database.Webhooks, admin)
+	router.HandleFunc("POST /csp-report", functions.CSPReport)
+	router.HandleFunc("GET /statics/", functions.ServeCss)
+	router.HandleFunc("GET /assets/", functions.ServeCss)
+
+	server := &http.Server{
+		Addr:              config.Addr,
+		Handler:           router,
+		ReadHeaderTimeout: config.Server.ReadHeaderTimeout,
+		ReadTimeout:       config.Server.ReadTimeout,
+		WriteTimeout:      config.Server.WriteTimeout,
+		IdleTimeout:       config.Server.IdleTimeout,
+		MaxHeaderBytes:    config.Server.MaxHeaderBytes,
+		ErrorLog:          slog.NewLogLogger(logger.Handler(), slog.LevelWarn),
 	}
+
+	// Shutdown doesn't wait for the live streams and the chat, they end when the hub closes
+	server.RegisterOnShutdown(database.Hub.Close)
+
+	var redirect *http.Server
+
+	if config.TLS.Enabled() {
+		certificate := &functions.CertReloader{CertFile: config.TLS.CertFile, KeyFile: config.TLS.KeyFile}
+		if err := certificate.Reload(); err != nil {
+			slog.Error("failed to load the certificate", "err", err)
+			return
+		}
+
+		server.TLSConfig = &tls.Config{
+			GetCertificate: certificate.GetCertificate,
+			MinVersion:     tls.VersionTLS12,
+		}
+
+		// SIGHUP reads the certificate again, for the renewals
+		reload := make(chan os.Signal, 1)
+		signal.Notify(reload, syscall.SIGHUP)
+		defer signal.Stop(reload)
+
+		go func() {
+			for range reload {
+				if err := certificate.Reload(); err != nil {
+					slog.Error("failed to reload the certificate, keeping the previous one", "err", err)
+					continue
+				}
+				slog.Info("certificate reloaded")
+			}
+		}()
+
+		if config.TLS.RedirectAddr != "" {
+			redirect = &http.Server{
+				Addr:              config.TLS.RedirectAddr,
+				Handler:           functions.RedirectToHTTPS(config.Addr),
+				ReadHeaderTimeout: config.Server.ReadHeaderTimeout,
+				ReadTimeout:       config.Server.ReadTimeout,
+				WriteTimeout:      config.Server.WriteTimeout,
+				IdleTimeout:       config.Server.IdleTimeout,
+				MaxHeaderBytes:    config.Server.MaxHeaderBytes,
+				ErrorLog:          server.ErrorLog,
+			}
+		}
+	}
+
+	failed := make(chan error, 2)
+	go func() {
+		if config.TLS.Enabled() {
+			slog.Info("server started", "addr", config.Addr, "https", true)
+			failed <- server.ListenAndServeTLS("", "")
+			return
+		}
+
+		slog.Info("server started", "addr", config.Addr)
+		failed <- server.ListenAndServe()
+	}()
+
+	if redirect != nil {
+		go func() {
+			slog.Info("redirecting to HTTPS", "addr", config.TLS.RedirectAddr)
+			failed <- redirect.ListenAndServe()
+		}()
+	}
+
+	select {
+	case err := <-failed:
+		slog.Error("server failed", "err", err)
+	case <-ctx.Done():
+		slog.Info("shutting down")
+	}
+
+	stop()
+
+	shutdown, cancel := context.WithTimeout(context.Background(), config.Server.ShutdownTimeout)
+	defer cancel()
+
+	if err := server.Shutdown(shutdown); err != nil {
+		slog.Warn("requests still in progress were cut", "err", err)
+		server.Close()
+	}
+
+	if redirect != nil {
+		redirect.Close()
+	}
+
+	jobs.Wait()
+	slog.Info("server stopped")
 }
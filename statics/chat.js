@@ -0,0 +1,104 @@
+// Chat room over the WebSocket given in data-stream, reconnecting when the connection drops.
+(function () {
+  var script = document.currentScript;
+  if (!script || !window.WebSocket) {
+    return;
+  }
+
+  var log = document.getElementById("chat-log");
+  var status = document.getElementById("chat-status");
+  var form = document.getElementById("chat-form");
+  var input = document.getElementById("chat-input");
+  var scheme = location.protocol === "https:" ? "wss://" : "ws://";
+  var socket = null;
+  var kicked = false;
+
+  function append(className, author, text, date) {
+    var line = document.createElement("div");
+    line.className = "chat-line " + className;
+
+    if (date) {
+      var time = document.createElement("span");
+      time.className = "chat-date";
+      time.textContent = date;
+      line.appendChild(time);
+    }
+
+    if (author) {
+      var name = document.createElement("a");
+      name.className = "chat-author";
+      name.href = "/users/" + encodeURIComponent(author);
+      name.textContent = author;
+      line.appendChild(name);
+    }
+
+    var content = document.createElement("span");
+    content.className = "chat-text";
+    content.textContent = text;
+    line.appendChild(content);
+
+    var atBottom = log.scrollTop + log.clientHeight >= log.scrollHeight - 20;
+    log.appendChild(line);
+    if (atBottom) {
+      log.scrollTop = log.scrollHeight;
+    }
+  }
+
+  function connect() {
+    socket = new WebSocket(scheme + location.host + script.dataset.stream);
+
+    socket.onopen = function () {
+      log.textContent = "";
+      status.textContent = "";
+    };
+
+    socket.onmessage = function (message) {
+      var event = JSON.parse(message.data);
+
+      if (event.type === "message") {
+        append("", event.author, event.content, event.date);
+      } else if (event.type === "kicked") {
+        kicked = true;
+        append("chat-system", "", event.content);
+      } else {
+        append("chat-" + event.type, "", event.content);
+      }
+    };
+
+    socket.onclose = function () {
+      if (kicked) {
+        status.textContent = "You were kicked from this room, reload the page to join again once the kick is over.";
+        return;
+      }
+
+      status.textContent = "Disconnected, reconnecting...";
+      setTimeout(connect, 3000);
+    };
+  }
+
+  // "/kick name 10", "/mute name 30" and "/unmute name" are moderator commands
+  function command(text) {
+    var parts = text.split(/\s+/);
+    var type = parts[0].slice(1);
+
+    if (text[0] !== "/" || ["kick", "mute", "unmute"].indexOf(type) < 0 || !parts[1]) {
+      return { type: "message", content: text };
+    }
+
+    return { type: type, user: parts[1], minutes: parseInt(parts[2], 10) || 0 };
+  }
+
+  form.addEventListener("submit", function (event) {
+    event.preventDefault();
+
+    var text = input.value.trim();
+    if (!text || !socket || socket.readyState !== WebSocket.OPEN) {
+      return;
+    }
+
+    socket.send(JSON.stringify(command(text)));
+    input.value = "";
+  });
+
+  connect();
+})();
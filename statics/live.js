@@ -0,0 +1,85 @@
+// Live updates of the page from the server-sent events of /live/home or /live/posts/{id}.
+(function () {
+  var script = document.currentScript;
+  if (!script || !window.EventSource) {
+    return;
+  }
+
+  var stream = new EventSource(script.dataset.stream);
+  var newPosts = 0;
+
+  function setCount(key, value) {
+    document.querySelectorAll('[data-live="' + key + '"]').forEach(function (element) {
+      element.textContent = value;
+    });
+  }
+
+  stream.addEventListener("counts", function (event) {
+    var counts = JSON.parse(event.data);
+    var prefix = counts.target + "-" + counts.id + "-";
+
+    setCount(prefix + "likes", counts.likes);
+    setCount(prefix + "dislikes", counts.dislikes);
+    if (counts.target === "post") {
+      setCount(prefix + "comments", counts.comments || 0);
+    }
+  });
+
+  stream.addEventListener("comment", function (event) {
+    var comment = JSON.parse(event.data);
+    var section = document.querySelector(".comments-section");
+    if (!section || document.getElementById("comment-" + comment.id)) {
+      return;
+    }
+
+    var empty = section.querySelector(".no-comments");
+    if (empty) {
+      empty.remove();
+    }
+
+    var article = document.createElement("article");
+    article.className = "comment live-new";
+    article.id = "comment-" + comment.id;
+
+    var header = document.createElement("div");
+    header.className = "comment-header";
+
+    var author = document.createElement("span");
+    author.className = "comment-author";
+
+    var avatar = document.createElement("img");
+    avatar.className = "comment-avatar";
+    avatar.alt = "Avatar";
+    avatar.src = "/avatars/" + encodeURIComponent(comment.author) + "/48";
+
+    var link = document.createElement("a");
+    link.className = "author-link";
+    link.href = "/users/" + encodeURIComponent(comment.author);
+    link.textContent = comment.author;
+
+    var time = document.createElement("span");
+    time.className = "comment-time";
+    time.textContent = comment.date;
+
+    var text = document.createElement("div");
+    text.className = "comment-text markdown";
+    // the HTML was sanitised by the server when the comment was saved
+    text.innerHTML = comment.html;
+
+    author.append(avatar, link);
+    header.append(author, time);
+    article.append(header, text);
+    section.prepend(article);
+  });
+
+  stream.addEventListener("post", function () {
+    var banner = document.getElementById("live-banner");
+    if (!banner) {
+      return;
+    }
+
+    newPosts++;
+    banner.textContent = newPosts === 1 ? "1 new post, click to see it" : newPosts + " new posts, click to see them";
+    banner.hidden = false;
+  });
+})();